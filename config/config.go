@@ -0,0 +1,508 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bilibili-downloader-server/utils"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// 环境变量名，设置后覆盖配置文件中的对应项
+const (
+	EnvConfigFile      = "CONFIG_FILE"
+	EnvCookie          = "BILIBILI_COOKIE"
+	EnvCookieFile      = "BILIBILI_COOKIE_FILE"
+	EnvUserAgent       = "BILIBILI_USER_AGENT"
+	EnvPort            = "PORT"
+	EnvApiKeys         = "API_KEYS"
+	EnvApiTimeout      = "API_TIMEOUT"
+	EnvDownloadTimeout = "DOWNLOAD_TIMEOUT"
+	EnvDefaultQuality  = "DEFAULT_QUALITY"
+	EnvDefaultCodec    = "DEFAULT_CODEC"
+	EnvDefaultFormat   = "DEFAULT_FORMAT"
+	EnvTempDir         = "TEMP_DIR"
+	EnvCacheDir        = "CACHE_DIR"
+	EnvMaxDownloads    = "MAX_CONCURRENT_DOWNLOADS"
+	EnvMaxMerges       = "MAX_CONCURRENT_MERGES"
+	EnvMaxPerIP        = "MAX_CONCURRENT_PER_IP"
+	EnvMinFreeDiskMB   = "MIN_FREE_DISK_MB"
+	EnvLogLevel        = "LOG_LEVEL"
+	EnvShutdownTimeout = "SHUTDOWN_TIMEOUT"
+	EnvTrustedProxies  = "TRUSTED_PROXIES"
+	EnvStaleAfter      = "WORK_DIR_STALE_AFTER"
+	EnvJanitorInterval = "JANITOR_INTERVAL"
+	EnvMaxTranscodes   = "MAX_CONCURRENT_TRANSCODES"
+	EnvCacheTTL        = "CACHE_TTL"
+	EnvEmbedMetadata   = "EMBED_METADATA"
+	EnvFilenameTmpl    = "FILENAME_TEMPLATE"
+	EnvLibraryDir      = "LIBRARY_DIR"
+	EnvMaxJobs         = "MAX_CONCURRENT_JOBS"
+	EnvDatabasePath    = "DATABASE_PATH"
+)
+
+// Duration 支持 "30s"、"5m" 格式的时长，可用于 YAML 和 TOML
+type Duration time.Duration
+
+// UnmarshalText 实现 encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText 实现 encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Config 服务配置
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Bilibili  BilibiliConfig  `yaml:"bilibili" toml:"bilibili"`
+	Download  DownloadConfig  `yaml:"download" toml:"download"`
+	Limits    LimitsConfig    `yaml:"limits" toml:"limits"`
+	Transcode TranscodeConfig `yaml:"transcode" toml:"transcode"`
+	Danmaku   DanmakuConfig   `yaml:"danmaku" toml:"danmaku"`
+	Library   LibraryConfig   `yaml:"library" toml:"library"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+}
+
+// ServerConfig HTTP 服务配置
+type ServerConfig struct {
+	Port            string   `yaml:"port" toml:"port"`                         // 监听端口，修改后需要重启
+	MinFreeDiskMB   int      `yaml:"min_free_disk_mb" toml:"min_free_disk_mb"` // 最小剩余磁盘空间，低于时拒绝新下载且就绪检查失败
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"` // 关闭时等待进行中下载完成的最长时间
+	// TrustedProxies 信任的反向代理 IP 或 CIDR，只有来自这些地址的请求才按 X-Forwarded-For 识别客户端 IP；
+	// 为空时不信任任何代理，使用连接的对端地址。修改后需要重启
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// BilibiliConfig Bilibili 访问配置
+type BilibiliConfig struct {
+	Cookie     string   `yaml:"cookie" toml:"cookie"`           // 账号 Cookie
+	CookieFile string   `yaml:"cookie_file" toml:"cookie_file"` // 从文件读取 Cookie，优先级低于 cookie
+	UserAgent  string   `yaml:"user_agent" toml:"user_agent"`
+	ApiTimeout Duration `yaml:"api_timeout" toml:"api_timeout"`
+}
+
+// DownloadConfig 下载配置
+type DownloadConfig struct {
+	Timeout          Duration `yaml:"timeout" toml:"timeout"`                     // 单个 CDN 文件的下载超时
+	DefaultQuality   int      `yaml:"default_quality" toml:"default_quality"`     // 默认清晰度 qn
+	DefaultCodec     string   `yaml:"default_codec" toml:"default_codec"`         // 默认视频编码偏好：avc/hevc/av1，留空不限制
+	DefaultFormat    string   `yaml:"default_format" toml:"default_format"`       // 默认输出容器：mp4/mkv/webm/mov
+	EmbedMetadata    bool     `yaml:"embed_metadata" toml:"embed_metadata"`       // 默认是否写入标题、UP 主、封面和章节等元数据
+	FilenameTemplate string   `yaml:"filename_template" toml:"filename_template"` // 默认下载文件名模板
+	TempDir          string   `yaml:"temp_dir" toml:"temp_dir"`                   // 工作根目录，留空使用系统临时目录下的 bilibili-downloader
+	CacheDir         string   `yaml:"cache_dir" toml:"cache_dir"`                 // 缓存目录
+	StaleAfter       Duration `yaml:"stale_after" toml:"stale_after"`             // 工作目录超过该时长未修改视为残留并清理
+	JanitorInterval  Duration `yaml:"janitor_interval" toml:"janitor_interval"`   // 残留工作目录的清理间隔
+}
+
+// LimitsConfig 并发限制配置，小于等于 0 表示不限制
+type LimitsConfig struct {
+	Downloads  int `yaml:"downloads" toml:"downloads"`
+	Merges     int `yaml:"merges" toml:"merges"`
+	Transcodes int `yaml:"transcodes" toml:"transcodes"`
+	PerIP      int `yaml:"per_ip" toml:"per_ip"`
+}
+
+// TranscodeConfig 转码配置
+type TranscodeConfig struct {
+	CacheTTL Duration                    `yaml:"cache_ttl" toml:"cache_ttl"` // 缓存的转码结果超过该时长未被访问时删除，0 表示不过期
+	Profiles map[string]TranscodeProfile `yaml:"profiles" toml:"profiles"`   // 转码配置档，键为 profile 参数的取值
+}
+
+// DanmakuConfig 弹幕转换为 ASS 字幕时的默认参数，可以被请求参数覆盖
+type DanmakuConfig struct {
+	Font           string   `yaml:"font" toml:"font"`                       // 字体名称
+	FontSize       int      `yaml:"font_size" toml:"font_size"`             // 标准字号弹幕在 1080p 画布上的像素大小
+	Lanes          int      `yaml:"lanes" toml:"lanes"`                     // 滚动弹幕的轨道数，0 表示铺满整个画面
+	Density        float64  `yaml:"density" toml:"density"`                 // 保留的弹幕比例（0~1），1 表示全部保留
+	Opacity        float64  `yaml:"opacity" toml:"opacity"`                 // 弹幕不透明度（0~1）
+	ScrollDuration Duration `yaml:"scroll_duration" toml:"scroll_duration"` // 滚动弹幕划过画面的时长
+	FixedDuration  Duration `yaml:"fixed_duration" toml:"fixed_duration"`   // 顶部、底部弹幕的显示时长
+}
+
+// LibraryConfig 批量下载任务配置，任务把视频保存到本地视频库目录
+type LibraryConfig struct {
+	Dir     string `yaml:"dir" toml:"dir"`         // 视频库目录，留空时不能创建批量下载任务
+	Jobs    int    `yaml:"jobs" toml:"jobs"`       // 同时执行的批量任务数，小于等于 0 表示不限制
+	History int    `yaml:"history" toml:"history"` // 保留的已结束任务数，超出时删除最早的任务
+	// Database SQLite 数据库路径，记录下载历史和批量任务；留空时不记录，修改后需要重启
+	Database string `yaml:"database" toml:"database"`
+}
+
+// TranscodeProfile 单个转码配置档
+type TranscodeProfile struct {
+	VideoCodec   string `yaml:"video_codec" toml:"video_codec"`     // FFmpeg 视频编码器，如 libx264
+	VideoProfile string `yaml:"video_profile" toml:"video_profile"` // 视频编码 profile，如 baseline
+	Preset       string `yaml:"preset" toml:"preset"`               // 编码速度预设，如 veryfast
+	Height       int    `yaml:"height" toml:"height"`               // 输出最大高度，0 表示保持原分辨率
+	VideoBitrate string `yaml:"video_bitrate" toml:"video_bitrate"` // 视频码率，如 2500k
+	PixelFormat  string `yaml:"pixel_format" toml:"pixel_format"`   // 像素格式，如 yuv420p
+	AudioCodec   string `yaml:"audio_codec" toml:"audio_codec"`     // FFmpeg 音频编码器，如 aac
+	AudioBitrate string `yaml:"audio_bitrate" toml:"audio_bitrate"` // 音频码率，如 128k
+}
+
+// AuthConfig API Key 认证配置
+type AuthConfig struct {
+	ApiKeys []ApiKeyConfig `yaml:"api_keys" toml:"api_keys"`
+}
+
+// ApiKeyConfig 单个 API Key 及其每日配额，0 表示不限制
+type ApiKeyConfig struct {
+	Key            string `yaml:"key" toml:"key"`
+	DailyDownloads int    `yaml:"daily_downloads" toml:"daily_downloads"`
+	DailyBytes     int64  `yaml:"daily_bytes" toml:"daily_bytes"`
+	Admin          bool   `yaml:"admin" toml:"admin"` // 可以查看所有请求方的下载历史
+}
+
+// LogConfig 日志配置
+type LogConfig struct {
+	Level string `yaml:"level" toml:"level"`
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:            "8080",
+			MinFreeDiskMB:   1024,
+			ShutdownTimeout: Duration(60 * time.Second),
+		},
+		Bilibili: BilibiliConfig{
+			ApiTimeout: Duration(30 * time.Second),
+		},
+		Download: DownloadConfig{
+			Timeout:          Duration(300 * time.Second),
+			DefaultQuality:   80,
+			DefaultFormat:    "mp4",
+			EmbedMetadata:    true,
+			FilenameTemplate: "{title} [{bvid}]{page_suffix}{clip_suffix}.{ext}",
+			StaleAfter:       Duration(6 * time.Hour),
+			JanitorInterval:  Duration(10 * time.Minute),
+		},
+		Limits: LimitsConfig{
+			Downloads:  3,
+			Merges:     2,
+			Transcodes: 1,
+			PerIP:      1,
+		},
+		Transcode: TranscodeConfig{
+			CacheTTL: Duration(7 * 24 * time.Hour),
+			Profiles: map[string]TranscodeProfile{
+				// 老旧电视和安卓客户端：H.264 baseline 720p
+				"tv-720p": {
+					VideoCodec:   "libx264",
+					VideoProfile: "baseline",
+					Preset:       "veryfast",
+					Height:       720,
+					VideoBitrate: "2500k",
+					PixelFormat:  "yuv420p",
+					AudioCodec:   "aac",
+					AudioBitrate: "128k",
+				},
+			},
+		},
+		Danmaku: DanmakuConfig{
+			Font:           "Noto Sans CJK SC",
+			FontSize:       50,
+			Lanes:          12,
+			Density:        1,
+			Opacity:        0.8,
+			ScrollDuration: Duration(8 * time.Second),
+			FixedDuration:  Duration(4 * time.Second),
+		},
+		Library: LibraryConfig{
+			Jobs:    1,
+			History: 100,
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+	}
+}
+
+// Load 加载配置：默认值 -> 配置文件 -> 环境变量
+// 参数 path: 配置文件路径，为空时只使用默认值和环境变量；扩展名为 .toml 时按 TOML 解析，否则按 YAML 解析
+// 返回：校验通过的配置和错误信息
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read config file: %w", err)
+		}
+		if err := unmarshal(path, data, cfg); err != nil {
+			return nil, fmt.Errorf("Failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	// 从文件读取 Cookie
+	if cfg.Bilibili.Cookie == "" && cfg.Bilibili.CookieFile != "" {
+		data, err := os.ReadFile(cfg.Bilibili.CookieFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read cookie file: %w", err)
+		}
+		cfg.Bilibili.Cookie = strings.TrimSpace(string(data))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// unmarshal 根据扩展名选择 YAML 或 TOML 解析
+func unmarshal(path string, data []byte, cfg *Config) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		return toml.Unmarshal(data, cfg)
+	default:
+		return yaml.Unmarshal(data, cfg)
+	}
+}
+
+// applyEnv 使用环境变量覆盖配置
+func (c *Config) applyEnv() error {
+	setString(&c.Bilibili.Cookie, EnvCookie)
+	setString(&c.Bilibili.CookieFile, EnvCookieFile)
+	setString(&c.Bilibili.UserAgent, EnvUserAgent)
+	setString(&c.Server.Port, EnvPort)
+	setString(&c.Download.DefaultCodec, EnvDefaultCodec)
+	setString(&c.Download.DefaultFormat, EnvDefaultFormat)
+	setString(&c.Download.FilenameTemplate, EnvFilenameTmpl)
+	setString(&c.Download.TempDir, EnvTempDir)
+	setString(&c.Download.CacheDir, EnvCacheDir)
+	setString(&c.Library.Dir, EnvLibraryDir)
+	setString(&c.Library.Database, EnvDatabasePath)
+	setString(&c.Log.Level, EnvLogLevel)
+
+	for _, item := range []struct {
+		dst  *int
+		name string
+	}{
+		{&c.Download.DefaultQuality, EnvDefaultQuality},
+		{&c.Limits.Downloads, EnvMaxDownloads},
+		{&c.Limits.Merges, EnvMaxMerges},
+		{&c.Limits.Transcodes, EnvMaxTranscodes},
+		{&c.Limits.PerIP, EnvMaxPerIP},
+		{&c.Server.MinFreeDiskMB, EnvMinFreeDiskMB},
+		{&c.Library.Jobs, EnvMaxJobs},
+	} {
+		if err := setInt(item.dst, item.name); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range []struct {
+		dst  *Duration
+		name string
+	}{
+		{&c.Bilibili.ApiTimeout, EnvApiTimeout},
+		{&c.Download.Timeout, EnvDownloadTimeout},
+		{&c.Server.ShutdownTimeout, EnvShutdownTimeout},
+		{&c.Download.StaleAfter, EnvStaleAfter},
+		{&c.Download.JanitorInterval, EnvJanitorInterval},
+		{&c.Transcode.CacheTTL, EnvCacheTTL},
+	} {
+		if err := setDuration(item.dst, item.name); err != nil {
+			return err
+		}
+	}
+
+	if err := setBool(&c.Download.EmbedMetadata, EnvEmbedMetadata); err != nil {
+		return err
+	}
+
+	if v := os.Getenv(EnvTrustedProxies); v != "" {
+		c.Server.TrustedProxies = nil
+		for _, proxy := range strings.Split(v, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				c.Server.TrustedProxies = append(c.Server.TrustedProxies, proxy)
+			}
+		}
+	}
+
+	if v := os.Getenv(EnvApiKeys); v != "" {
+		keys, err := ParseApiKeys(v)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: %w", EnvApiKeys, err)
+		}
+		c.Auth.ApiKeys = keys
+	}
+	return nil
+}
+
+// Validate 校验配置
+func (c *Config) Validate() error {
+	if c.Bilibili.Cookie == "" {
+		return fmt.Errorf("Bilibili cookie must be set (%s, bilibili.cookie or bilibili.cookie_file)", EnvCookie)
+	}
+	if c.Server.Port == "" {
+		return fmt.Errorf("Server port must be set")
+	}
+	if c.Bilibili.ApiTimeout <= 0 || c.Download.Timeout <= 0 || c.Server.ShutdownTimeout <= 0 ||
+		c.Download.StaleAfter <= 0 || c.Download.JanitorInterval <= 0 {
+		return fmt.Errorf("Timeouts must be positive")
+	}
+	if c.Download.DefaultQuality < 1 {
+		return fmt.Errorf("Invalid default quality: %d", c.Download.DefaultQuality)
+	}
+	switch c.Download.DefaultCodec {
+	case "", "avc", "hevc", "av1":
+	default:
+		return fmt.Errorf("Invalid default codec %q, expected avc, hevc or av1", c.Download.DefaultCodec)
+	}
+	switch c.Download.DefaultFormat {
+	case "mp4", "mkv", "webm", "mov":
+	default:
+		return fmt.Errorf("Invalid default format %q, expected mp4, mkv, webm or mov", c.Download.DefaultFormat)
+	}
+	if err := utils.ValidateFilenameTemplate(c.Download.FilenameTemplate); err != nil {
+		return fmt.Errorf("Invalid filename_template: %w", err)
+	}
+	if c.Transcode.CacheTTL < 0 {
+		return fmt.Errorf("Invalid transcode cache_ttl: %s", time.Duration(c.Transcode.CacheTTL))
+	}
+	for name, p := range c.Transcode.Profiles {
+		if name == "" || p.VideoCodec == "" || p.AudioCodec == "" || p.Height < 0 {
+			return fmt.Errorf("Invalid transcode profile %q: video_codec and audio_codec are required", name)
+		}
+	}
+	if c.Danmaku.Font == "" || c.Danmaku.FontSize < 1 || c.Danmaku.Lanes < 0 ||
+		c.Danmaku.Density <= 0 || c.Danmaku.Density > 1 || c.Danmaku.Opacity <= 0 || c.Danmaku.Opacity > 1 {
+		return fmt.Errorf("Invalid danmaku config: font is required, font_size must be positive, density and opacity must be in (0, 1]")
+	}
+	if c.Danmaku.ScrollDuration <= 0 || c.Danmaku.FixedDuration <= 0 {
+		return fmt.Errorf("Danmaku durations must be positive")
+	}
+	if c.Library.History < 1 {
+		return fmt.Errorf("Invalid library history: %d", c.Library.History)
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("Invalid trusted proxy %q, expected an IP address or CIDR", proxy)
+			}
+		}
+	}
+	if c.Server.MinFreeDiskMB < 0 {
+		return fmt.Errorf("Invalid min_free_disk_mb: %d", c.Server.MinFreeDiskMB)
+	}
+	for i, k := range c.Auth.ApiKeys {
+		if k.Key == "" || k.DailyDownloads < 0 || k.DailyBytes < 0 {
+			return fmt.Errorf("Invalid API key entry %d", i+1)
+		}
+	}
+	return nil
+}
+
+// ParseApiKeys 解析 API Key 配置字符串
+// 参数 s: 以逗号分隔的配置项，每项格式为 key[:每日次数[:每日字节数[:admin]]]
+// 返回：ApiKeyConfig 列表和错误信息
+//
+// 例如：key1,key2:100,key3:50:10737418240,key4:::admin
+func ParseApiKeys(s string) ([]ApiKeyConfig, error) {
+	var keys []ApiKeyConfig
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) > 4 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid API key entry %d", len(keys)+1)
+		}
+
+		key := ApiKeyConfig{Key: parts[0]}
+		if len(parts) > 1 && parts[1] != "" {
+			n, err := strconv.Atoi(parts[1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid daily download quota for API key entry %d", len(keys)+1)
+			}
+			key.DailyDownloads = n
+		}
+		if len(parts) > 2 && parts[2] != "" {
+			n, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid daily byte quota for API key entry %d", len(keys)+1)
+			}
+			key.DailyBytes = n
+		}
+		if len(parts) > 3 && parts[3] != "" {
+			if parts[3] != "admin" {
+				return nil, fmt.Errorf("Invalid flag for API key entry %d, expected admin", len(keys)+1)
+			}
+			key.Admin = true
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// setString 环境变量非空时覆盖字符串配置
+func setString(dst *string, name string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = v
+	}
+}
+
+// setInt 环境变量非空时覆盖整数配置
+func setInt(dst *int, name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("Invalid %s=%q: %w", name, v, err)
+	}
+	*dst = n
+	return nil
+}
+
+// setBool 环境变量非空时覆盖布尔配置
+func setBool(dst *bool, name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("Invalid %s=%q: %w", name, v, err)
+	}
+	*dst = b
+	return nil
+}
+
+// setDuration 环境变量非空时覆盖时长配置
+func setDuration(dst *Duration, name string) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	if err := dst.UnmarshalText([]byte(v)); err != nil {
+		return fmt.Errorf("Invalid %s=%q: %w", name, v, err)
+	}
+	return nil
+}
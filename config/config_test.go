@@ -0,0 +1,142 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseApiKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []ApiKeyConfig
+		ok   bool
+	}{
+		{"", nil, true},
+		{" , ", nil, true},
+		{"key1", []ApiKeyConfig{{Key: "key1"}}, true},
+		{"key1, key2:100 ,key3:50:10737418240", []ApiKeyConfig{
+			{Key: "key1"},
+			{Key: "key2", DailyDownloads: 100},
+			{Key: "key3", DailyDownloads: 50, DailyBytes: 10737418240},
+		}, true},
+		{"key1::1024", []ApiKeyConfig{{Key: "key1", DailyBytes: 1024}}, true},
+		{"key1:0:0", []ApiKeyConfig{{Key: "key1"}}, true},
+		{"key1:::admin,key2:10::", []ApiKeyConfig{{Key: "key1", Admin: true}, {Key: "key2", DailyDownloads: 10}}, true},
+		{"key1:5:100:admin", []ApiKeyConfig{{Key: "key1", DailyDownloads: 5, DailyBytes: 100, Admin: true}}, true},
+		{":100", nil, false},
+		{"key1,:5", nil, false},
+		{"key1:abc", nil, false},
+		{"key1:-1", nil, false},
+		{"key1:1.5", nil, false},
+		{"key1:1:abc", nil, false},
+		{"key1:1:-1", nil, false},
+		{"key1:1:1:1:1", nil, false},
+		{"key1:::root", nil, false},
+		{"key1:1:1:admin:extra", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseApiKeys(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseApiKeys(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseApiKeys(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadApiKeys(t *testing.T) {
+	t.Setenv(EnvCookie, "SESSDATA=x")
+	t.Setenv(EnvApiKeys, "")
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	yamlPath := write("config.yaml", `
+auth:
+  api_keys:
+    - key: "a"
+      daily_downloads: 10
+    - key: "b"
+      daily_bytes: 1024
+      admin: true
+`)
+	tomlPath := write("config.toml", `
+[[auth.api_keys]]
+key = "a"
+daily_downloads = 10
+
+[[auth.api_keys]]
+key = "b"
+daily_bytes = 1024
+admin = true
+`)
+	invalidPath := write("invalid.yaml", `
+auth:
+  api_keys:
+    - key: "a"
+      daily_downloads: -1
+`)
+	fromFile := []ApiKeyConfig{{Key: "a", DailyDownloads: 10}, {Key: "b", DailyBytes: 1024, Admin: true}}
+
+	tests := []struct {
+		name string
+		path string
+		env  string
+		want []ApiKeyConfig
+		ok   bool
+	}{
+		{"yaml", yamlPath, "", fromFile, true},
+		{"toml", tomlPath, "", fromFile, true},
+		// 环境变量覆盖配置文件中的整个列表
+		{"env overrides file", yamlPath, "c:1,d:::admin", []ApiKeyConfig{{Key: "c", DailyDownloads: 1}, {Key: "d", Admin: true}}, true},
+		{"env only", "", "c", []ApiKeyConfig{{Key: "c"}}, true},
+		{"invalid env", yamlPath, "c:x", nil, false},
+		{"negative quota in file", invalidPath, "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvApiKeys, tt.env)
+			cfg, err := Load(tt.path)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Load error = %v, want ok %v", err, tt.ok)
+			}
+			if err == nil && !reflect.DeepEqual(cfg.Auth.ApiKeys, tt.want) {
+				t.Errorf("api keys = %+v, want %+v", cfg.Auth.ApiKeys, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv(EnvCookie, "SESSDATA=x")
+	tests := []struct {
+		env  string
+		want []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"127.0.0.1, 10.0.0.0/8 ,::1", []string{"127.0.0.1", "10.0.0.0/8", "::1"}, true},
+		{"localhost", nil, false},
+		{"10.0.0.0/33", nil, false},
+	}
+	for _, tt := range tests {
+		t.Setenv(EnvTrustedProxies, tt.env)
+		cfg, err := Load("")
+		if (err == nil) != tt.ok {
+			t.Errorf("Load with %s=%q error = %v, want ok %v", EnvTrustedProxies, tt.env, err, tt.ok)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(cfg.Server.TrustedProxies, tt.want) {
+			t.Errorf("trusted proxies = %q, want %q", cfg.Server.TrustedProxies, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch 在收到 SIGHUP 或配置文件发生变化时重新加载配置
+// 参数 ctx: 上下文，取消时停止监听
+// 参数 path: 配置文件路径，为空时只响应 SIGHUP
+// 参数 interval: 检查配置文件修改时间的间隔
+// 参数 apply: 重新加载成功后的回调；加载失败时保留旧配置并记录日志
+func Watch(ctx context.Context, path string, interval time.Duration, apply func(*Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastMod := modTime(path)
+	reload := func(reason string) {
+		cfg, err := Load(path)
+		if err != nil {
+			slog.Error("Config reload failed, keeping previous config", "reason", reason, "error", err)
+			return
+		}
+		slog.Info("Config reloaded", "reason", reason, "path", path)
+		apply(cfg)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			lastMod = modTime(path)
+			reload("SIGHUP")
+		case <-ticker.C:
+			if path == "" {
+				continue
+			}
+			if mod := modTime(path); !mod.Equal(lastMod) {
+				lastMod = mod
+				reload("file changed")
+			}
+		}
+	}
+}
+
+// modTime 返回文件修改时间，文件不存在时返回零值
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bilibili-downloader-server/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gin.Context 中保存当前 API Key 和是否为管理员 Key 的键名
+const (
+	apiKeyContextKey      = "apiKey"
+	apiKeyAdminContextKey = "apiKeyAdmin"
+)
+
+// keyUsage 单个 API Key 的当日用量
+type keyUsage struct {
+	day       string
+	downloads int
+	bytes     int64
+}
+
+// Auth API Key 认证与配额管理
+type Auth struct {
+	keys  map[string]config.ApiKeyConfig
+	usage map[string]*keyUsage
+	mu    sync.Mutex
+}
+
+// NewAuth 创建 Auth 实例
+// 参数 keys: 允许访问的 API Key 列表，为空时不启用认证
+// 返回：配置好的 Auth 实例
+func NewAuth(keys []config.ApiKeyConfig) *Auth {
+	a := &Auth{
+		usage: make(map[string]*keyUsage, len(keys)),
+	}
+	a.SetKeys(keys)
+	return a
+}
+
+// SetKeys 热更新 API Key 列表，保留仍然存在的 Key 的当日用量
+// 参数 keys: 允许访问的 API Key 列表，为空时不启用认证
+func (a *Auth) SetKeys(keys []config.ApiKeyConfig) {
+	m := make(map[string]config.ApiKeyConfig, len(keys))
+	for _, k := range keys {
+		m[k.Key] = k
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = m
+	for key := range a.usage {
+		if _, ok := m[key]; !ok {
+			delete(a.usage, key)
+		}
+	}
+}
+
+// Enabled 是否启用了认证
+func (a *Auth) Enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.keys) > 0
+}
+
+// lookup 查找 API Key 配置
+func (a *Auth) lookup(token string) (config.ApiKeyConfig, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key, ok := a.keys[token]
+	return key, ok
+}
+
+// Middleware 返回 API Key 认证中间件
+// 校验 Authorization: Bearer <key>，检查并累计每日下载次数和字节数配额
+// 请求以 4xx/5xx 状态码结束时退还预占的下载次数；未配置任何 API Key 时直接放行
+func (a *Auth) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+
+		key, ok := a.authenticate(c)
+		if !ok {
+			return
+		}
+
+		// 检查配额并预占一次下载次数
+		if err := a.reserve(key); err != nil {
+			c.Header("Retry-After", strconv.Itoa(secondsUntilTomorrow()))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		setKey(c, key)
+		c.Next()
+
+		// 参数错误、视频不存在、排队超时等失败的请求不计入下载次数
+		if c.Writer.Status() >= http.StatusBadRequest {
+			a.release(key.Key)
+		}
+		// 累计实际写出的字节数
+		if size := c.Writer.Size(); size > 0 {
+			a.addBytes(key.Key, int64(size))
+		}
+	}
+}
+
+// Authenticate 返回只校验 API Key、不计入下载配额的认证中间件
+// 用于字幕等体积很小的附属资源，未配置任何 API Key 时直接放行
+func (a *Auth) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+
+		key, ok := a.authenticate(c)
+		if !ok {
+			return
+		}
+		setKey(c, key)
+		c.Next()
+	}
+}
+
+// Quota 返回校验 API Key 并检查当日配额是否已经用完、但不累计下载的认证中间件
+// 用于创建批量任务和订阅的接口：任务在下载每个视频时通过 Charge 按创建任务的 Key 累计配额，
+// 配额已经用完时直接返回 429，不创建任务；未配置任何 API Key 时直接放行
+func (a *Auth) Quota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+
+		key, ok := a.authenticate(c)
+		if !ok {
+			return
+		}
+		if err := a.check(key); err != nil {
+			c.Header("Retry-After", strconv.Itoa(secondsUntilTomorrow()))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		setKey(c, key)
+		c.Next()
+	}
+}
+
+// Check 检查请求方的当日配额是否已经用完，不累计下载
+// 参数 requester: 请求方标识（见 keyRequester），未启用认证时不做限制
+// 返回：配额已经用完或 Key 已被移除时的错误信息
+func (a *Auth) Check(requester string) error {
+	key, err := a.requesterKey(requester)
+	if err != nil || key == nil {
+		return err
+	}
+	return a.check(*key)
+}
+
+// Charge 检查配额并为请求方累计一次下载，用于批量任务中的每个分 P
+// 参数 requester: 请求方标识（见 keyRequester），未启用认证时不做限制
+// 返回：配额已经用完或 Key 已被移除时的错误信息
+func (a *Auth) Charge(requester string) error {
+	key, err := a.requesterKey(requester)
+	if err != nil || key == nil {
+		return err
+	}
+	return a.reserve(*key)
+}
+
+// ChargeBytes 为请求方累计下载字节数，未启用认证或 Key 已被移除时不做任何事
+func (a *Auth) ChargeBytes(requester string, n int64) {
+	key, err := a.requesterKey(requester)
+	if err != nil || key == nil {
+		return
+	}
+	a.addBytes(key.Key, n)
+}
+
+// requesterKey 根据请求方标识查找 API Key，未启用认证时返回 nil
+// 启用认证后请求方必须是仍然存在的 Key，否则（Key 已被移除，或任务创建时还没有启用认证）返回错误
+func (a *Auth) requesterKey(requester string) (*config.ApiKeyConfig, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.keys) == 0 {
+		return nil, nil
+	}
+	for _, key := range a.keys {
+		if keyRequester(key.Key) == requester {
+			return &key, nil
+		}
+	}
+	return nil, fmt.Errorf("API key of %s is no longer configured", requester)
+}
+
+// keyRequester 返回 API Key 对应的请求方标识：key: 加 Key 的 SHA-256 前 8 位，不保存 Key 本身
+func keyRequester(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:4])
+}
+
+// authenticate 校验 Authorization 头，失败时返回 401 并中止请求
+func (a *Auth) authenticate(c *gin.Context) (config.ApiKeyConfig, bool) {
+	token, ok := bearerToken(c.GetHeader("Authorization"))
+	if !ok {
+		c.Header("WWW-Authenticate", `Bearer realm="bilibili-downloader-server"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Missing or malformed Authorization header",
+		})
+		return config.ApiKeyConfig{}, false
+	}
+
+	key, ok := a.lookup(token)
+	if !ok {
+		c.Header("WWW-Authenticate", `Bearer realm="bilibili-downloader-server", error="invalid_token"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid API key",
+		})
+		return config.ApiKeyConfig{}, false
+	}
+	return key, true
+}
+
+// setKey 在 gin.Context 中记录通过认证的 API Key
+func setKey(c *gin.Context, key config.ApiKeyConfig) {
+	c.Set(apiKeyContextKey, key.Key)
+	c.Set(apiKeyAdminContextKey, key.Admin)
+}
+
+// reserve 检查配额并累计一次下载
+func (a *Auth) reserve(key config.ApiKeyConfig) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.checkLocked(key); err != nil {
+		return err
+	}
+	a.usageLocked(key.Key).downloads++
+	return nil
+}
+
+// release 退还一次预占的下载次数
+func (a *Auth) release(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if u := a.usageLocked(key); u.downloads > 0 {
+		u.downloads--
+	}
+}
+
+// check 检查配额是否已经用完
+func (a *Auth) check(key config.ApiKeyConfig) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.checkLocked(key)
+}
+
+// checkLocked 检查配额是否已经用完（调用方需持有锁）
+func (a *Auth) checkLocked(key config.ApiKeyConfig) error {
+	u := a.usageLocked(key.Key)
+	if key.DailyDownloads > 0 && u.downloads >= key.DailyDownloads {
+		return fmt.Errorf("Daily download quota exceeded (%d/%d)", u.downloads, key.DailyDownloads)
+	}
+	if key.DailyBytes > 0 && u.bytes >= key.DailyBytes {
+		return fmt.Errorf("Daily byte quota exceeded (%d/%d)", u.bytes, key.DailyBytes)
+	}
+	return nil
+}
+
+// addBytes 累计下载字节数
+func (a *Auth) addBytes(key string, n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.usageLocked(key).bytes += n
+}
+
+// usageLocked 获取当日用量记录，跨天时自动重置（调用方需持有锁）
+func (a *Auth) usageLocked(key string) *keyUsage {
+	today := time.Now().Format("2006-01-02")
+	u, ok := a.usage[key]
+	if !ok || u.day != today {
+		u = &keyUsage{day: today}
+		a.usage[key] = u
+	}
+	return u
+}
+
+// bearerToken 从 Authorization 头中提取 Bearer Token
+func bearerToken(header string) (string, bool) {
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len(prefix):])
+	return token, token != ""
+}
+
+// secondsUntilTomorrow 计算距离次日零点的秒数，用于 Retry-After
+func secondsUntilTomorrow() int {
+	now := time.Now()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	return int(tomorrow.Sub(now).Seconds()) + 1
+}
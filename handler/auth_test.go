@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bilibili-downloader-server/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAuthRouter 创建挂载 mw 的测试路由：/ok 返回 body，/fail 返回 404
+func newAuthRouter(mw gin.HandlerFunc, body string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ok", mw, func(c *gin.Context) {
+		c.String(http.StatusOK, body)
+	})
+	router.GET("/fail", mw, func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+	})
+	return router
+}
+
+// serveAuth 以 token 请求 path，token 为空时不带 Authorization 头
+func serveAuth(router *gin.Engine, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// usageOf 返回 Key 的当日下载次数和字节数
+func (a *Auth) usageOf(key string) (int, int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.usageLocked(key)
+	return u.downloads, u.bytes
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := NewAuth([]config.ApiKeyConfig{{Key: "secret", DailyDownloads: 2}})
+	router := newAuthRouter(auth.Middleware(), "0123456789")
+
+	if w := serveAuth(router, "/ok", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("missing key: status %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := serveAuth(router, "/ok", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid key: status %d, want 401", w.Code)
+	}
+	if downloads, _ := auth.usageOf("secret"); downloads != 0 {
+		t.Errorf("rejected requests counted: downloads = %d", downloads)
+	}
+
+	// 失败的请求退还预占的下载次数，写出的字节仍然计入
+	var written int64
+	for i := 0; i < 3; i++ {
+		w := serveAuth(router, "/fail", "secret")
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("fail: status %d, want 404", w.Code)
+		}
+		written += int64(w.Body.Len())
+	}
+	if downloads, _ := auth.usageOf("secret"); downloads != 0 {
+		t.Errorf("failed requests counted: downloads = %d, want 0", downloads)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serveAuth(router, "/ok", "secret"); w.Code != http.StatusOK {
+			t.Fatalf("download %d: status %d, want 200", i+1, w.Code)
+		}
+	}
+	downloads, bytes := auth.usageOf("secret")
+	if downloads != 2 || bytes != written+20 {
+		t.Errorf("usage = %d downloads, %d bytes; want 2, %d", downloads, bytes, written+20)
+	}
+
+	w := serveAuth(router, "/ok", "secret")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over quota: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(w.Body.String(), "Daily download quota exceeded") {
+		t.Errorf("over quota: body %s", w.Body)
+	}
+	if downloads, _ := auth.usageOf("secret"); downloads != 2 {
+		t.Errorf("refused request changed usage: downloads = %d, want 2", downloads)
+	}
+}
+
+func TestAuthMiddlewareByteQuota(t *testing.T) {
+	auth := NewAuth([]config.ApiKeyConfig{{Key: "secret", DailyBytes: 15}})
+	router := newAuthRouter(auth.Middleware(), "0123456789")
+
+	// 字节配额在请求结束后累计，超出后下一个请求被拒绝
+	for i := 0; i < 2; i++ {
+		if w := serveAuth(router, "/ok", "secret"); w.Code != http.StatusOK {
+			t.Fatalf("download %d: status %d, want 200", i+1, w.Code)
+		}
+	}
+	if w := serveAuth(router, "/ok", "secret"); w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "Daily byte quota exceeded") {
+		t.Errorf("over byte quota: status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	auth := NewAuth(nil)
+	for _, mw := range []gin.HandlerFunc{auth.Middleware(), auth.Authenticate(), auth.Quota()} {
+		if w := serveAuth(newAuthRouter(mw, "ok"), "/ok", ""); w.Code != http.StatusOK {
+			t.Errorf("auth disabled: status %d, want 200", w.Code)
+		}
+	}
+	if err := auth.Charge("ip:192.0.2.1"); err != nil {
+		t.Errorf("Charge with auth disabled: %v", err)
+	}
+	if err := auth.Check("ip:192.0.2.1"); err != nil {
+		t.Errorf("Check with auth disabled: %v", err)
+	}
+}
+
+func TestAuthQuota(t *testing.T) {
+	auth := NewAuth([]config.ApiKeyConfig{{Key: "secret", DailyDownloads: 1}})
+	router := newAuthRouter(auth.Quota(), "0123456789")
+
+	// Quota 只检查配额，不累计下载次数和字节数
+	for i := 0; i < 3; i++ {
+		if w := serveAuth(router, "/ok", "secret"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, w.Code)
+		}
+	}
+	if downloads, bytes := auth.usageOf("secret"); downloads != 0 || bytes != 0 {
+		t.Errorf("usage = %d downloads, %d bytes; want 0, 0", downloads, bytes)
+	}
+	if w := serveAuth(router, "/ok", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid key: status %d, want 401", w.Code)
+	}
+
+	if err := auth.Charge(keyRequester("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveAuth(router, "/ok", "secret"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over quota: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestAuthChargeByRequester(t *testing.T) {
+	auth := NewAuth([]config.ApiKeyConfig{{Key: "a", DailyDownloads: 2}, {Key: "b"}})
+	a, b := keyRequester("a"), keyRequester("b")
+
+	for i := 0; i < 2; i++ {
+		if err := auth.Charge(a); err != nil {
+			t.Fatalf("charge %d: %v", i, err)
+		}
+	}
+	if err := auth.Check(a); err == nil {
+		t.Error("check after using up the quota should fail")
+	}
+	if err := auth.Charge(a); err == nil {
+		t.Error("charge after using up the quota should fail")
+	}
+	if err := auth.Charge(b); err != nil {
+		t.Errorf("unlimited key: %v", err)
+	}
+	if err := auth.Charge("ip:127.0.0.1"); err == nil {
+		t.Error("requester without a key should be rejected when auth is enabled")
+	}
+
+	// Key 被移除后订阅创建的任务不能继续下载
+	auth.SetKeys([]config.ApiKeyConfig{{Key: "b"}})
+	if err := auth.Charge(a); err == nil {
+		t.Error("removed key should be rejected")
+	}
+
+	// 未启用认证时不限制
+	auth.SetKeys(nil)
+	if err := auth.Charge("ip:127.0.0.1"); err != nil {
+		t.Errorf("auth disabled: %v", err)
+	}
+}
+
+func TestAuthChargeBytes(t *testing.T) {
+	auth := NewAuth([]config.ApiKeyConfig{{Key: "a", DailyBytes: 100}, {Key: "b"}})
+	a, b := keyRequester("a"), keyRequester("b")
+
+	auth.ChargeBytes(a, 60)
+	if err := auth.Charge(a); err != nil {
+		t.Fatalf("charge under byte quota: %v", err)
+	}
+	auth.ChargeBytes(a, 40)
+	if err := auth.Check(a); err == nil {
+		t.Error("check after using up the byte quota should fail")
+	}
+	if downloads, bytes := auth.usageOf("a"); downloads != 1 || bytes != 100 {
+		t.Errorf("usage = %d downloads, %d bytes; want 1, 100", downloads, bytes)
+	}
+
+	// 其他 Key 的用量互不影响
+	auth.ChargeBytes(b, 1000)
+	if err := auth.Check(b); err != nil {
+		t.Errorf("unlimited key: %v", err)
+	}
+
+	// 移除后重新添加的 Key 从零开始计算用量
+	auth.SetKeys([]config.ApiKeyConfig{{Key: "b"}})
+	auth.ChargeBytes(a, 1000)
+	auth.SetKeys([]config.ApiKeyConfig{{Key: "a", DailyBytes: 100}, {Key: "b"}})
+	if err := auth.Check(a); err != nil {
+		t.Errorf("re-added key: %v", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{"Bearer secret", "secret", true},
+		{"bearer  secret ", "secret", true},
+		{"Bearer ", "", false},
+		{"Bearer    ", "", false},
+		{"Basic secret", "", false},
+		{"secret", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := bearerToken(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("bearerToken(%q) = %q, %v; want %q, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
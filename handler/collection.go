@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"bilibili-downloader-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VideoCollection 处理视频所属合集的查询请求
+// GET /bilibili/collection/:id
+// 从视频详细信息中解析 ugc_season，返回合集信息和按小节分组的全部视频，不属于合集时返回 404
+func (h *Handler) VideoCollection(c *gin.Context) {
+	season, ok := h.videoSeason(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, season)
+}
+
+// VideoCollectionDownload 处理视频所属合集的批量下载请求
+// POST /bilibili/collection/:id/download
+// 按合集中的顺序下载全部视频到视频库目录，文件名加上序号前缀
+func (h *Handler) VideoCollectionDownload(c *gin.Context) {
+	if !h.requireLibrary(c) {
+		return
+	}
+	opts, ok := parseJobOptions(c, h.config())
+	if !ok {
+		return
+	}
+	season, ok := h.videoSeason(c)
+	if !ok {
+		return
+	}
+
+	var items []*JobItem
+	seen := map[string]bool{}
+	for _, ep := range season.Episodes() {
+		if seen[ep.Bvid] {
+			continue
+		}
+		seen[ep.Bvid] = true
+		items = append(items, &JobItem{Index: len(items) + 1, Bvid: ep.Bvid, Title: ep.Title})
+	}
+	h.submitCollectionJob(c, fmt.Sprintf("season:%d", season.Id), opts, items)
+}
+
+// videoSeason 解析路径参数中的视频 ID，返回视频所属的合集，失败时写入错误响应
+func (h *Handler) videoSeason(c *gin.Context) (*service.UgcSeason, bool) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing video ID parameter",
+		})
+		return nil, false
+	}
+	bvid, ok := h.resolveBvid(c, id, 1)
+	if !ok {
+		return nil, false
+	}
+
+	info, err := h.apiService.GetVideoInfo(c.Request.Context(), bvid)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get video info: %w", err))
+		return nil, false
+	}
+	if info.UgcSeason == nil {
+		h.handleError(c, service.ErrNotInCollection)
+		return nil, false
+	}
+	return info.UgcSeason, true
+}
+
+// SpaceCollections 处理 UP 主合集和系列列表请求
+// GET /bilibili/space/:mid/collections
+func (h *Handler) SpaceCollections(c *gin.Context) {
+	mid, ok := parseMid(c)
+	if !ok {
+		return
+	}
+	collections, err := h.apiService.ListSpaceCollections(c.Request.Context(), mid)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get collections: %w", err))
+		return
+	}
+	if collections == nil {
+		collections = []service.CollectionMeta{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mid":         mid,
+		"collections": collections,
+	})
+}
+
+// SpaceCollection 处理合集或系列的视频列表请求
+// GET /bilibili/space/:mid/collections/:kind/:collection_id
+// kind 为 season（合集）或 series（系列），返回按合集顺序排列的全部视频
+func (h *Handler) SpaceCollection(c *gin.Context) {
+	collection, ok := h.spaceCollection(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, collection)
+}
+
+// SpaceCollectionDownload 处理合集或系列的批量下载请求
+// POST /bilibili/space/:mid/collections/:kind/:collection_id/download
+// 按合集顺序下载全部视频到视频库目录，文件名加上序号前缀
+func (h *Handler) SpaceCollectionDownload(c *gin.Context) {
+	if !h.requireLibrary(c) {
+		return
+	}
+	opts, ok := parseJobOptions(c, h.config())
+	if !ok {
+		return
+	}
+	collection, ok := h.spaceCollection(c)
+	if !ok {
+		return
+	}
+
+	items := make([]*JobItem, 0, len(collection.Archives))
+	for i, a := range collection.Archives {
+		items = append(items, &JobItem{Index: i + 1, Bvid: a.Bvid, Title: a.Title})
+	}
+	h.submitCollectionJob(c, fmt.Sprintf("%s:%d", collection.Kind, collection.Id), opts, items)
+}
+
+// spaceCollection 解析路径参数，返回合集或系列的全部视频，失败时写入错误响应
+func (h *Handler) spaceCollection(c *gin.Context) (*service.Collection, bool) {
+	mid, ok := parseMid(c)
+	if !ok {
+		return nil, false
+	}
+	kind := service.CollectionKind(c.Param("kind"))
+	if kind != service.CollectionSeason && kind != service.CollectionSeries {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid kind parameter, expected season or series",
+		})
+		return nil, false
+	}
+	id, err := strconv.ParseInt(c.Param("collection_id"), 10, 64)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid collection_id parameter",
+		})
+		return nil, false
+	}
+
+	collection, err := h.apiService.GetCollection(c.Request.Context(), kind, mid, id)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get %s: %w", kind, err))
+		return nil, false
+	}
+	return collection, true
+}
+
+// submitCollectionJob 创建合集下载任务并返回 202
+func (h *Handler) submitCollectionJob(c *gin.Context, source string, opts JobOptions, items []*JobItem) {
+	job := h.submitJob(source, requesterOf(c), opts, items, nil)
+	c.Header("Location", "/bilibili/jobs/"+job.Id)
+	c.JSON(http.StatusAccepted, job)
+}
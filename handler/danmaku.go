@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bilibili-downloader-server/config"
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/service"
+	"bilibili-downloader-server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Danmaku 处理弹幕请求
+// GET /bilibili/danmaku/:id
+// 返回 format（xml/ass）格式的弹幕文件，ASS 格式可以通过 font、font_size、lanes、density 参数调整样式
+func (h *Handler) Danmaku(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing video ID parameter",
+		})
+		return
+	}
+
+	// 获取 URL 参数 p（分 P 页码）和 format（弹幕格式）
+	page, ok := parsePage(c, c.DefaultQuery("p", "1"))
+	if !ok {
+		return
+	}
+	format := strings.ToLower(c.DefaultQuery("format", "xml"))
+	if format != "xml" && format != "ass" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid format parameter, expected one of: ass, xml",
+		})
+		return
+	}
+	opts, ok := danmakuOptions(c, h.config().Danmaku)
+	if !ok {
+		return
+	}
+
+	bvid, ok := h.resolveBvid(c, id, page)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	cid, err := h.apiService.GetCid(ctx, bvid, page)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get CID: %w", err))
+		return
+	}
+
+	info, err := h.apiService.GetVideoInfo(ctx, bvid)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to get video info, fetching first danmaku segment only", "bvid", bvid, "error", err)
+	}
+	list, err := h.apiService.GetDanmaku(ctx, cid, pageDuration(info, page))
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get danmaku: %w", err))
+		return
+	}
+
+	name := fmt.Sprintf("%s.danmaku.%s", bvid, format)
+	if page > 1 {
+		name = fmt.Sprintf("%s_p%d.danmaku.%s", bvid, page, format)
+	}
+	c.Header("Content-Disposition", utils.ContentDisposition(utils.SanitizeFilename(name), bvid+"."+format))
+	if format == "ass" {
+		c.Data(http.StatusOK, "text/x-ssa; charset=utf-8", service.DanmakuToAss(list, opts))
+		return
+	}
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", service.DanmakuToXml(cid, list))
+}
+
+// danmakuOptions 解析 ASS 弹幕参数 font、font_size、lanes 和 density，未指定时使用配置中的默认值
+// 参数 c: Gin 上下文，参数无效时写入 400 响应
+// 参数 cfg: 弹幕配置
+// 返回：弹幕参数和是否有效
+func danmakuOptions(c *gin.Context, cfg config.DanmakuConfig) (service.DanmakuOptions, bool) {
+	opts := service.DanmakuOptions{
+		Font:           c.DefaultQuery("font", cfg.Font),
+		FontSize:       cfg.FontSize,
+		Lanes:          cfg.Lanes,
+		Density:        cfg.Density,
+		Opacity:        cfg.Opacity,
+		ScrollDuration: time.Duration(cfg.ScrollDuration),
+		FixedDuration:  time.Duration(cfg.FixedDuration),
+	}
+
+	if opts.Font == "" || strings.ContainsAny(opts.Font, ",\r\n") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid font parameter",
+		})
+		return opts, false
+	}
+	if v := c.Query("font_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid font_size parameter, expected 1-200",
+			})
+			return opts, false
+		}
+		opts.FontSize = n
+	}
+	if v := c.Query("lanes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid lanes parameter",
+			})
+			return opts, false
+		}
+		opts.Lanes = n
+	}
+	if v := c.Query("density"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid density parameter, expected a number in (0, 1]",
+			})
+			return opts, false
+		}
+		opts.Density = f
+	}
+	return opts, true
+}
+
+// pageDuration 返回分 P 时长，视频信息不可用时返回 0
+func pageDuration(info *service.VideoInfo, page int) time.Duration {
+	if info == nil {
+		return 0
+	}
+	if p, ok := info.Page(page); ok {
+		return time.Duration(p.Duration) * time.Second
+	}
+	return 0
+}
+
+// danmakuSubtitle 获取弹幕并转换为要内嵌到输出文件的 ASS 字幕
+// 弹幕只是附加信息，获取失败时记录日志并跳过
+// 参数 ctx: 上下文
+// 参数 cid: 分 P 的 CID
+// 参数 duration: 分 P 时长
+// 参数 opts: 弹幕参数
+// 返回：字幕文件和是否成功
+func (h *Handler) danmakuSubtitle(ctx context.Context, cid int64, duration time.Duration, opts service.DanmakuOptions) (service.SubtitleFile, bool) {
+	list, err := h.apiService.GetDanmaku(ctx, cid, duration)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			logging.FromContext(ctx).Warn("Failed to get danmaku, skipping", "cid", cid, "error", err)
+		}
+		return service.SubtitleFile{}, false
+	}
+	return service.SubtitleFile{
+		Language: "chi",
+		Title:    "弹幕",
+		Data:     service.DanmakuToAss(list, opts),
+		Ass:      true,
+	}, true
+}
@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Favorites 处理当前账号的收藏夹列表请求
+// GET /bilibili/favorites
+// 返回 Cookie 对应账号创建的全部收藏夹（包括私密收藏夹）
+func (h *Handler) Favorites(c *gin.Context) {
+	nav, err := h.apiService.CheckLogin(c.Request.Context())
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to check login: %w", err))
+		return
+	}
+	if !nav.IsLogin {
+		h.handleError(c, errors.New("Cookie is not logged in"))
+		return
+	}
+	h.favoriteFolders(c, nav.Mid)
+}
+
+// SpaceFavorites 处理指定用户的收藏夹列表请求
+// GET /bilibili/space/:mid/favorites
+// 只返回公开的收藏夹，mid 为 Cookie 对应账号时也返回私密收藏夹
+func (h *Handler) SpaceFavorites(c *gin.Context) {
+	mid, ok := parseMid(c)
+	if !ok {
+		return
+	}
+	h.favoriteFolders(c, mid)
+}
+
+// favoriteFolders 返回用户创建的收藏夹列表
+func (h *Handler) favoriteFolders(c *gin.Context, mid int64) {
+	folders, err := h.apiService.GetFavFolders(c.Request.Context(), mid)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get favorites folders: %w", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mid":     mid,
+		"folders": folders,
+	})
+}
+
+// FavoriteMedia 处理收藏夹内容请求
+// GET /bilibili/favorites/:media_id
+// 按收藏时间倒序分页返回收藏夹内容，pn 为页码，ps 为每页数量（1~20）
+func (h *Handler) FavoriteMedia(c *gin.Context) {
+	mediaId, ok := parseMediaId(c)
+	if !ok {
+		return
+	}
+	page, ok := parsePage(c, c.DefaultQuery("pn", "1"))
+	if !ok {
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("ps", strconv.Itoa(service.DefaultFavPageSize)))
+	if err != nil || size < 1 || size > service.MaxFavPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid ps parameter, expected 1-%d", service.MaxFavPageSize),
+		})
+		return
+	}
+
+	result, err := h.apiService.GetFavMedia(c.Request.Context(), mediaId, page, size)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get favorites folder: %w", err))
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// FavoriteSync 处理收藏夹同步请求
+// POST /bilibili/favorites/:media_id/sync
+// 获取收藏夹的全部视频，创建批量任务下载尚未同步过的视频；已失效的视频和非视频内容会被忽略
+// 同步记录保存在视频库目录的 .favorites/<media_id>.json 中，之前同步过的视频标记为 skipped，
+// 即使文件已经从视频库中移走或删除也不会重新下载
+func (h *Handler) FavoriteSync(c *gin.Context) {
+	mediaId, ok := parseMediaId(c)
+	if !ok {
+		return
+	}
+	if !h.requireLibrary(c) {
+		return
+	}
+	opts, ok := parseJobOptions(c, h.config())
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	info, medias, err := h.apiService.ListFavMedia(ctx, mediaId)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get favorites folder: %w", err))
+		return
+	}
+
+	path := favoriteRecordPath(h.config().Library.Dir, mediaId)
+	h.favoritesMu.Lock()
+	record, err := loadFavoriteRecord(path)
+	h.favoritesMu.Unlock()
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	var items []*JobItem
+	unavailable := 0
+	for _, m := range medias {
+		if !m.Available() {
+			unavailable++
+			continue
+		}
+		item := &JobItem{Bvid: m.Bvid, Title: m.Title}
+		if fetched, ok := record.Fetched[m.Bvid]; ok {
+			item.Status, item.Files = JobSkipped, fetched.Files
+		}
+		items = append(items, item)
+	}
+	if unavailable > 0 {
+		logging.FromContext(ctx).Info("Skipping unavailable favorites items", "media_id", mediaId, "count", unavailable)
+	}
+
+	job := h.submitJob(fmt.Sprintf("favorites:%d", mediaId), requesterOf(c), opts, items, func(ctx context.Context, item JobItem) {
+		h.recordFavorite(ctx, path, mediaId, info.Title, item)
+	})
+	c.Header("Location", "/bilibili/jobs/"+job.Id)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// favoriteRecord 收藏夹的同步记录
+type favoriteRecord struct {
+	MediaId int64                      `json:"media_id"`
+	Title   string                     `json:"title"`
+	Updated time.Time                  `json:"updated"`
+	Fetched map[string]favoriteFetched `json:"fetched"` // 按 BV 号索引
+}
+
+// favoriteFetched 一个已同步的视频
+type favoriteFetched struct {
+	Title string    `json:"title"`
+	Files []string  `json:"files"`
+	Time  time.Time `json:"time"`
+}
+
+// favoriteRecordPath 返回收藏夹同步记录的路径
+func favoriteRecordPath(dir string, mediaId int64) string {
+	return filepath.Join(dir, ".favorites", fmt.Sprintf("%d.json", mediaId))
+}
+
+// loadFavoriteRecord 读取同步记录，文件不存在时返回空记录（调用方需持有 favoritesMu）
+func loadFavoriteRecord(path string) (*favoriteRecord, error) {
+	record := &favoriteRecord{Fetched: map[string]favoriteFetched{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return record, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, record)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read favorites sync record: %w", err)
+	}
+	if record.Fetched == nil {
+		record.Fetched = map[string]favoriteFetched{}
+	}
+	return record, nil
+}
+
+// recordFavorite 把下载完成的视频写入同步记录，写入失败只记录日志，下次同步时会重新检查视频库中的文件
+func (h *Handler) recordFavorite(ctx context.Context, path string, mediaId int64, title string, item JobItem) {
+	h.favoritesMu.Lock()
+	defer h.favoritesMu.Unlock()
+
+	err := func() error {
+		record, err := loadFavoriteRecord(path)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		record.MediaId, record.Title, record.Updated = mediaId, title, now
+		record.Fetched[item.Bvid] = favoriteFetched{Title: item.Title, Files: item.Files, Time: now}
+
+		data, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		tmp := path + ".part"
+		if err := os.WriteFile(tmp, data, 0o644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}()
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to update favorites sync record", "media_id", mediaId, "bvid", item.Bvid, "error", err)
+	}
+}
+
+// parseMediaId 解析路径参数中的收藏夹 ID，无效时返回 400
+func parseMediaId(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("media_id"), 10, 64)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid media_id parameter",
+		})
+		return 0, false
+	}
+	return id, true
+}
@@ -1,38 +1,216 @@
 package handler
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"bilibili-downloader-server/config"
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/metrics"
 	"bilibili-downloader-server/service"
+	"bilibili-downloader-server/store"
+	"bilibili-downloader-server/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Handler HTTP 请求处理器
 type Handler struct {
-	apiService *service.ApiService
-	downloader *service.Downloader
+	cfg             atomic.Pointer[config.Config]
+	apiService      *service.ApiService
+	downloader      *service.Downloader
+	downloadLimiter *service.Limiter
+	ipLimiter       *service.KeyedLimiter
+	ready           readyCache
+	draining        atomic.Bool
+
+	// 批量下载任务，关闭时（Drain）取消排队中的任务并等待执行中的任务，
+	// 超时后（Abort）通过 jobsCancel 取消全部任务
+	jobs       jobQueue
+	jobLimiter *service.Limiter
+	jobsCtx    context.Context
+	jobsCancel context.CancelFunc
+
+	// 下载记录数据库，未配置 library.database 时为 nil
+	store *store.Store
+	// 批量任务按创建任务的 API Key 累计配额，未调用 SetAuth 时不限制
+	auth *Auth
+
+	// 保护视频库中的收藏夹同步记录
+	favoritesMu sync.Mutex
+	// 保护视频库中未启用数据库时的已下载文件索引
+	libraryMu sync.Mutex
+	// 订阅列表，由 RunSubscriptions 定期检查
+	subs subscriptionStore
 }
 
 // NewHandler 创建 Handler 实例
-// 参数 cookie: 用户 Cookie，用于身份验证
+// 参数 cfg: 服务配置
 // 返回：配置好的 Handler 实例
-func NewHandler(cookie string) *Handler {
-	return &Handler{
-		apiService: service.NewApiService(cookie),
-		downloader: service.NewDownloader(cookie),
+func NewHandler(cfg *config.Config) *Handler {
+	h := &Handler{
+		apiService:      service.NewApiService(clientOptions(cfg, cfg.Bilibili.ApiTimeout)),
+		downloader:      service.NewDownloader(downloaderOptions(cfg)),
+		downloadLimiter: service.NewLimiter(cfg.Limits.Downloads),
+		ipLimiter:       service.NewKeyedLimiter(cfg.Limits.PerIP),
+		jobLimiter:      service.NewLimiter(cfg.Library.Jobs),
+	}
+	h.jobsCtx, h.jobsCancel = context.WithCancel(context.Background())
+	h.cfg.Store(cfg)
+	return h
+}
+
+// ApplyConfig 热更新配置
+// 新的 Cookie、超时和 User-Agent 只对之后发起的请求生效，
+// 调整并发上限不会中断进行中的下载
+// 参数 cfg: 新的服务配置
+func (h *Handler) ApplyConfig(cfg *config.Config) {
+	h.cfg.Store(cfg)
+	h.apiService.UpdateOptions(clientOptions(cfg, cfg.Bilibili.ApiTimeout))
+	h.downloader.UpdateOptions(downloaderOptions(cfg))
+	h.downloadLimiter.SetLimit(cfg.Limits.Downloads)
+	h.ipLimiter.SetLimit(cfg.Limits.PerIP)
+	h.jobLimiter.SetLimit(cfg.Library.Jobs)
+}
+
+// SetAuth 设置批量任务累计配额使用的 Auth，需要在开始处理请求之前调用
+// 批量任务在后台下载，不经过认证中间件，每个分 P 按创建任务的 API Key 累计下载次数和字节数
+func (h *Handler) SetAuth(auth *Auth) {
+	h.auth = auth
+}
+
+// Drain 进入关闭流程：拒绝新的下载请求，就绪检查返回失败，取消还没有开始的批量任务，
+// 进行中的下载和批量任务继续完成
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+	h.jobs.cancelQueued()
+}
+
+// WaitJobs 等待全部批量任务结束，ctx 取消时返回错误，之后由 Abort 取消剩余的任务
+// 参数 ctx: 上下文，通常带有关闭超时
+// 返回：等待超时的错误信息
+func (h *Handler) WaitJobs(ctx context.Context) error {
+	ticker := time.NewTicker(jobsPollInterval)
+	defer ticker.Stop()
+	for h.jobs.active() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Abort 终止所有进行中的下载、批量任务和 FFmpeg 进程并清理临时目录
+// 用于关闭时等待超时后的强制清理，最多等待 abortJobsGrace 让被取消的任务记录结束状态
+func (h *Handler) Abort() {
+	h.jobsCancel()
+	h.downloader.Abort()
+
+	ctx, cancel := context.WithTimeout(context.Background(), abortJobsGrace)
+	defer cancel()
+	h.WaitJobs(ctx)
+}
+
+// RunJanitor 在后台定期清理残留的工作目录，直到 ctx 取消
+// 启动时额外清理一次系统临时目录中旧版本遗留的工作目录，未设置 TEMP_DIR 时系统临时目录就是工作根目录，
+// 同样跳过进行中的下载正在使用的目录
+func (h *Handler) RunJanitor(ctx context.Context) {
+	cfg := h.config()
+	if removed, err := h.downloader.SweepDir(os.TempDir(), time.Duration(cfg.Download.StaleAfter)); err == nil && removed > 0 {
+		logging.FromContext(ctx).Info("Removed stale work directories", "root", os.TempDir(), "count", removed)
 	}
+	h.downloader.RunJanitor(ctx)
+}
+
+// config 返回当前配置
+func (h *Handler) config() *config.Config {
+	return h.cfg.Load()
+}
+
+// clientOptions 根据配置生成 HTTP 客户端配置
+func clientOptions(cfg *config.Config, timeout config.Duration) service.ClientOptions {
+	return service.ClientOptions{
+		Cookie:    cfg.Bilibili.Cookie,
+		UserAgent: cfg.Bilibili.UserAgent,
+		Timeout:   time.Duration(timeout),
+	}
+}
+
+// downloaderOptions 根据配置生成下载器配置
+func downloaderOptions(cfg *config.Config) service.DownloaderOptions {
+	return service.DownloaderOptions{
+		Client:          clientOptions(cfg, cfg.Download.Timeout),
+		TempDir:         cfg.Download.TempDir,
+		MaxMerges:       cfg.Limits.Merges,
+		MaxTranscodes:   cfg.Limits.Transcodes,
+		CacheDir:        cfg.Download.CacheDir,
+		CacheTTL:        time.Duration(cfg.Transcode.CacheTTL),
+		MinFreeDisk:     uint64(cfg.Server.MinFreeDiskMB) << 20,
+		StaleAfter:      time.Duration(cfg.Download.StaleAfter),
+		JanitorInterval: time.Duration(cfg.Download.JanitorInterval),
+	}
+}
+
+// transcodeProfile 根据名称查找配置中的转码配置档
+func transcodeProfile(cfg *config.Config, name string) (service.TranscodeProfile, bool) {
+	p, ok := cfg.Transcode.Profiles[name]
+	if !ok {
+		return service.TranscodeProfile{}, false
+	}
+	return service.TranscodeProfile{
+		Name:         name,
+		VideoCodec:   p.VideoCodec,
+		VideoProfile: p.VideoProfile,
+		Preset:       p.Preset,
+		Height:       p.Height,
+		VideoBitrate: p.VideoBitrate,
+		PixelFormat:  p.PixelFormat,
+		AudioCodec:   p.AudioCodec,
+		AudioBitrate: p.AudioBitrate,
+	}, true
 }
 
 // Health 处理健康检查请求
 // GET /bilibili/download/health
-// 返回简单的健康状态
+// 保留旧路径以兼容已有的探针配置，等同于 Live
 func (h *Handler) Health(c *gin.Context) {
+	h.Live(c)
+}
+
+// Queue 处理下载队列状态查询请求
+// GET /bilibili/download/queue
+// 返回下载、FFmpeg 合并和转码的执行中及排队中数量
+func (h *Handler) Queue(c *gin.Context) {
+	downloadsActive, downloadsWaiting := h.downloadLimiter.Stats()
+	mergesActive, mergesWaiting := h.downloader.MergeStats()
+	transcodesActive, transcodesWaiting := h.downloader.TranscodeStats()
 	c.JSON(http.StatusOK, gin.H{
-		"status": "ok",
+		"downloads": gin.H{
+			"active":  downloadsActive,
+			"waiting": downloadsWaiting,
+		},
+		"merges": gin.H{
+			"active":  mergesActive,
+			"waiting": mergesWaiting,
+		},
+		"transcodes": gin.H{
+			"active":  transcodesActive,
+			"waiting": transcodesWaiting,
+		},
 	})
 }
 
@@ -48,21 +226,37 @@ func (h *Handler) Download(c *gin.Context) {
 		return
 	}
 
-	// 获取 URL 参数 p（分 P 页码）和 quality（清晰度）
+	// 关闭流程中不再接受新的下载
+	if h.rejectDraining(c) {
+		return
+	}
+
+	cfg := h.config()
+
+	// 获取 URL 参数 p（分 P 页码）、quality（清晰度）、codec（视频编码偏好）、format（输出容器）、
+	// profile（转码配置档）、metadata（是否写入元数据）、filename（文件名模板）、subtitles（内嵌字幕语言）
+	// danmaku（是否内嵌弹幕）、start / end（截取片段的时间范围）、precise（是否精确截取）和 hdr / dolby（HDR 或杜比视界）
 	p := c.DefaultQuery("p", "1")
-	quality := c.DefaultQuery("quality", "80")
+	quality := c.DefaultQuery("quality", strconv.Itoa(cfg.Download.DefaultQuality))
+	codec := c.DefaultQuery("codec", cfg.Download.DefaultCodec)
+	formatName := c.DefaultQuery("format", cfg.Download.DefaultFormat)
+	profileName := c.Query("profile")
+	embedMetadata := c.DefaultQuery("metadata", strconv.FormatBool(cfg.Download.EmbedMetadata))
+	filenameTemplate := c.DefaultQuery("filename", cfg.Download.FilenameTemplate)
+	subtitleLangs := c.Query("subtitles")
+	withDanmaku := c.DefaultQuery("danmaku", "false")
+	clipStart, clipEnd := c.Query("start"), c.Query("end")
+	precise := c.DefaultQuery("precise", "false")
+	withHdr, withDolby := c.DefaultQuery("hdr", "false"), c.DefaultQuery("dolby", "false")
 
 	// 解析 page 参数
-	page := 1
-	if _, err := fmt.Sscanf(p, "%d", &page); err != nil || page < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid page parameter",
-		})
+	page, ok := parsePage(c, p)
+	if !ok {
 		return
 	}
 
 	// 解析 quality 参数
-	qn := 80
+	qn := cfg.Download.DefaultQuality
 	if _, err := fmt.Sscanf(quality, "%d", &qn); err != nil || qn < 1 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid quality parameter",
@@ -70,50 +264,247 @@ func (h *Handler) Download(c *gin.Context) {
 		return
 	}
 
-	// 判断是 AV 号还是 BV 号
-	// AV 号：纯数字
-	// BV 号：以 BV 开头（不区分大小写）
-	var bvid string
-	var err error
+	// 校验 codec 参数
+	switch codec {
+	case "", "avc", "hevc", "av1":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid codec parameter",
+		})
+		return
+	}
 
-	if strings.HasPrefix(strings.ToUpper(id), "BV") {
-		// BV 号下载
-		bvid = id
-		// 确保 bvid 以 BV 开头
-		if !strings.HasPrefix(bvid, "BV") {
-			bvid = "BV" + strings.TrimPrefix(id, "BV")
+	// 解析 format 参数
+	format, ok := service.LookupFormat(strings.ToLower(formatName))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid format parameter, expected one of: " + strings.Join(service.FormatNames(), ", "),
+		})
+		return
+	}
+	opts := service.MergeOptions{Format: format}
+
+	// 校验 filename 参数
+	if err := utils.ValidateFilenameTemplate(filenameTemplate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid filename parameter: " + err.Error(),
+		})
+		return
+	}
+
+	// 解析 metadata 参数
+	withMetadata, err := strconv.ParseBool(embedMetadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid metadata parameter",
+		})
+		return
+	}
+
+	// 解析 profile 参数，转码时编码器必须能放入所选容器
+	if profileName != "" {
+		profile, ok := transcodeProfile(cfg, profileName)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Unknown transcode profile: " + profileName,
+			})
+			return
 		}
-	} else if isNumeric(id) {
-		// AV 号下载，转换为 BV 号
-		bvid, err = h.avidToBvid(id, page)
+		if !format.Supports(profile) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Transcode profile %s cannot be muxed into %s", profile.Name, format.Name),
+			})
+			return
+		}
+		opts.Profile = &profile
+	} else if format.RequiredCodec != "" {
+		// 直接复制流时容器对视频编码有要求（如 WebM 只能放 AV1），覆盖编码偏好
+		codec = format.RequiredCodec
+	}
+
+	// 解析 subtitles 参数
+	subtitles := parseList(subtitleLangs)
+
+	// 解析 danmaku 参数，弹幕以 ASS 字幕内嵌，只有支持 ASS 的容器可以使用
+	embedDanmaku, err := strconv.ParseBool(withDanmaku)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid danmaku parameter",
+		})
+		return
+	}
+	var danmaku *service.DanmakuOptions
+	if embedDanmaku {
+		if !format.AssSubtitles {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Danmaku cannot be muxed into %s, use format=mkv", format.Name),
+			})
+			return
+		}
+		danmakuOpts, ok := danmakuOptions(c, cfg.Danmaku)
+		if !ok {
+			return
+		}
+		danmaku = &danmakuOpts
+	}
+
+	// 解析 start、end 和 precise 参数
+	if clipStart != "" || clipEnd != "" {
+		clip, err := parseClip(clipStart, clipEnd)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "AV to BV conversion failed: " + err.Error(),
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
 			})
 			return
 		}
-	} else {
+		opts.Clip = clip
+
+		// 精确截取需要重新编码，未指定转码配置档时按容器选择编码器
+		exact, err := strconv.ParseBool(precise)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid precise parameter",
+			})
+			return
+		}
+		if exact && opts.Profile == nil {
+			profile := service.PreciseClipProfile(format)
+			opts.Profile = &profile
+			codec = c.DefaultQuery("codec", cfg.Download.DefaultCodec)
+		}
+	}
+
+	// 解析 hdr 和 dolby 参数，HDR 轨道只能直接复制，转码会丢失色彩信息
+	hdrMode, err := parseHdrMode(withHdr, withDolby)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid video ID format",
+			"error": err.Error(),
+		})
+		return
+	}
+	if hdrMode != service.HdrNone {
+		if opts.Profile != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "HDR and Dolby Vision tracks cannot be transcoded, remove profile and precise",
+			})
+			return
+		}
+		if hdrMode == service.HdrDolby && !format.DolbyAudio {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Dolby audio cannot be muxed into %s, use format=mp4 or mkv", format.Name),
+			})
+			return
+		}
+		qn = hdrMode.Quality(qn)
+	}
+
+	// 判断是 AV 号还是 BV 号
+	bvid, ok := h.resolveBvid(c, id, page)
+	if !ok {
+		return
+	}
+
+	// 记录下载日志和指标
+	ctx := c.Request.Context()
+	logger := logging.FromContext(ctx).With("bvid", bvid, "page", page, "qn", qn, "format", format.Name, "profile", profileName)
+	start := time.Now()
+	var written int64
+	var video *videoDownload
+	var downloadErr error
+	checksum := sha256.New()
+	defer func() {
+		outcome := downloadOutcome(ctx, c.Writer.Status(), downloadErr)
+		duration := time.Since(start)
+		quality := qn
+		if video != nil {
+			quality = video.quality
+		}
+		metrics.DownloadsTotal.WithLabelValues(outcome, metrics.QualityLabel(quality)).Inc()
+		metrics.DownloadDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+		logger.Info("Download finished",
+			"outcome", outcome,
+			"bytes", written,
+			"duration_ms", duration.Milliseconds(),
+		)
+
+		record := store.Download{
+			Bvid:      bvid,
+			Page:      page,
+			Quality:   qn,
+			Codec:     codec,
+			Format:    format.Name,
+			Size:      written,
+			Requester: requesterOf(c),
+			Source:    "request",
+			Outcome:   outcome,
+			Started:   start,
+		}
+		if video != nil {
+			record.Cid, record.Quality, record.Codec, record.Cached = video.cid, video.quality, video.codec, video.cached
+		}
+		if outcome == store.OutcomeSuccess {
+			record.Checksum = hex.EncodeToString(checksum.Sum(nil))
+		}
+		h.recordDownload(ctx, record, downloadErr)
+	}()
+
+	// 获取执行名额，超出并发上限时排队等待
+	release, err := h.acquireSlots(c)
+	if err != nil {
+		// 客户端在排队期间断开连接
+		logger.Warn("Download cancelled while queued", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Request cancelled while queued: " + err.Error(),
 		})
 		return
 	}
+	defer release()
+
+	metrics.DownloadsInFlight.Inc()
+	defer metrics.DownloadsInFlight.Dec()
 
 	// 下载视频
-	reader, err := h.downloadVideo(bvid, page, qn)
+	video, err = h.downloadVideo(ctx, downloadRequest{
+		bvid:         bvid,
+		page:         page,
+		quality:      qn,
+		codec:        codec,
+		withMetadata: withMetadata,
+		subtitles:    subtitles,
+		danmaku:      danmaku,
+		hdr:          hdrMode,
+		opts:         opts,
+	})
 	if err != nil {
+		logger.Error("Download failed", "error", err)
+		downloadErr = err
 		h.handleError(c, err)
 		return
 	}
-	defer reader.Close()
+	defer video.Close()
+
+	if opts.Profile != nil {
+		if video.cached {
+			c.Header("X-Cache", "HIT")
+		} else {
+			c.Header("X-Cache", "MISS")
+		}
+	}
 
 	// 设置响应头
-	c.Header("Content-Type", "video/mp4")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.mp4\"", bvid))
+	c.Header("Content-Type", format.ContentType)
+	filename := downloadFilename(filenameTemplate, bvid, page, format, opts.Clip, video)
+	c.Header("Content-Disposition", utils.ContentDisposition(filename, bvid+"."+format.Extension))
 
-	// 将文件内容写入响应体
-	_, err = io.Copy(c.Writer, reader)
+	// 将文件内容写入响应体，启用数据库时同时计算 SHA-256 用于下载记录
+	var out io.Writer = c.Writer
+	if h.store != nil {
+		out = io.MultiWriter(c.Writer, checksum)
+	}
+	written, err = io.Copy(out, video)
 	if err != nil {
+		logger.Warn("Failed to write response", "error", err)
+		downloadErr = err
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to write response: " + err.Error(),
 		})
@@ -121,6 +512,162 @@ func (h *Handler) Download(c *gin.Context) {
 	}
 }
 
+// downloadOutcome 根据下载错误和响应状态码得到下载结果标签
+// 开始写出响应体后状态码已经是 200，写出中途失败时需要根据 err 判断，客户端断开时记为 cancelled
+func downloadOutcome(ctx context.Context, status int, err error) string {
+	if err != nil {
+		if ctx.Err() != nil {
+			return "cancelled"
+		}
+		if status == http.StatusOK {
+			return "error"
+		}
+	}
+	switch status {
+	case http.StatusOK:
+		return "success"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusServiceUnavailable:
+		return "cancelled"
+	case http.StatusInsufficientStorage:
+		return "insufficient_disk"
+	case http.StatusUnprocessableEntity:
+		return "unavailable"
+	default:
+		return "error"
+	}
+}
+
+// parsePage 解析分 P 页码，无效时返回 400
+func parsePage(c *gin.Context, p string) (int, bool) {
+	page := 1
+	if _, err := fmt.Sscanf(p, "%d", &page); err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid page parameter",
+		})
+		return 0, false
+	}
+	return page, true
+}
+
+// parseClip 解析截取片段的开始和结束时间，两者都可以省略（从开头或到结尾）
+// 参数 start, end: 秒数（如 90.5）或 [时:]分:秒 格式（如 1:30）
+// 返回：时间范围和错误信息
+func parseClip(start, end string) (*service.Clip, error) {
+	clip := &service.Clip{}
+	var err error
+	if start != "" {
+		if clip.Start, err = parseClipTime(start); err != nil {
+			return nil, fmt.Errorf("Invalid start parameter: %w", err)
+		}
+	}
+	if end != "" {
+		if clip.End, err = parseClipTime(end); err != nil {
+			return nil, fmt.Errorf("Invalid end parameter: %w", err)
+		}
+		if clip.End <= clip.Start {
+			return nil, fmt.Errorf("Invalid end parameter: must be after start")
+		}
+	}
+	return clip, nil
+}
+
+// parseClipTime 解析秒数或 [时:]分:秒 格式的时间
+func parseClipTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("expected seconds or [hh:]mm:ss, got %q", s)
+	}
+	var total float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || !(v >= 0) || math.IsInf(v, 0) || (i > 0 && v >= 60) {
+			return 0, fmt.Errorf("expected seconds or [hh:]mm:ss, got %q", s)
+		}
+		total = total*60 + v
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+// clipSeconds 把时间格式化为秒数，去掉多余的小数位，用于文件名
+func clipSeconds(d time.Duration) string {
+	if d <= 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// parseHdrMode 解析 hdr 和 dolby 参数，两者不能同时开启
+// 返回：HDR 模式和错误信息
+func parseHdrMode(hdr, dolby string) (service.HdrMode, error) {
+	withHdr, err := strconv.ParseBool(hdr)
+	if err != nil {
+		return service.HdrNone, fmt.Errorf("Invalid hdr parameter")
+	}
+	withDolby, err := strconv.ParseBool(dolby)
+	if err != nil {
+		return service.HdrNone, fmt.Errorf("Invalid dolby parameter")
+	}
+	switch {
+	case withHdr && withDolby:
+		return service.HdrNone, fmt.Errorf("Parameters hdr and dolby cannot be used together")
+	case withHdr:
+		return service.HdrHdr10, nil
+	case withDolby:
+		return service.HdrDolby, nil
+	}
+	return service.HdrNone, nil
+}
+
+// parseList 解析逗号分隔的列表，忽略空项
+func parseList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// resolveBvid 把视频 ID 解析为 BV 号，失败时写入错误响应
+// AV 号：纯数字，通过 API 转换为 BV 号
+// BV 号：以 BV 开头（不区分大小写）
+// 参数 c: gin 上下文
+// 参数 id: 视频 ID（AV 号或 BV 号）
+// 参数 page: 分 P 页码
+// 返回：BV 号和是否成功
+func (h *Handler) resolveBvid(c *gin.Context, id string, page int) (string, bool) {
+	if strings.HasPrefix(strings.ToUpper(id), "BV") {
+		// 确保 bvid 以 BV 开头
+		bvid := id
+		if !strings.HasPrefix(bvid, "BV") {
+			bvid = "BV" + id[2:]
+		}
+		return bvid, true
+	}
+
+	if isNumeric(id) {
+		// AV 号，转换为 BV 号
+		bvid, err := h.avidToBvid(c.Request.Context(), id, page)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "AV to BV conversion failed: " + err.Error(),
+			})
+			return "", false
+		}
+		return bvid, true
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": "Invalid video ID format",
+	})
+	return "", false
+}
+
 // isNumeric 判断字符串是否为纯数字
 func isNumeric(s string) bool {
 	for _, r := range s {
@@ -131,49 +678,375 @@ func isNumeric(s string) bool {
 	return len(s) > 0
 }
 
+// rejectDraining 关闭流程中拒绝新的下载，返回 503 和 Retry-After
+// 返回：是否已经拒绝
+func (h *Handler) rejectDraining(c *gin.Context) bool {
+	if !h.draining.Load() {
+		return false
+	}
+	c.Header("Retry-After", "30")
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error": "Server is shutting down",
+	})
+	return true
+}
+
+// waitForSlots 获取下载名额，客户端在排队期间断开时写入 503 响应
+// 用于截图、预览等同样需要读取 CDN 和运行 FFmpeg 的请求
+// 返回：释放全部名额的函数和是否获取成功
+func (h *Handler) waitForSlots(c *gin.Context) (func(), bool) {
+	release, err := h.acquireSlots(c)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Request cancelled while queued: " + err.Error(),
+		})
+		return nil, false
+	}
+	return release, true
+}
+
+// acquireSlots 依次获取客户端 IP 和全局下载名额
+// 返回：释放全部名额的函数和错误信息
+func (h *Handler) acquireSlots(c *gin.Context) (func(), error) {
+	ctx := c.Request.Context()
+	clientIP := c.ClientIP()
+
+	releaseIP, err := h.ipLimiter.Acquire(ctx, clientIP, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := false
+	releaseDownload, err := h.downloadLimiter.Acquire(ctx, func(int) {
+		queued = true
+		metrics.DownloadsQueued.Inc()
+	})
+	if queued {
+		metrics.DownloadsQueued.Dec()
+	}
+	if err != nil {
+		releaseIP()
+		return nil, err
+	}
+
+	return func() {
+		releaseDownload()
+		releaseIP()
+	}, nil
+}
+
+// videoDownload downloadVideo 的结果
+type videoDownload struct {
+	io.ReadCloser
+	cached  bool               // 是否命中转码缓存
+	info    *service.VideoInfo // 视频信息，获取失败时为 nil
+	cid     int64
+	quality int    // 实际下载的清晰度
+	codec   string // 实际下载的视频编码，如 avc1.640032，durl 分段为空
+}
+
+// downloadRequest 下载参数
+type downloadRequest struct {
+	bvid         string
+	page         int
+	cid          int64 // 为 0 时根据页码获取
+	quality      int
+	codec        string                  // 视频编码偏好（avc/hevc/av1），为空时不限制
+	withMetadata bool                    // 是否写入标题、UP 主、封面和章节等元数据
+	subtitles    []string                // 内嵌字幕的语言代码，"all" 表示全部
+	danmaku      *service.DanmakuOptions // 内嵌弹幕的参数，为 nil 时不内嵌弹幕
+	hdr          service.HdrMode         // HDR 模式，要求的轨道不存在时返回 ErrStreamUnavailable
+	opts         service.MergeOptions
+}
+
 // downloadVideo 执行视频下载流程
-// 参数 bvid: 视频 BV 号
-// 参数 page: 分 P 页码
-// 参数 quality: 清晰度
-// 返回：视频文件读取器和错误信息
-func (h *Handler) downloadVideo(bvid string, page int, quality int) (io.ReadCloser, error) {
-	// 1. 获取 CID
-	cid, err := h.apiService.GetCid(bvid, page)
+// 参数 ctx: 上下文，客户端断开时取消下载
+// 参数 r: 下载参数
+// 返回：下载结果和错误信息
+func (h *Handler) downloadVideo(ctx context.Context, r downloadRequest) (*videoDownload, error) {
+	bvid, page, opts := r.bvid, r.page, r.opts
+	logger := logging.FromContext(ctx).With("bvid", bvid)
+
+	// 1. 获取 CID，调用方已经指定时（如互动视频的节点）直接使用
+	cid := r.cid
+	if cid == 0 {
+		var err error
+		if cid, err = h.apiService.GetCid(ctx, bvid, page); err != nil {
+			return nil, fmt.Errorf("Failed to get CID: %w", err)
+		}
+	}
+
+	// 2. 获取视频信息，用于文件名和元数据，失败时只记录日志
+	info, err := h.apiService.GetVideoInfo(ctx, bvid)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get CID: %w", err)
+		logger.Warn("Failed to get video info, falling back to plain filename without metadata", "error", err)
 	}
 
-	// 2. 获取播放地址
-	playUrlData, err := h.apiService.GetPlayUrl(bvid, cid, quality)
+	// 3. 获取播放地址
+	playUrlData, err := h.apiService.GetPlayUrl(ctx, bvid, cid, r.quality)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get play URL: %w", err)
 	}
 
-	// 3. 提取视频和音频地址
-	if len(playUrlData.Dash.Video) == 0 || len(playUrlData.Dash.Audio) == 0 {
+	// 4. 提取视频和音频地址，没有 DASH 格式时使用 durl 分段
+	if duration := playUrlData.Duration(); opts.Clip != nil && duration > 0 && opts.Clip.Start >= duration {
+		return nil, fmt.Errorf("%w: start %s, duration %s", service.ErrClipOutOfRange, opts.Clip.Start, duration)
+	}
+	var videoTrack service.VideoTrack
+	var videoUrl, audioUrl string
+	var segments []service.Segment
+	switch {
+	case playUrlData.HasDash():
+		videoTrack = service.SelectVideoTrack(playUrlData.Dash.Video, playUrlData.Quality, r.codec)
+		audioTrack := service.SelectAudioTrack(playUrlData.Dash, r.hdr)
+		if r.hdr == service.HdrDolby && audioTrack.Codecs != "" && !strings.HasPrefix(audioTrack.Codecs, "ec-3") {
+			logger.Warn("Dolby audio not offered, using regular audio", "audio_codecs", audioTrack.Codecs)
+		}
+		if opts.Clip != nil {
+			// 截取片段需要轨道的 sidx 索引位置
+			clip := *opts.Clip
+			clip.VideoIndex, clip.AudioIndex = videoTrack.SegmentBase, audioTrack.SegmentBase
+			opts.Clip = &clip
+		}
+		videoUrl = service.GetVideoUrl(videoTrack)
+		audioUrl = service.GetAudioUrl(audioTrack)
+		if videoUrl == "" || audioUrl == "" {
+			return nil, fmt.Errorf("Video or audio URL is empty")
+		}
+		logger.Info("Stream resolved",
+			"cid", cid,
+			"qn", videoTrack.Id,
+			"video_codecs", videoTrack.Codecs,
+			"audio_codecs", audioTrack.Codecs,
+		)
+	case len(playUrlData.Durl) > 0:
+		// durl 只有一种编码，不能按偏好选择
+		segments = playUrlData.Segments()
+		videoTrack = service.VideoTrack{Id: playUrlData.Quality, Codecid: playUrlData.VideoCodecid}
+		logger.Info("Stream resolved",
+			"cid", cid,
+			"qn", videoTrack.Id,
+			"durl_format", playUrlData.Format,
+			"segments", len(segments),
+		)
+	default:
 		return nil, fmt.Errorf("No video or audio stream found")
 	}
+	if opts.Profile == nil && !opts.Format.Accepts(videoTrack) {
+		return nil, fmt.Errorf("%w: %s requires %s video, not offered at qn %d",
+			service.ErrStreamUnavailable, opts.Format.Name, opts.Format.RequiredCodec, videoTrack.Id)
+	}
+	if r.hdr != service.HdrNone {
+		// 账号没有大会员或视频没有对应轨道时，接口返回较低的清晰度，不静默退回 SDR
+		if !r.hdr.Matches(videoTrack) {
+			return nil, fmt.Errorf("%w: %s track not offered, got qn %d", service.ErrStreamUnavailable, r.hdr, videoTrack.Id)
+		}
+		opts.Hdr = &service.HdrOptions{Mode: r.hdr, Codecid: videoTrack.Codecid}
+	}
+
+	result := &videoDownload{info: info, cid: cid, quality: videoTrack.Id, codec: videoTrack.Codecs}
+
+	// 5. 转码结果优先使用缓存，缓存键包含源轨道和配置档的全部参数
+	if opts.Profile != nil {
+		danmakuKey := ""
+		if r.danmaku != nil {
+			danmakuKey = r.danmaku.Fingerprint()
+		}
+		opts.CacheKey = fmt.Sprintf("transcode|%s|%d|%d|%d|%s|%s|%t|%s|%s|%s",
+			bvid, cid, videoTrack.Id, videoTrack.Codecid, opts.Format.Name, opts.Profile.Fingerprint(),
+			r.withMetadata && info != nil, strings.Join(r.subtitles, ","), danmakuKey, opts.Clip.Key())
+		if reader, ok := h.downloader.OpenCached(opts.CacheKey); ok {
+			logger.Info("Transcode cache hit", "profile", opts.Profile.Name)
+			result.ReadCloser, result.cached = reader, true
+			return result, nil
+		}
+	}
 
-	videoUrl := service.GetVideoUrl(playUrlData.Dash.Video[0])
-	audioUrl := service.GetAudioUrl(playUrlData.Dash.Audio[0])
+	// 6. 生成元数据
+	if r.withMetadata && info != nil {
+		opts.Metadata = h.videoMetadata(ctx, info, cid, page)
+	}
 
-	if videoUrl == "" || audioUrl == "" {
-		return nil, fmt.Errorf("Video or audio URL is empty")
+	// 7. 获取内嵌字幕和弹幕，失败时只记录日志
+	if len(r.subtitles) > 0 && opts.Format.SubtitleCodec != "" {
+		opts.Subtitles = h.subtitleFiles(ctx, bvid, cid, r.subtitles)
+	}
+	if r.danmaku != nil {
+		if sub, ok := h.danmakuSubtitle(ctx, cid, pageDuration(info, page), *r.danmaku); ok {
+			opts.Subtitles = append(opts.Subtitles, sub)
+		}
 	}
 
-	// 4. 下载并合并
-	reader, err := h.downloader.DownloadAndMerge(videoUrl, audioUrl, bvid)
+	// 8. 下载并合并
+	var reader io.ReadCloser
+	if segments != nil {
+		reader, err = h.downloader.DownloadAndConcat(ctx, segments, bvid, opts)
+	} else {
+		reader, err = h.downloader.DownloadAndMerge(ctx, videoUrl, audioUrl, bvid, opts)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Failed to download and merge: %w", err)
 	}
 
-	return reader, nil
+	result.ReadCloser = reader
+	return result, nil
+}
+
+// downloadFilename 按模板生成下载文件名
+// 视频信息获取失败时，模板中的标题等字段无法填充，退回 "<bvid>.<ext>"
+// 参数 tmpl: 文件名模板
+// 参数 bvid: 视频 BV 号
+// 参数 page: 分 P 页码
+// 参数 format: 输出格式
+// 参数 clip: 截取的时间范围，为 nil 时表示完整视频
+// 参数 video: 下载结果
+// 返回：清理后的文件名
+func downloadFilename(tmpl, bvid string, page int, format service.OutputFormat, clip *service.Clip, video *videoDownload) string {
+	fallback := bvid + "." + format.Extension
+	info := video.info
+	if info == nil {
+		return fallback
+	}
+
+	values := map[string]string{
+		"title":    info.Title,
+		"uploader": info.Owner.Name,
+		"bvid":     bvid,
+		"aid":      strconv.FormatInt(info.Aid, 10),
+		"page":     strconv.Itoa(page),
+		"quality":  strconv.Itoa(video.quality),
+		"format":   format.Name,
+		"ext":      format.Extension,
+	}
+	if p, ok := info.Page(page); ok {
+		values["part"] = p.Part
+	}
+	if len(info.Pages) > 1 {
+		values["page_suffix"] = fmt.Sprintf(" P%d", page)
+	}
+	if clip != nil {
+		end := ""
+		if clip.End > 0 {
+			end = clipSeconds(clip.End)
+		}
+		values["clip_suffix"] = fmt.Sprintf(" [%s-%s]", clipSeconds(clip.Start), end)
+	}
+	if info.Pubdate > 0 {
+		values["date"] = time.Unix(info.Pubdate, 0).In(bilibiliLocation).Format("2006-01-02")
+	}
+
+	name := utils.RenderFilename(tmpl, values)
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// bilibiliLocation Bilibili 使用的时区（UTC+8），用于格式化发布日期
+var bilibiliLocation = time.FixedZone("CST", 8*60*60)
+
+// videoMetadata 生成写入输出文件的元数据：标题、UP 主、发布日期、简介、封面和分段章节
+// 分段章节获取失败时记录日志并跳过章节
+// 参数 ctx: 上下文
+// 参数 info: 视频信息
+// 参数 cid: 分 P 的 CID
+// 参数 page: 分 P 页码
+// 返回：元数据
+func (h *Handler) videoMetadata(ctx context.Context, info *service.VideoInfo, cid int64, page int) *service.Metadata {
+	bvid := info.Bvid
+	logger := logging.FromContext(ctx).With("bvid", bvid)
+
+	pageUrl := fmt.Sprintf("%s/video/%s/", service.VideoURL, bvid)
+	if page > 1 {
+		pageUrl += fmt.Sprintf("?p=%d", page)
+	}
+	meta := &service.Metadata{
+		Title:       info.Title,
+		Artist:      info.Owner.Name,
+		Description: info.Desc,
+		Comment:     pageUrl,
+		CoverUrl:    info.Pic,
+	}
+	if info.Pubdate > 0 {
+		meta.Date = time.Unix(info.Pubdate, 0).In(bilibiliLocation).Format("2006-01-02")
+	}
+	// 多 P 视频在标题后附加分 P 标题
+	if len(info.Pages) > 1 {
+		if p, ok := info.Page(page); ok && p.Part != "" {
+			meta.Title += " - " + p.Part
+		}
+	}
+
+	points, err := h.apiService.GetViewPoints(ctx, bvid, cid)
+	if err != nil {
+		logger.Warn("Failed to get view points, skipping chapters", "error", err)
+		return meta
+	}
+	for _, p := range points {
+		if p.To <= p.From {
+			continue
+		}
+		meta.Chapters = append(meta.Chapters, service.Chapter{
+			Start: time.Duration(p.From) * time.Second,
+			End:   time.Duration(p.To) * time.Second,
+			Title: p.Content,
+		})
+	}
+	return meta
 }
 
 // handleError 处理错误并返回适当的 HTTP 状态码
 func (h *Handler) handleError(c *gin.Context, err error) {
 	errStr := err.Error()
 
+	// 检查是否是磁盘空间不足
+	if errors.Is(err, service.ErrInsufficientDisk) {
+		c.JSON(http.StatusInsufficientStorage, gin.H{
+			"error": "Insufficient disk space, try again later: " + err.Error(),
+		})
+		return
+	}
+
+	// 检查是否是没有满足输出格式的音视频流
+	if errors.Is(err, service.ErrStreamUnavailable) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "Requested format is not available for this video: " + err.Error(),
+		})
+		return
+	}
+
+	// 检查是否是截取范围超出视频时长
+	if errors.Is(err, service.ErrClipOutOfRange) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 检查是否不是互动视频
+	if errors.Is(err, service.ErrNotInteractive) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 检查是否是视频不属于合集
+	if errors.Is(err, service.ErrNotInCollection) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 检查是否是字幕不存在
+	if errors.Is(err, service.ErrSubtitleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	// 检查是否是视频不存在的错误
 	if strings.Contains(errStr, "未找到视频") || strings.Contains(errStr, "10002") {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -198,9 +1071,10 @@ func (h *Handler) handleError(c *gin.Context, err error) {
 
 // avidToBvid 将 AV 号转换为 BV 号
 // 通过调用 Bilibili pagelist API 获取视频信息，从响应中提取 BV 号
+// 参数 ctx: 上下文，用于取消请求和传递请求 ID
 // 参数 avid: AV 号
 // 参数 page: 分 P 页码（用于获取对应分 P 的 BV 号）
-func (h *Handler) avidToBvid(avid string, page int) (string, error) {
+func (h *Handler) avidToBvid(ctx context.Context, avid string, page int) (string, error) {
 	// 由于 AV 号转 BV 号需要特定的算法，这里通过 API 获取视频信息
 	// 调用 pagelist API 通过 aid 获取视频信息，响应中包含 bvid
 	apiUrl := fmt.Sprintf("%s%s?aid=%s&page=%d", service.BaseURL, service.PagelistEndpoint, avid, page)
@@ -209,13 +1083,16 @@ func (h *Handler) avidToBvid(avid string, page int) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("Failed to create request: %w", err)
 	}
+	req = service.EnsureContext(ctx, req)
 
 	// 设置请求头
 	h.apiService.SetHeadersForRequest(req, "")
 
 	// 发送请求
+	start := time.Now()
 	resp, err := h.apiService.GetHttpClient().Do(req)
 	if err != nil {
+		metrics.ObserveApi(service.PagelistEndpoint, 0, err, start)
 		return "", fmt.Errorf("Request failed: %w", err)
 	}
 	defer resp.Body.Close()
@@ -223,14 +1100,17 @@ func (h *Handler) avidToBvid(avid string, page int) (string, error) {
 	// 读取响应体
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
+		metrics.ObserveApi(service.PagelistEndpoint, 0, err, start)
 		return "", fmt.Errorf("Failed to read response body: %w", err)
 	}
 
 	// 解析 JSON 响应
 	var pagelistResp service.PagelistResponse
 	if err := service.UnmarshalPagelistResponse(body, &pagelistResp); err != nil {
+		metrics.ObserveApi(service.PagelistEndpoint, 0, err, start)
 		return "", fmt.Errorf("Failed to parse JSON: %w", err)
 	}
+	metrics.ObserveApi(service.PagelistEndpoint, pagelistResp.Code, nil, start)
 
 	// 检查响应码
 	if pagelistResp.Code != 0 {
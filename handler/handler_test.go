@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseClipTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"90", 90 * time.Second, true},
+		{"90.5", 90*time.Second + 500*time.Millisecond, true},
+		{"1:30", 90 * time.Second, true},
+		{"01:02:03", time.Hour + 2*time.Minute + 3*time.Second, true},
+		{"1:02:03.25", time.Hour + 2*time.Minute + 3250*time.Millisecond, true},
+		{"100:00", 100 * time.Minute, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"1:60", 0, false},
+		{"1:-5", 0, false},
+		{"1:2:3:4", 0, false},
+		{"1::3", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseClipTime(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseClipTime(%q) = %s, %v; want %s, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseClip(t *testing.T) {
+	tests := []struct {
+		start, end string
+		wantStart  time.Duration
+		wantEnd    time.Duration
+		ok         bool
+	}{
+		{"", "", 0, 0, true},
+		{"1:00", "", time.Minute, 0, true},
+		{"", "30", 0, 30 * time.Second, true},
+		{"10", "1:00", 10 * time.Second, time.Minute, true},
+		{"60", "1:00", 0, 0, false},
+		{"70", "60", 0, 0, false},
+		{"x", "60", 0, 0, false},
+		{"10", "x", 0, 0, false},
+	}
+	for _, tt := range tests {
+		clip, err := parseClip(tt.start, tt.end)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseClip(%q, %q) error = %v, want ok %v", tt.start, tt.end, err, tt.ok)
+			continue
+		}
+		if err == nil && (clip.Start != tt.wantStart || clip.End != tt.wantEnd) {
+			t.Errorf("parseClip(%q, %q) = %s-%s, want %s-%s", tt.start, tt.end, clip.Start, clip.End, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+func TestClipSeconds(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{0, "0"},
+		{-time.Second, "0"},
+		{90 * time.Second, "90"},
+		{1500 * time.Millisecond, "1.5"},
+	}
+	for _, tt := range tests {
+		if got := clipSeconds(tt.in); got != tt.want {
+			t.Errorf("clipSeconds(%s) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bilibili-downloader-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// readyCheckTimeout 单项就绪检查的超时时间
+	readyCheckTimeout = 5 * time.Second
+	// readyCacheTTL 就绪检查结果缓存时间，避免频繁探测时反复请求 Bilibili
+	readyCacheTTL = 10 * time.Second
+)
+
+// checkResult 单项就绪检查结果
+type checkResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readyReport 就绪检查报告
+type readyReport struct {
+	Status    string        `json:"status"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Checks    []checkResult `json:"checks"`
+}
+
+// readyCache 缓存最近一次就绪检查报告
+// 同一时间只有一个请求执行检查，其余请求等待 refreshing 关闭后复用结果
+type readyCache struct {
+	mu         sync.Mutex
+	report     *readyReport
+	refreshing chan struct{}
+}
+
+// readyCheck 单项就绪检查，返回描述信息和错误
+type readyCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// Live 处理存活检查请求
+// GET /health/live
+// 进程能够响应即视为存活
+func (h *Handler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// Ready 处理就绪检查请求
+// GET /health/ready
+// 并发执行 FFmpeg、Cookie 登录状态、WBI 密钥和磁盘空间检查，
+// 全部通过返回 200，否则返回 503，结果包含每项检查的耗时；关闭流程中直接返回 503
+func (h *Handler) Ready(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, readyReport{
+			Status:    "draining",
+			CheckedAt: time.Now(),
+			Checks:    []checkResult{},
+		})
+		return
+	}
+
+	report := h.readyReport(c.Request.Context())
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+// readyReport 返回就绪检查报告，缓存未过期时直接复用
+// 检查在锁外执行，并发的探测请求等待同一次检查的结果，不会依次排队请求 Bilibili
+func (h *Handler) readyReport(ctx context.Context) *readyReport {
+	for {
+		h.ready.mu.Lock()
+		if h.ready.report != nil && time.Since(h.ready.report.CheckedAt) < readyCacheTTL {
+			report := h.ready.report
+			h.ready.mu.Unlock()
+			return report
+		}
+		if wait := h.ready.refreshing; wait != nil {
+			h.ready.mu.Unlock()
+			<-wait
+			continue
+		}
+		done := make(chan struct{})
+		h.ready.refreshing = done
+		h.ready.mu.Unlock()
+
+		// 检查结果由所有等待的请求共享，不随发起检查的请求断开而取消，每项检查仍受 readyCheckTimeout 限制
+		report := h.runReadyChecks(context.WithoutCancel(ctx))
+
+		h.ready.mu.Lock()
+		h.ready.report, h.ready.refreshing = report, nil
+		h.ready.mu.Unlock()
+		close(done)
+		return report
+	}
+}
+
+// runReadyChecks 并发执行全部就绪检查
+func (h *Handler) runReadyChecks(ctx context.Context) *readyReport {
+	checks := []readyCheck{
+		{name: "ffmpeg", run: h.checkFfmpeg},
+		{name: "cookie", run: h.checkCookie},
+		{name: "wbi_keys", run: h.checkWbiKeys},
+		{name: "disk", run: h.checkDisk},
+	}
+
+	report := &readyReport{
+		Status:    "ok",
+		CheckedAt: time.Now(),
+		Checks:    make([]checkResult, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check readyCheck) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != "ok" {
+			report.Status = "fail"
+			break
+		}
+	}
+	return report
+}
+
+// runCheck 在超时限制内执行单项检查并记录耗时
+func runCheck(ctx context.Context, check readyCheck) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := check.run(ctx)
+	result := checkResult{
+		Name:      check.name,
+		Status:    "ok",
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    detail,
+	}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkFfmpeg 检查 FFmpeg 是否可用
+func (h *Handler) checkFfmpeg(ctx context.Context) (string, error) {
+	installed, err := service.CheckFfmpegInstalled()
+	if err != nil {
+		return "", err
+	}
+	if !installed {
+		return "", fmt.Errorf("FFmpeg not found in PATH")
+	}
+	return "installed", nil
+}
+
+// checkCookie 检查 Cookie 是否仍处于登录状态
+// 就绪检查不需要认证，不返回账号的用户名和 mid
+func (h *Handler) checkCookie(ctx context.Context) (string, error) {
+	nav, err := h.apiService.CheckLogin(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !nav.IsLogin {
+		return "", fmt.Errorf("Cookie is not logged in or has expired")
+	}
+	return "logged in", nil
+}
+
+// checkWbiKeys 检查 WBI 密钥是否新鲜，过期或尚未获取时尝试刷新
+func (h *Handler) checkWbiKeys(ctx context.Context) (string, error) {
+	if age, ok := h.apiService.WbiKeysAge(); ok && age < service.WbiKeysTTL {
+		return fmt.Sprintf("age %s", age.Truncate(time.Second)), nil
+	}
+	if _, err := h.apiService.RefreshWbiKeys(ctx); err != nil {
+		return "", fmt.Errorf("Failed to refresh WBI keys: %w", err)
+	}
+	return "refreshed", nil
+}
+
+// checkDisk 检查工作根目录和缓存目录所在磁盘的剩余空间
+// 就绪检查不需要认证，结果中只使用目录的名称（temp、cache），不返回绝对路径
+func (h *Handler) checkDisk(ctx context.Context) (string, error) {
+	cfg := h.config()
+	minFree := uint64(cfg.Server.MinFreeDiskMB) << 20
+
+	dirs := []struct{ name, path string }{{"temp", h.downloader.TempDir()}}
+	if cfg.Download.CacheDir != "" {
+		dirs = append(dirs, struct{ name, path string }{"cache", cfg.Download.CacheDir})
+	}
+
+	var details []string
+	for _, dir := range dirs {
+		free, err := service.FreeDiskSpace(dir.path)
+		if err != nil {
+			return strings.Join(details, ", "), fmt.Errorf("Failed to get free disk space of %s directory: %w", dir.name, err)
+		}
+		details = append(details, fmt.Sprintf("%s: %d MiB free", dir.name, free>>20))
+		if free < minFree {
+			return strings.Join(details, ", "), fmt.Errorf("Free disk space in %s directory below %d MiB", dir.name, cfg.Server.MinFreeDiskMB)
+		}
+	}
+	return strings.Join(details, ", "), nil
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetStore 启用下载记录数据库，并从数据库恢复最近的批量任务，需要在开始处理请求之前调用
+// 参数 db: 已打开的数据库
+// 返回：恢复任务的错误信息
+func (h *Handler) SetStore(db *store.Store) error {
+	h.store = db
+	return h.restoreJobs(context.Background())
+}
+
+// recordDownload 写入一条下载记录，未启用数据库时不做任何事，写入失败只记录日志
+// 参数 d: 下载记录，Outcome 为空时根据 err 填写
+// 参数 err: 下载或写出失败的原因，不为 nil 时结果记为失败（ctx 已取消时记为取消）
+func (h *Handler) recordDownload(ctx context.Context, d store.Download, err error) {
+	if h.store == nil {
+		return
+	}
+	d.Finished = time.Now()
+	if err != nil {
+		d.Error, d.Checksum = err.Error(), ""
+		if d.Outcome == "" || d.Outcome == store.OutcomeSuccess {
+			d.Outcome = store.OutcomeFailed
+			if ctx.Err() != nil {
+				d.Outcome = store.OutcomeCancelled
+			}
+		}
+	} else if d.Outcome == "" {
+		d.Outcome = store.OutcomeSuccess
+	}
+	if err := h.store.AddDownload(context.WithoutCancel(ctx), d); err != nil {
+		logging.FromContext(ctx).Warn("Failed to record download", "bvid", d.Bvid, "error", err)
+	}
+}
+
+// requesterOf 返回请求方标识：使用 API Key 时为 key: 加 Key 的 SHA-256 前 8 位（不保存 Key 本身），否则为 ip: 加客户端 IP
+func requesterOf(c *gin.Context) string {
+	if key := c.GetString(apiKeyContextKey); key != "" {
+		return keyRequester(key)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// isAdmin 判断请求是否可以访问所有请求方的任务、订阅和下载记录：未启用认证或使用管理员 Key
+func (h *Handler) isAdmin(c *gin.Context) bool {
+	return h.auth == nil || !h.auth.Enabled() || c.GetBool(apiKeyAdminContextKey)
+}
+
+// owns 判断请求是否可以访问 requester 创建的任务或订阅
+func (h *Handler) owns(c *gin.Context, requester string) bool {
+	return h.isAdmin(c) || requester == requesterOf(c)
+}
+
+// scopeRequester 返回查询下载记录时使用的请求方过滤条件
+// 未启用认证或使用管理员 Key 时可以查询所有请求方（requester 为空）或指定的请求方，
+// 其余 Key 只能查询自己的记录，指定其他请求方时写入 403 响应
+func (h *Handler) scopeRequester(c *gin.Context, requester string) (string, bool) {
+	if h.isAdmin(c) {
+		return requester, true
+	}
+	own := requesterOf(c)
+	if requester != "" && requester != own {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Only admin API keys can query downloads of other requesters",
+		})
+		return "", false
+	}
+	return own, true
+}
+
+// requireStore 检查是否启用了数据库，未启用时写入 503 响应
+func (h *Handler) requireStore(c *gin.Context) bool {
+	if h.store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Database is not configured (library.database or DATABASE_PATH)",
+		})
+		return false
+	}
+	return true
+}
+
+// parseLimit 解析分页参数 limit 和 offset，无效时写入 400 响应
+func parseLimit(c *gin.Context) (int, int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(store.DefaultLimit)))
+	if err != nil || limit < 1 || limit > store.MaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid limit parameter, expected 1-" + strconv.Itoa(store.MaxLimit),
+		})
+		return 0, 0, false
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid offset parameter",
+		})
+		return 0, 0, false
+	}
+	return limit, offset, true
+}
+
+// History 处理下载历史请求
+// GET /bilibili/history
+// 按完成时间倒序返回下载记录，可以按 bvid、requester、outcome 过滤；启用认证时非管理员 Key 只能查询自己的记录
+func (h *Handler) History(c *gin.Context) {
+	if !h.requireStore(c) {
+		return
+	}
+	limit, offset, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+	requester, ok := h.scopeRequester(c, c.Query("requester"))
+	if !ok {
+		return
+	}
+	downloads, err := h.store.Downloads(c.Request.Context(), store.DownloadQuery{
+		Bvid:      c.Query("bvid"),
+		Requester: requester,
+		Outcome:   c.Query("outcome"),
+		Limit:     limit,
+		Offset:    offset,
+	})
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"downloads": downloads,
+	})
+}
+
+// Library 处理视频库查询请求
+// GET /bilibili/library
+// 按完成时间倒序返回成功保存到视频库的下载，可以按 bvid 查询某个视频是否已经下载过；
+// 启用认证时非管理员 Key 只能看到自己创建的任务保存的文件
+func (h *Handler) Library(c *gin.Context) {
+	if !h.requireStore(c) {
+		return
+	}
+	limit, offset, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+	requester, ok := h.scopeRequester(c, "")
+	if !ok {
+		return
+	}
+	downloads, err := h.store.Downloads(c.Request.Context(), store.DownloadQuery{
+		Bvid:      c.Query("bvid"),
+		Requester: requester,
+		Outcome:   store.OutcomeSuccess,
+		Library:   true,
+		Limit:     limit,
+		Offset:    offset,
+	})
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"downloads": downloads,
+	})
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"bilibili-downloader-server/config"
+	"bilibili-downloader-server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHistoryScopedToRequester(t *testing.T) {
+	keys := []config.ApiKeyConfig{{Key: "alice"}, {Key: "bob"}, {Key: "root", Admin: true}}
+	h, auth := newTestHandler(t, keys, &fakeBilibili{handle: func(*http.Request) string { return "" }})
+	db, err := store.Open(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h.store = db
+
+	alice, bob := keyRequester("alice"), keyRequester("bob")
+	now := time.Now()
+	for _, d := range []store.Download{
+		{Bvid: "BV1alice", Page: 1, Path: "Alice.mp4", Requester: alice, Source: "favorites:1"},
+		{Bvid: "BV1bob", Page: 1, Path: "Bob.mp4", Requester: bob, Source: "favorites:2"},
+		{Bvid: "BV1direct", Page: 1, Requester: bob, Source: "request"},
+	} {
+		d.Outcome, d.Started, d.Finished = store.OutcomeSuccess, now, now
+		if err := db.AddDownload(context.Background(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/bilibili/history", auth.Authenticate(), h.History)
+	router.GET("/bilibili/library", auth.Authenticate(), h.Library)
+
+	tests := []struct {
+		name   string
+		key    string
+		url    string
+		status int
+		bvids  []string
+	}{
+		{"own history", "alice", "/bilibili/history", http.StatusOK, []string{"BV1alice"}},
+		{"own requester filter", "bob", "/bilibili/history?requester=" + bob, http.StatusOK, []string{"BV1bob", "BV1direct"}},
+		{"other requester", "alice", "/bilibili/history?requester=" + bob, http.StatusForbidden, nil},
+		{"own library", "bob", "/bilibili/library", http.StatusOK, []string{"BV1bob"}},
+		{"library of other requester", "alice", "/bilibili/library?bvid=BV1bob", http.StatusOK, []string{}},
+		{"admin history", "root", "/bilibili/history", http.StatusOK, []string{"BV1alice", "BV1bob", "BV1direct"}},
+		{"admin requester filter", "root", "/bilibili/history?requester=" + alice, http.StatusOK, []string{"BV1alice"}},
+		{"admin library", "root", "/bilibili/library", http.StatusOK, []string{"BV1alice", "BV1bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d, body %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Downloads []store.Download `json:"downloads"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			bvids := []string{}
+			for _, d := range resp.Downloads {
+				bvids = append(bvids, d.Bvid)
+			}
+			sort.Strings(bvids)
+			if !slices.Equal(bvids, tt.bvids) {
+				t.Errorf("downloads = %v, want %v", bvids, tt.bvids)
+			}
+		})
+	}
+}
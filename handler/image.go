@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bilibili-downloader-server/service"
+	"bilibili-downloader-server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cover 处理封面请求
+// GET /bilibili/cover/:id
+// 不带 p 参数时返回视频封面，带 p 参数时返回该分 P 的第一帧截图（没有时退回视频封面），
+// 可以通过 width、height、format 参数缩放和转换格式
+func (h *Handler) Cover(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing video ID parameter",
+		})
+		return
+	}
+
+	// 获取 URL 参数 p（分 P 页码），为空时返回视频封面
+	page := 0
+	if p := c.Query("p"); p != "" {
+		var ok bool
+		if page, ok = parsePage(c, p); !ok {
+			return
+		}
+	}
+	opts, ok := imageOptions(c)
+	if !ok {
+		return
+	}
+
+	bvid, ok := h.resolveBvid(c, id, max(page, 1))
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	info, err := h.apiService.GetVideoInfo(ctx, bvid)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get video info: %w", err))
+		return
+	}
+
+	imageUrl := info.Pic
+	name := bvid
+	if page > 0 {
+		p, ok := info.Page(page)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": fmt.Sprintf("Page %d not found", page),
+			})
+			return
+		}
+		if p.FirstFrame != "" {
+			imageUrl = p.FirstFrame
+		}
+		name = fmt.Sprintf("%s_p%d", bvid, page)
+	}
+	if imageUrl == "" {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Video has no cover",
+		})
+		return
+	}
+
+	data, err := h.downloader.FetchImage(ctx, httpsUrl(imageUrl), opts)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get cover: %w", err))
+		return
+	}
+	writeImage(c, name, opts.Format, data)
+}
+
+// Thumbnail 处理缩略图请求
+// GET /bilibili/thumbnail/:id
+// 从 DASH 视频流（或 durl 分段）中截取 t 秒处的一帧，FFmpeg 通过 Range 请求只读取所需的片段，
+// 和下载共用并发名额和配额
+func (h *Handler) Thumbnail(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing video ID parameter",
+		})
+		return
+	}
+	if h.rejectDraining(c) {
+		return
+	}
+
+	// 获取 URL 参数 p（分 P 页码）、t（截取时间，秒）和 quality（清晰度）
+	page, ok := parsePage(c, c.DefaultQuery("p", "1"))
+	if !ok {
+		return
+	}
+	sec, err := strconv.ParseFloat(c.Query("t"), 64)
+	if err != nil || !(sec >= 0) || math.IsInf(sec, 0) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid t parameter, expected a non-negative number of seconds",
+		})
+		return
+	}
+	at := time.Duration(sec * float64(time.Second))
+	qn, err := strconv.Atoi(c.DefaultQuery("quality", strconv.Itoa(h.config().Download.DefaultQuality)))
+	if err != nil || qn < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid quality parameter",
+		})
+		return
+	}
+	opts, ok := imageOptions(c)
+	if !ok {
+		return
+	}
+
+	bvid, ok := h.resolveBvid(c, id, page)
+	if !ok {
+		return
+	}
+	release, ok := h.waitForSlots(c)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx := c.Request.Context()
+	cid, err := h.apiService.GetCid(ctx, bvid, page)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get CID: %w", err))
+		return
+	}
+
+	playUrlData, err := h.apiService.GetPlayUrl(ctx, bvid, cid, qn)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get play URL: %w", err))
+		return
+	}
+	if duration := playUrlData.Duration(); duration > 0 && at >= duration {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Parameter t exceeds video duration (%ds)", int(duration.Seconds())),
+		})
+		return
+	}
+
+	// 优先选择 AVC 轨道，解码最快；没有 DASH 格式时从包含该时间的 durl 分段中截取
+	var videoUrl string
+	if len(playUrlData.Dash.Video) > 0 {
+		track := service.SelectVideoTrack(playUrlData.Dash.Video, playUrlData.Quality, "avc")
+		videoUrl = service.GetVideoUrl(track)
+	} else if segment, segStart, ok := service.SegmentAt(playUrlData.Segments(), at); ok {
+		videoUrl, at = segment.Url, at-segStart
+	} else {
+		h.handleError(c, fmt.Errorf("No video stream found"))
+		return
+	}
+	data, err := h.downloader.Thumbnail(ctx, videoUrl, bvid, at, opts)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to extract thumbnail: %w", err))
+		return
+	}
+
+	name := fmt.Sprintf("%s_%d", bvid, int(sec))
+	if page > 1 {
+		name = fmt.Sprintf("%s_p%d_%d", bvid, page, int(sec))
+	}
+	writeImage(c, name, opts.Format, data)
+}
+
+// imageOptions 解析图片参数 format、width 和 height
+// 参数 c: Gin 上下文，参数无效时写入 400 响应
+// 返回：图片选项和是否有效
+func imageOptions(c *gin.Context) (service.ImageOptions, bool) {
+	format, ok := service.LookupImageFormat(strings.ToLower(c.DefaultQuery("format", service.DefaultImageFormat)))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid format parameter, expected one of: " + strings.Join(service.ImageFormatNames(), ", "),
+		})
+		return service.ImageOptions{}, false
+	}
+	opts := service.ImageOptions{Format: format}
+
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{
+		{"width", &opts.Width},
+		{"height", &opts.Height},
+	} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > service.MaxImageSize {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid %s parameter, expected 1-%d", p.name, service.MaxImageSize),
+			})
+			return opts, false
+		}
+		*p.dst = n
+	}
+	return opts, true
+}
+
+// writeImage 返回图片，封面和截图不会变化，允许客户端缓存
+func writeImage(c *gin.Context, name string, format service.ImageFormat, data []byte) {
+	filename := name + "." + format.Extension
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Header("Content-Disposition", utils.InlineContentDisposition(filename, filename))
+	c.Data(http.StatusOK, format.ContentType, data)
+}
+
+// httpsUrl 把 http 和省略协议的图片地址转换为 https
+func httpsUrl(rawUrl string) string {
+	switch {
+	case strings.HasPrefix(rawUrl, "//"):
+		return "https:" + rawUrl
+	case strings.HasPrefix(rawUrl, "http://"):
+		return "https://" + strings.TrimPrefix(rawUrl, "http://")
+	}
+	return rawUrl
+}
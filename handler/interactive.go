@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/service"
+	"bilibili-downloader-server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Interactive 处理互动视频剧情图请求
+// GET /bilibili/interactive/:id
+// 遍历剧情图，返回节点、选项和每个节点对应的 CID
+func (h *Handler) Interactive(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing video ID parameter",
+		})
+		return
+	}
+
+	bvid, ok := h.resolveBvid(c, id, 1)
+	if !ok {
+		return
+	}
+	graph, err := h.storyGraph(c.Request.Context(), bvid)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, graph)
+}
+
+// storyManifest 互动视频 ZIP 中的 manifest.json
+type storyManifest struct {
+	*service.StoryGraph
+	Nodes []storyManifestNode `json:"nodes"`
+}
+
+// storyManifestNode 剧情图节点和对应的文件
+type storyManifestNode struct {
+	service.StoryNode
+	File  string `json:"file,omitempty"`  // ZIP 中的文件名，多个节点可能指向同一个文件
+	Error string `json:"error,omitempty"` // 下载失败的原因
+}
+
+// InteractiveDownload 处理互动视频下载请求
+// GET /bilibili/interactive/:id/download
+// 按剧情图顺序下载每个节点的视频（相同 CID 只下载一次），和描述分支的 manifest.json 一起打包为 ZIP 返回
+// ZIP 以流式写出，开始写出后单个节点失败只记录在 manifest 中
+func (h *Handler) InteractiveDownload(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing video ID parameter",
+		})
+		return
+	}
+
+	// 关闭流程中不再接受新的下载
+	if h.draining.Load() {
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Server is shutting down",
+		})
+		return
+	}
+
+	// 获取 URL 参数 quality（清晰度）、codec（视频编码偏好）、format（输出容器）和 metadata（是否写入元数据）
+	opts, ok := parseJobOptions(c, h.config())
+	if !ok {
+		return
+	}
+	format, _ := service.LookupFormat(opts.Format)
+
+	bvid, ok := h.resolveBvid(c, id, 1)
+	if !ok {
+		return
+	}
+	graph, err := h.storyGraph(c.Request.Context(), bvid)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	logger := logging.FromContext(ctx).With("bvid", bvid, "qn", opts.Quality, "format", format.Name)
+	// 整个请求占用一个客户端 IP 名额，每个节点下载时再获取全局下载名额
+	release, err := h.ipLimiter.Acquire(ctx, c.ClientIP(), nil)
+	if err != nil {
+		logger.Warn("Interactive download cancelled while queued", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Request cancelled while queued: " + err.Error(),
+		})
+		return
+	}
+	defer release()
+
+	name := bvid
+	if graph.Title != "" {
+		name = fmt.Sprintf("%s [%s]", graph.Title, bvid)
+	}
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", utils.ContentDisposition(utils.SanitizeFilename(name+".zip"), bvid+".zip"))
+	c.Status(http.StatusOK)
+
+	start := time.Now()
+	requester := requesterOf(c)
+	archive := zip.NewWriter(c.Writer)
+	manifest := storyManifest{StoryGraph: graph}
+	files := map[int64]string{}
+	failures := map[int64]string{}
+	// refused 配额用完时的错误信息，之后的节点不再下载
+	refused := ""
+	for i, node := range graph.Nodes {
+		entry := storyManifestNode{StoryNode: node}
+		if file, ok := files[node.Cid]; ok {
+			entry.File = file
+		} else if msg, ok := failures[node.Cid]; ok {
+			entry.Error = msg
+		} else if refused != "" {
+			entry.Error = refused
+		} else if ctx.Err() == nil {
+			// 每个节点和批量任务中的分 P 一样计入一次下载配额
+			if h.auth != nil {
+				if err := h.auth.Charge(requester); err != nil {
+					logger.Warn("Interactive download quota exceeded", "edge_id", node.EdgeId, "cid", node.Cid, "error", err)
+					refused = err.Error()
+					entry.Error = refused
+					manifest.Nodes = append(manifest.Nodes, entry)
+					continue
+				}
+			}
+			file := utils.SanitizeFilename(fmt.Sprintf("%03d %s.%s", i+1, node.Title, format.Extension))
+			video, err := h.downloadStoryNode(ctx, requester, opts.request(bvid, 1, node.Cid))
+			if err != nil {
+				logger.Warn("Interactive node download failed", "edge_id", node.EdgeId, "cid", node.Cid, "error", err)
+				failures[node.Cid] = err.Error()
+				entry.Error = err.Error()
+			} else {
+				err = writeStoryNode(archive, file, video)
+				video.Close()
+				os.Remove(video.Name())
+				if err != nil {
+					// ZIP 已经写出了不完整的条目，只能中止整个响应
+					logger.Warn("Failed to write interactive archive", "error", err)
+					return
+				}
+				files[node.Cid] = file
+				entry.File = file
+			}
+		}
+		manifest.Nodes = append(manifest.Nodes, entry)
+	}
+
+	if ctx.Err() != nil {
+		logger.Warn("Interactive download aborted", "error", ctx.Err())
+		return
+	}
+	w, err := archive.Create("manifest.json")
+	if err == nil {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(manifest)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		logger.Warn("Failed to write interactive archive", "error", err)
+		return
+	}
+	logger.Info("Interactive download finished",
+		"nodes", len(graph.Nodes),
+		"files", len(files),
+		"failed", len(failures),
+		"refused", refused != "",
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+// storyGraph 获取互动视频的剧情图并填写视频标题
+func (h *Handler) storyGraph(ctx context.Context, bvid string) (*service.StoryGraph, error) {
+	info, err := h.apiService.GetVideoInfo(ctx, bvid)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get video info: %w", err)
+	}
+	if len(info.Pages) == 0 {
+		return nil, fmt.Errorf("Video has no pages")
+	}
+	graph, err := h.apiService.GetStoryGraph(ctx, bvid, info.Pages[0].Cid)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get story graph: %w", err)
+	}
+	graph.Title = info.Title
+	return graph, nil
+}
+
+// downloadStoryNode 获取全局下载名额，把一个节点的视频完整下载到临时文件，并为请求方累计写入的字节数
+// 节点下载失败时不会在 ZIP 中留下不完整的条目
+// 返回：读写位置在开头的临时文件，调用方负责关闭和删除
+func (h *Handler) downloadStoryNode(ctx context.Context, requester string, r downloadRequest) (*os.File, error) {
+	var tmp *os.File
+	err := h.runDownload(ctx, requester, func() (int64, error) {
+		video, err := h.downloadVideo(ctx, r)
+		if err != nil {
+			return 0, err
+		}
+		defer video.Close()
+
+		dir := h.downloader.TempDir()
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return 0, fmt.Errorf("Failed to create temp directory: %w", err)
+		}
+		if tmp, err = os.CreateTemp(dir, "interactive-*"); err != nil {
+			return 0, fmt.Errorf("Failed to create temp file: %w", err)
+		}
+		n, err := io.Copy(tmp, video)
+		if err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return n, fmt.Errorf("Failed to download node: %w", err)
+		}
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// writeStoryNode 把完整下载的节点视频写入 ZIP，视频已经压缩，直接存储不再压缩
+func writeStoryNode(archive *zip.Writer, file string, video io.Reader) error {
+	w, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     file,
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, video)
+	return err
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bilibili-downloader-server/config"
+	"bilibili-downloader-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInteractiveDownloadChargesQuotaPerNode(t *testing.T) {
+	// 起始节点 1 有两个选项，分别指向节点 2 和 3，三个节点的 CID 各不相同
+	api := &fakeBilibili{handle: func(r *http.Request) string {
+		switch r.URL.Path {
+		case service.ViewEndpoint:
+			return `{"code":0,"data":{"bvid":"BV1xx411c7mD","title":"Story","pages":[{"cid":101,"page":1,"part":"P1"}]}}`
+		case service.NavEndpoint:
+			return `{"code":0,"data":{"isLogin":true,"wbi_img":{"img_url":"https://i0.hdslb.com/bfs/wbi/7cd084941338484aae1ad9425b84077c.png","sub_url":"https://i0.hdslb.com/bfs/wbi/4932caff0ff746eab6f01bf08b70ac45.png"}}}`
+		case service.PlayerWbiEndpoint:
+			return `{"code":0,"data":{"interaction":{"graph_version":1}}}`
+		case service.StoryEdgeEndpoint:
+			switch r.URL.Query().Get("edge_id") {
+			case "":
+				return `{"code":0,"data":{"title":"Start","edge_id":1,"edges":{"questions":[{"title":"Q","choices":[{"id":2,"cid":102,"option":"A"},{"id":3,"cid":103,"option":"B"}]}]}}}`
+			case "2":
+				return `{"code":0,"data":{"title":"A","edge_id":2,"is_leaf":1}}`
+			case "3":
+				return `{"code":0,"data":{"title":"B","edge_id":3,"is_leaf":1}}`
+			}
+		}
+		// 播放地址请求失败，已经计入配额的节点下载失败
+		return ""
+	}}
+	keys := []config.ApiKeyConfig{{Key: "secret", DailyDownloads: 2}}
+	h, auth := newTestHandler(t, keys, api)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/bilibili/interactive/:id/download", auth.Quota(), h.InteractiveDownload)
+	download := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/bilibili/interactive/BV1xx411c7mD/download", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := download()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 下载失败的节点不会在 ZIP 中留下条目
+	if len(archive.File) != 1 || archive.File[0].Name != "manifest.json" {
+		names := []string{}
+		for _, f := range archive.File {
+			names = append(names, f.Name)
+		}
+		t.Fatalf("archive entries = %q, want only manifest.json", names)
+	}
+	f, err := archive.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var manifest struct {
+		Nodes []storyManifestNode `json:"nodes"`
+	}
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Nodes) != 3 {
+		t.Fatalf("manifest has %d nodes, want 3", len(manifest.Nodes))
+	}
+	for i, node := range manifest.Nodes[:2] {
+		if node.Error == "" || strings.Contains(node.Error, "quota") {
+			t.Errorf("node %d error = %q, want a download error", i+1, node.Error)
+		}
+	}
+	if !strings.Contains(manifest.Nodes[2].Error, "Daily download quota exceeded") {
+		t.Errorf("node 3 error = %q, want quota refusal", manifest.Nodes[2].Error)
+	}
+	// 配额用完后不再为剩余的节点请求播放地址
+	for i, cid := range []int64{101, 102, 103} {
+		want := 1
+		if i == 2 {
+			want = 0
+		}
+		if n := api.count(service.PlayUrlEndpoint, fmt.Sprintf("cid=%d", cid)); n != want {
+			t.Errorf("play URL requests for cid %d = %d, want %d", cid, n, want)
+		}
+	}
+
+	// 当日配额用完后直接返回 429，不再获取剧情图
+	if w := download(); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second download: status %d, want 429", w.Code)
+	}
+}
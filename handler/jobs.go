@@ -0,0 +1,755 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bilibili-downloader-server/config"
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/metrics"
+	"bilibili-downloader-server/service"
+	"bilibili-downloader-server/store"
+	"bilibili-downloader-server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// jobsPollInterval 关闭时检查批量任务是否全部结束的间隔
+	jobsPollInterval = 100 * time.Millisecond
+	// abortJobsGrace 强制取消批量任务后等待任务记录结束状态的时间
+	abortJobsGrace = 5 * time.Second
+)
+
+// JobStatus 批量任务和任务条目的状态
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"    // 等待执行
+	JobRunning   JobStatus = "running"   // 执行中
+	JobDone      JobStatus = "done"      // 全部条目下载成功或已存在
+	JobFailed    JobStatus = "failed"    // 有条目下载失败
+	JobCancelled JobStatus = "cancelled" // 被取消或服务关闭
+	JobSkipped   JobStatus = "skipped"   // 条目的文件已存在于视频库中，或之前已经下载过
+)
+
+// finished 判断任务是否已经结束
+func (s JobStatus) finished() bool {
+	return s == JobDone || s == JobFailed || s == JobCancelled
+}
+
+// JobOptions 批量任务的下载参数
+type JobOptions struct {
+	Quality  int    `json:"quality"`
+	Codec    string `json:"codec,omitempty"`
+	Format   string `json:"format"`
+	Metadata bool   `json:"metadata"`
+}
+
+// request 生成单个分 P 的下载参数，容器对视频编码有要求时覆盖编码偏好
+func (o JobOptions) request(bvid string, page int, cid int64) downloadRequest {
+	format, _ := service.LookupFormat(o.Format)
+	codec := o.Codec
+	if format.RequiredCodec != "" {
+		codec = format.RequiredCodec
+	}
+	return downloadRequest{
+		bvid:         bvid,
+		page:         page,
+		cid:          cid,
+		quality:      o.Quality,
+		codec:        codec,
+		withMetadata: o.Metadata,
+		opts:         service.MergeOptions{Format: format},
+	}
+}
+
+// parseJobOptions 解析批量下载参数 quality、codec、format 和 metadata，未指定时使用配置中的默认值
+// 参数 c: Gin 上下文，参数无效时写入 400 响应
+// 参数 cfg: 服务配置
+// 返回：下载参数和是否有效
+func parseJobOptions(c *gin.Context, cfg *config.Config) (JobOptions, bool) {
+	opts := JobOptions{Codec: c.DefaultQuery("codec", cfg.Download.DefaultCodec)}
+
+	qn, err := strconv.Atoi(c.DefaultQuery("quality", strconv.Itoa(cfg.Download.DefaultQuality)))
+	if err != nil || qn < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid quality parameter",
+		})
+		return opts, false
+	}
+	opts.Quality = qn
+
+	switch opts.Codec {
+	case "", "avc", "hevc", "av1":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid codec parameter",
+		})
+		return opts, false
+	}
+
+	format, ok := service.LookupFormat(strings.ToLower(c.DefaultQuery("format", cfg.Download.DefaultFormat)))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid format parameter, expected one of: " + strings.Join(service.FormatNames(), ", "),
+		})
+		return opts, false
+	}
+	opts.Format = format.Name
+
+	if opts.Metadata, err = strconv.ParseBool(c.DefaultQuery("metadata", strconv.FormatBool(cfg.Download.EmbedMetadata))); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid metadata parameter",
+		})
+		return opts, false
+	}
+	return opts, true
+}
+
+// JobItem 批量任务中的一个视频，多 P 视频的每个分 P 保存为单独的文件
+type JobItem struct {
+	Index  int       `json:"index,omitempty"` // 在合集中的序号，非 0 时文件名加上序号前缀
+	Bvid   string    `json:"bvid"`
+	Title  string    `json:"title"`
+	Status JobStatus `json:"status"`
+	Files  []string  `json:"files,omitempty"` // 视频库目录中的文件名
+	Bytes  int64     `json:"bytes"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Job 批量下载任务
+type Job struct {
+	Id        string     `json:"id"`
+	Source    string     `json:"source"`              // 任务来源，如 space:<mid>
+	Requester string     `json:"requester,omitempty"` // 创建任务的客户端，见 requesterOf
+	Status    JobStatus  `json:"status"`
+	Options   JobOptions `json:"options"`
+	Created   time.Time  `json:"created"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Items     []*JobItem `json:"items"`
+
+	cancel context.CancelFunc
+	onDone func(ctx context.Context, item JobItem) // 条目下载成功或已存在时调用，可以为 nil
+	exited bool                                    // 执行任务的 goroutine 已经返回，结束状态已经保存
+}
+
+// jobSummary 任务列表中的任务摘要
+type jobSummary struct {
+	Id       string     `json:"id"`
+	Source   string     `json:"source"`
+	Status   JobStatus  `json:"status"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`   // 下载成功或已存在的条目数
+	Failed   int        `json:"failed"` // 下载失败的条目数
+}
+
+// jobQueue 内存中的批量任务列表，任务状态只在持有锁时读写
+type jobQueue struct {
+	mu   sync.Mutex
+	jobs []*Job // 按创建时间排序
+}
+
+// add 添加任务，已结束的任务超过 history 个时删除最早的
+func (q *jobQueue) add(job *Job, history int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs = append(q.jobs, job)
+
+	finished := 0
+	for _, j := range q.jobs {
+		if j.exited {
+			finished++
+		}
+	}
+	kept := q.jobs[:0]
+	for _, j := range q.jobs {
+		if finished > history && j.exited {
+			finished--
+			continue
+		}
+		kept = append(kept, j)
+	}
+	q.jobs = kept
+}
+
+// update 在持有锁时修改任务状态
+func (q *jobQueue) update(fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn()
+}
+
+// findLocked 查找任务（调用方需持有锁）
+func (q *jobQueue) findLocked(id string) *Job {
+	for _, j := range q.jobs {
+		if j.Id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// get 返回任务的副本
+func (q *jobQueue) get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := q.findLocked(id)
+	if j == nil {
+		return Job{}, false
+	}
+	return j.snapshotLocked(), true
+}
+
+// list 返回任务的摘要，最新的任务在前
+// 参数 requester: 只返回该请求方创建的任务，为空时返回全部任务
+func (q *jobQueue) list(requester string) []jobSummary {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	summaries := make([]jobSummary, 0, len(q.jobs))
+	for i := len(q.jobs) - 1; i >= 0; i-- {
+		j := q.jobs[i]
+		if requester != "" && j.Requester != requester {
+			continue
+		}
+		s := jobSummary{
+			Id:       j.Id,
+			Source:   j.Source,
+			Status:   j.Status,
+			Created:  j.Created,
+			Started:  j.Started,
+			Finished: j.Finished,
+			Total:    len(j.Items),
+		}
+		for _, item := range j.Items {
+			switch item.Status {
+			case JobDone, JobSkipped:
+				s.Done++
+			case JobFailed:
+				s.Failed++
+			}
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// cancel 取消任务，返回任务是否存在
+func (q *jobQueue) cancel(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := q.findLocked(id)
+	if j == nil {
+		return false
+	}
+	j.cancel()
+	return true
+}
+
+// active 判断是否有执行任务的 goroutine 还没有返回
+func (q *jobQueue) active() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if !j.exited {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelQueued 取消还没有开始执行的任务
+func (q *jobQueue) cancelQueued() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.Status == JobQueued {
+			j.cancel()
+		}
+	}
+}
+
+// snapshotLocked 深拷贝任务，避免响应序列化时和执行中的任务竞争（调用方需持有锁）
+func (j *Job) snapshotLocked() Job {
+	c := *j
+	c.Items = make([]*JobItem, len(j.Items))
+	for i, item := range j.Items {
+		copied := *item
+		copied.Files = append([]string(nil), item.Files...)
+		c.Items[i] = &copied
+	}
+	return c
+}
+
+// submitJob 创建批量任务并在后台执行，同时执行的任务数受 library.jobs 限制
+// 参数 source: 任务来源
+// 参数 requester: 创建任务的客户端
+// 参数 opts: 下载参数
+// 参数 items: 要下载的视频，状态已经是 skipped 的条目不会下载
+// 参数 onDone: 条目下载成功或文件已存在时调用（在任务的 goroutine 中），可以为 nil
+// 返回：新任务的副本
+func (h *Handler) submitJob(source, requester string, opts JobOptions, items []*JobItem, onDone func(ctx context.Context, item JobItem)) Job {
+	ctx, cancel := context.WithCancel(h.jobsCtx)
+	job := &Job{
+		Id:        logging.NewRequestID(),
+		Source:    source,
+		Requester: requester,
+		Status:    JobQueued,
+		Options:   opts,
+		Created:   time.Now(),
+		Items:     items,
+		cancel:    cancel,
+		onDone:    onDone,
+	}
+	for _, item := range items {
+		if item.Status != JobSkipped {
+			item.Status = JobQueued
+		}
+	}
+	h.jobs.add(job, h.config().Library.History)
+
+	ctx = logging.WithRequestID(ctx, job.Id)
+	snapshot := h.saveJob(ctx, job)
+	go h.runJob(ctx, job)
+	return snapshot
+}
+
+// saveJob 返回任务的副本，启用数据库时同时保存任务，任务结束时删除超出 library.history 的已结束任务
+// 保存失败只记录日志
+func (h *Handler) saveJob(ctx context.Context, job *Job) Job {
+	var snapshot Job
+	h.jobs.update(func() { snapshot = job.snapshotLocked() })
+	if h.store == nil {
+		return snapshot
+	}
+
+	record := store.JobRecord{Id: snapshot.Id, Source: snapshot.Source, Status: string(snapshot.Status), Created: snapshot.Created}
+	if snapshot.Finished != nil {
+		record.Finished = *snapshot.Finished
+	}
+	data, err := json.Marshal(snapshot)
+	if err == nil {
+		record.Data = data
+		err = h.store.SaveJob(context.WithoutCancel(ctx), record)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to save job", "job", job.Id, "error", err)
+		return snapshot
+	}
+	if snapshot.Finished != nil {
+		if _, err := h.store.PruneJobs(context.WithoutCancel(ctx), h.config().Library.History); err != nil {
+			logging.FromContext(ctx).Warn("Failed to prune jobs", "error", err)
+		}
+	}
+	return snapshot
+}
+
+// restoreJobs 从数据库恢复最近的批量任务，服务重启前未结束的任务标记为取消
+func (h *Handler) restoreJobs(ctx context.Context) error {
+	records, err := h.store.Jobs(ctx, h.config().Library.History)
+	if err != nil {
+		return err
+	}
+	// 数据库按创建时间倒序返回，队列按创建时间正序排列
+	for i := len(records) - 1; i >= 0; i-- {
+		job := &Job{}
+		if err := json.Unmarshal(records[i].Data, job); err != nil {
+			logging.FromContext(ctx).Warn("Skipping unreadable job", "job", records[i].Id, "error", err)
+			continue
+		}
+		job.cancel, job.exited = func() {}, true
+		interrupted := !job.Status.finished()
+		if interrupted {
+			now := time.Now()
+			job.Status, job.Finished = JobCancelled, &now
+			for _, item := range job.Items {
+				if item.Status == JobQueued || item.Status == JobRunning {
+					item.Status, item.Error = JobCancelled, "Interrupted by server restart"
+				}
+			}
+		}
+		h.jobs.add(job, h.config().Library.History)
+		if interrupted {
+			h.saveJob(ctx, job)
+		}
+	}
+	return nil
+}
+
+// runJob 依次下载任务中的视频，单个视频失败时继续下载其余视频
+func (h *Handler) runJob(ctx context.Context, job *Job) {
+	logger := logging.FromContext(ctx).With("job", job.Id, "source", job.Source)
+	defer job.cancel()
+	defer h.jobs.update(func() { job.exited = true })
+
+	release, err := h.jobLimiter.Acquire(ctx, nil)
+	if err != nil {
+		h.finishJob(ctx, job, JobCancelled)
+		logger.Info("Job cancelled while queued")
+		return
+	}
+	defer release()
+	// 关闭流程中不再开始新的任务
+	if h.draining.Load() {
+		h.finishJob(ctx, job, JobCancelled)
+		logger.Info("Job cancelled while queued, server is shutting down")
+		return
+	}
+
+	h.jobs.update(func() {
+		now := time.Now()
+		job.Status, job.Started = JobRunning, &now
+	})
+	h.saveJob(ctx, job)
+	logger.Info("Job started", "items", len(job.Items))
+
+	status := JobDone
+	for _, item := range job.Items {
+		if ctx.Err() != nil {
+			status = JobCancelled
+			break
+		}
+		var done JobItem
+		h.jobs.update(func() {
+			if item.Status == JobQueued {
+				item.Status = JobRunning
+			}
+			done = *item
+		})
+		if done.Status == JobSkipped {
+			continue
+		}
+
+		files, size, skipped, err := h.downloadToLibrary(ctx, job, done)
+		h.jobs.update(func() {
+			item.Files, item.Bytes = files, size
+			switch {
+			case err != nil && ctx.Err() != nil:
+				item.Status, item.Error = JobCancelled, ctx.Err().Error()
+			case err != nil:
+				item.Status, item.Error = JobFailed, err.Error()
+			case skipped:
+				item.Status = JobSkipped
+			default:
+				item.Status = JobDone
+			}
+			done = *item
+		})
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("Job item failed", "bvid", item.Bvid, "error", err)
+			status = JobFailed
+		}
+		if err == nil && job.onDone != nil {
+			job.onDone(ctx, done)
+		}
+		h.saveJob(ctx, job)
+	}
+	if ctx.Err() != nil {
+		status = JobCancelled
+	}
+	h.finishJob(ctx, job, status)
+	logger.Info("Job finished", "status", status)
+}
+
+// finishJob 记录任务结束状态，未执行的条目标记为取消
+func (h *Handler) finishJob(ctx context.Context, job *Job, status JobStatus) {
+	h.jobs.update(func() {
+		now := time.Now()
+		job.Status, job.Finished = status, &now
+		for _, item := range job.Items {
+			if item.Status == JobQueued || item.Status == JobRunning {
+				item.Status = JobCancelled
+			}
+		}
+	})
+	h.saveJob(ctx, job)
+}
+
+// downloadToLibrary 下载视频的全部分 P 到视频库目录，按配置的文件名模板命名，已存在的文件跳过
+// 条目在合集中的序号非 0 时文件名加上三位数字的序号前缀以保持顺序；启用数据库时记录每个分 P 的下载结果
+// 参数 ctx: 上下文，任务取消时中断下载
+// 参数 job: 所属的任务，只读取创建后不再修改的字段
+// 参数 item: 要下载的条目
+// 返回：文件名列表、新下载的字节数、是否全部已存在和错误信息
+func (h *Handler) downloadToLibrary(ctx context.Context, job *Job, item JobItem) ([]string, int64, bool, error) {
+	bvid, index, opts := item.Bvid, item.Index, job.Options
+	cfg := h.config()
+	dir := cfg.Library.Dir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, 0, false, fmt.Errorf("Failed to create library directory: %w", err)
+	}
+
+	// 配额已经用完时不再请求 Bilibili，剩余的条目直接失败
+	if h.auth != nil {
+		if err := h.auth.Check(job.Requester); err != nil {
+			return nil, 0, false, err
+		}
+	}
+
+	info, err := h.apiService.GetVideoInfo(ctx, bvid)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("Failed to get video info: %w", err)
+	}
+
+	var files []string
+	var total int64
+	skipped := true
+	existing := h.libraryFiles(ctx, dir, bvid)
+	for _, p := range info.Pages {
+		r := opts.request(bvid, p.Page, p.Cid)
+		format := r.opts.Format
+
+		// 之前下载过的分 P 按记录中的文件名判断，文件名取决于下载时的标题和实际清晰度，不能重新生成
+		if name, ok := existing[p.Page]; ok {
+			files = append(files, name)
+			continue
+		}
+		// 没有记录时（如记录功能加入之前下载的文件）按当前标题和请求的清晰度生成文件名判断
+		name := libraryFilename(cfg.Download.FilenameTemplate, bvid, p.Page, index, format, &videoDownload{info: info, quality: opts.Quality})
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			files = append(files, name)
+			continue
+		}
+		skipped = false
+
+		record := store.Download{
+			Bvid:      bvid,
+			Cid:       p.Cid,
+			Page:      p.Page,
+			Quality:   opts.Quality,
+			Codec:     r.codec,
+			Format:    format.Name,
+			Requester: job.Requester,
+			Source:    job.Source,
+			Started:   time.Now(),
+		}
+		err := h.downloadPage(ctx, job.Requester, func() (int64, error) {
+			video, err := h.downloadVideo(ctx, r)
+			if err != nil {
+				return 0, err
+			}
+			defer video.Close()
+			name = libraryFilename(cfg.Download.FilenameTemplate, bvid, p.Page, index, format, video)
+			record.Quality, record.Codec, record.Cached = video.quality, video.codec, video.cached
+			record.Size, record.Checksum, err = writeLibraryFile(filepath.Join(dir, name), video)
+			return record.Size, err
+		})
+		if err == nil {
+			record.Path = name
+			h.indexLibraryFile(ctx, dir, bvid, p.Page, name)
+		}
+		h.recordDownload(ctx, record, err)
+		if err != nil {
+			return files, total, false, fmt.Errorf("P%d: %w", p.Page, err)
+		}
+		files = append(files, name)
+		total += record.Size
+	}
+	return files, total, skipped, nil
+}
+
+// libraryIndexPath 返回未启用数据库时记录视频已下载文件名的索引路径
+func libraryIndexPath(dir, bvid string) string {
+	return filepath.Join(dir, ".library", bvid+".json")
+}
+
+// libraryFiles 返回视频已经保存到视频库的文件名（按分 P 页码索引），文件已被删除的分 P 不包含在内
+// 启用数据库时查询下载记录，同时读取未启用数据库时写入的索引
+func (h *Handler) libraryFiles(ctx context.Context, dir, bvid string) map[int]string {
+	logger := logging.FromContext(ctx)
+	files := map[int]string{}
+
+	h.libraryMu.Lock()
+	data, err := os.ReadFile(libraryIndexPath(dir, bvid))
+	h.libraryMu.Unlock()
+	if err == nil {
+		err = json.Unmarshal(data, &files)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("Failed to read library index", "bvid", bvid, "error", err)
+	}
+	if h.store != nil {
+		recorded, err := h.store.LibraryFiles(ctx, bvid)
+		if err != nil {
+			logger.Warn("Failed to query library files", "bvid", bvid, "error", err)
+		}
+		for page, name := range recorded {
+			files[page] = name
+		}
+	}
+
+	for page, name := range files {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			delete(files, page)
+		}
+	}
+	return files
+}
+
+// indexLibraryFile 未启用数据库时把下载完成的文件名写入索引，写入失败只记录日志
+// 启用数据库时文件名记录在下载记录中，不写入索引
+func (h *Handler) indexLibraryFile(ctx context.Context, dir, bvid string, page int, name string) {
+	if h.store != nil {
+		return
+	}
+	h.libraryMu.Lock()
+	defer h.libraryMu.Unlock()
+
+	err := func() error {
+		path := libraryIndexPath(dir, bvid)
+		files := map[int]string{}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &files)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		files[page] = name
+
+		if data, err = json.Marshal(files); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		tmp := path + ".part"
+		if err := os.WriteFile(tmp, data, 0o644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}()
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to write library index", "bvid", bvid, "error", err)
+	}
+}
+
+// downloadPage 按创建任务的 API Key 累计一次下载，获取全局下载名额后执行 fn，完成后累计写出的字节数
+// 批量任务中的每个分 P 和直接下载一样受 limits.downloads 和每日配额限制（每个任务本身还受 library.jobs 限制）
+// 参数 requester: 创建任务的客户端
+// 参数 fn: 下载并写入视频库，返回写入的字节数
+func (h *Handler) downloadPage(ctx context.Context, requester string, fn func() (int64, error)) error {
+	if h.auth != nil {
+		if err := h.auth.Charge(requester); err != nil {
+			return err
+		}
+	}
+	return h.runDownload(ctx, requester, fn)
+}
+
+// runDownload 获取全局下载名额后执行 fn，完成后为请求方累计写出的字节数，下载次数由调用方累计
+func (h *Handler) runDownload(ctx context.Context, requester string, fn func() (int64, error)) error {
+	release, err := h.downloadLimiter.Acquire(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	metrics.DownloadsInFlight.Inc()
+	defer metrics.DownloadsInFlight.Dec()
+	n, err := fn()
+	if h.auth != nil && n > 0 {
+		h.auth.ChargeBytes(requester, n)
+	}
+	return err
+}
+
+// libraryFilename 按文件名模板生成视频库中的文件名，index 非 0 时加上序号前缀
+func libraryFilename(tmpl, bvid string, page, index int, format service.OutputFormat, video *videoDownload) string {
+	name := downloadFilename(tmpl, bvid, page, format, nil, video)
+	if index > 0 {
+		name = utils.SanitizeFilename(fmt.Sprintf("%03d %s", index, name))
+	}
+	return name
+}
+
+// writeLibraryFile 先写入临时文件再重命名，避免中断时留下不完整的文件
+// 返回：写入的字节数、文件的 SHA-256 和错误信息
+func writeLibraryFile(path string, r io.Reader) (int64, string, error) {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".part")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return 0, "", fmt.Errorf("Failed to create file: %w", err)
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(file, hash), r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, "", fmt.Errorf("Failed to write file: %w", err)
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// requireLibrary 检查是否配置了视频库目录，未配置时写入 503 响应
+func (h *Handler) requireLibrary(c *gin.Context) bool {
+	if h.config().Library.Dir == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Library directory is not configured (library.dir or LIBRARY_DIR)",
+		})
+		return false
+	}
+	return !h.rejectDraining(c)
+}
+
+// Jobs 处理批量任务列表请求
+// GET /bilibili/jobs
+// 启用认证时非管理员 Key 只能看到自己创建的任务
+func (h *Handler) Jobs(c *gin.Context) {
+	requester := ""
+	if !h.isAdmin(c) {
+		requester = requesterOf(c)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": h.jobs.list(requester),
+	})
+}
+
+// Job 处理批量任务详情请求
+// GET /bilibili/jobs/:id
+// 其他请求方创建的任务按不存在处理（管理员 Key 除外）
+func (h *Handler) Job(c *gin.Context) {
+	job, ok := h.jobs.get(c.Param("id"))
+	if !ok || !h.owns(c, job.Requester) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Job not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob 处理取消批量任务请求，正在下载的视频会被中断
+// DELETE /bilibili/jobs/:id
+// 其他请求方创建的任务按不存在处理（管理员 Key 除外）
+func (h *Handler) CancelJob(c *gin.Context) {
+	if job, ok := h.jobs.get(c.Param("id")); !ok || !h.owns(c, job.Requester) || !h.jobs.cancel(job.Id) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Job not found",
+		})
+		return
+	}
+	job, _ := h.jobs.get(c.Param("id"))
+	c.JSON(http.StatusAccepted, job)
+}
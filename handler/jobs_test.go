@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bilibili-downloader-server/config"
+	"bilibili-downloader-server/service"
+	"bilibili-downloader-server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeBilibili 按路径返回固定响应的 Bilibili API，记录每个请求的路径和参数
+type fakeBilibili struct {
+	mu       sync.Mutex
+	requests []string
+	handle   func(r *http.Request) string
+}
+
+func (f *fakeBilibili) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.URL.Path+"?"+r.URL.RawQuery)
+	f.mu.Unlock()
+
+	body := f.handle(r)
+	if body == "" {
+		body = `{"code":-404,"message":"not found"}`
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+// count 返回路径为 path 且参数包含 query 的请求数
+func (f *fakeBilibili) count(path, query string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, path+"?") && strings.Contains(r, query) {
+			n++
+		}
+	}
+	return n
+}
+
+// newTestHandler 创建使用 fake API 的 Handler，视频库目录为临时目录
+func newTestHandler(t *testing.T, keys []config.ApiKeyConfig, api *fakeBilibili) (*Handler, *Auth) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Library.Dir = t.TempDir()
+	cfg.Download.TempDir = t.TempDir()
+	cfg.Auth.ApiKeys = keys
+
+	h := NewHandler(cfg)
+	h.apiService.GetHttpClient().Transport = api
+	auth := NewAuth(keys)
+	h.SetAuth(auth)
+	t.Cleanup(h.Abort)
+	return h, auth
+}
+
+// waitJob 等待任务结束
+func waitJob(t *testing.T, h *Handler, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := h.jobs.get(id); ok && job.Status.finished() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestFavoriteSyncChargesQuotaPerItem(t *testing.T) {
+	const items = 50
+	api := &fakeBilibili{handle: func(r *http.Request) string {
+		switch r.URL.Path {
+		case service.FavResourceListEndpoint:
+			medias := make([]service.FavMedia, items)
+			for i := range medias {
+				medias[i] = service.FavMedia{
+					Id:      int64(i + 1),
+					Type:    service.FavMediaTypeVideo,
+					Title:   fmt.Sprintf("Video %d", i+1),
+					Bvid:    fmt.Sprintf("BV1test%05d", i+1),
+					FavTime: int64(1700000000 + i),
+				}
+			}
+			data, _ := json.Marshal(gin.H{
+				"code": 0,
+				"data": gin.H{"info": gin.H{"id": 1, "title": "Folder", "media_count": items}, "medias": medias},
+			})
+			return string(data)
+		case service.ViewEndpoint:
+			bvid := r.URL.Query().Get("bvid")
+			return fmt.Sprintf(`{"code":0,"data":{"bvid":%q,"title":"Video","pages":[{"cid":1,"page":1,"part":"P1"}]}}`, bvid)
+		}
+		// 播放地址等其余请求失败，第一个视频在累计配额之后下载失败
+		return ""
+	}}
+	keys := []config.ApiKeyConfig{{Key: "secret", DailyDownloads: 1}}
+	h, auth := newTestHandler(t, keys, api)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/bilibili/favorites/:media_id/sync", auth.Quota(), h.FavoriteSync)
+	sync := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/bilibili/favorites/1/sync", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := sync()
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("first sync: status %d, body %s", w.Code, w.Body)
+	}
+	var submitted Job
+	if err := json.Unmarshal(w.Body.Bytes(), &submitted); err != nil {
+		t.Fatal(err)
+	}
+	job := waitJob(t, h, submitted.Id)
+
+	if job.Status != JobFailed {
+		t.Errorf("job status = %s, want %s", job.Status, JobFailed)
+	}
+	if len(job.Items) != items {
+		t.Fatalf("job has %d items, want %d", len(job.Items), items)
+	}
+	for i, item := range job.Items {
+		if item.Status != JobFailed {
+			t.Errorf("item %d status = %s, want %s", i, item.Status, JobFailed)
+		}
+		quota := strings.Contains(item.Error, "Daily download quota exceeded")
+		if i == 0 && quota {
+			t.Errorf("item 0 should use the only download of the day, got %q", item.Error)
+		}
+		if i > 0 && !quota {
+			t.Errorf("item %d error = %q, want quota exceeded", i, item.Error)
+		}
+	}
+	// 配额用完之后的条目不再请求 Bilibili
+	for i := 2; i <= items; i++ {
+		if n := api.count(service.ViewEndpoint, fmt.Sprintf("bvid=BV1test%05d", i)); n > 0 {
+			t.Errorf("video %d was requested %d times after the quota was used up", i, n)
+		}
+	}
+
+	if w := sync(); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second sync: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestLibraryFilesUsesRecordedNames(t *testing.T) {
+	h, _ := newTestHandler(t, nil, &fakeBilibili{handle: func(*http.Request) string { return "" }})
+	dir := h.config().Library.Dir
+	ctx := context.Background()
+
+	// 文件名包含下载时的标题和实际清晰度，和现在生成的文件名不同
+	write := func(name string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("video"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Old title [1080P].mp4")
+	write("Old title P2 [720P].mp4")
+	h.indexLibraryFile(ctx, dir, "BV1test", 1, "Old title [1080P].mp4")
+	h.indexLibraryFile(ctx, dir, "BV1test", 2, "Old title P2 [720P].mp4")
+
+	files := h.libraryFiles(ctx, dir, "BV1test")
+	if files[1] != "Old title [1080P].mp4" || files[2] != "Old title P2 [720P].mp4" {
+		t.Fatalf("files = %v", files)
+	}
+
+	// 文件被删除后重新下载
+	os.Remove(filepath.Join(dir, "Old title P2 [720P].mp4"))
+	if files := h.libraryFiles(ctx, dir, "BV1test"); len(files) != 1 {
+		t.Errorf("files after removal = %v", files)
+	}
+
+	// 启用数据库时按下载记录判断
+	db, err := store.Open(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h.store = db
+	write("Other [4K].mkv")
+	now := time.Now()
+	for _, d := range []store.Download{
+		{Bvid: "BV1other", Page: 1, Path: "Other [1080P].mkv", Outcome: store.OutcomeSuccess, Started: now, Finished: now},
+		{Bvid: "BV1other", Page: 1, Path: "Other [4K].mkv", Outcome: store.OutcomeSuccess, Started: now, Finished: now.Add(time.Second)},
+		{Bvid: "BV1other", Page: 2, Path: "Other P2.mkv", Outcome: store.OutcomeFailed, Started: now, Finished: now},
+	} {
+		if err := db.AddDownload(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := h.libraryFiles(ctx, dir, "BV1other"); len(files) != 1 || files[1] != "Other [4K].mkv" {
+		t.Errorf("files from store = %v", files)
+	}
+}
+
+func TestDrainWaitsForRunningJobs(t *testing.T) {
+	started, unblock := make(chan struct{}, 1), make(chan struct{})
+	api := &fakeBilibili{handle: func(r *http.Request) string {
+		if r.URL.Path == service.ViewEndpoint {
+			started <- struct{}{}
+			select {
+			case <-unblock:
+			case <-r.Context().Done():
+			}
+		}
+		return ""
+	}}
+	h, _ := newTestHandler(t, nil, api)
+	h.jobLimiter.SetLimit(1)
+
+	running := h.submitJob("test", "", JobOptions{Format: "mp4"}, []*JobItem{{Bvid: "BV1running"}}, nil)
+	<-started
+	queued := h.submitJob("test", "", JobOptions{Format: "mp4"}, []*JobItem{{Bvid: "BV1queued"}}, nil)
+
+	h.Drain()
+	if job := waitJob(t, h, queued.Id); job.Status != JobCancelled {
+		t.Errorf("queued job status = %s, want %s", job.Status, JobCancelled)
+	}
+
+	// 截止时间之前执行中的任务不会被取消
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := h.WaitJobs(ctx); err == nil {
+		t.Fatal("WaitJobs returned while a job was still running")
+	}
+	if job, _ := h.jobs.get(running.Id); job.Status != JobRunning {
+		t.Fatalf("running job status = %s, want %s", job.Status, JobRunning)
+	}
+
+	close(unblock)
+	if err := h.WaitJobs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	job, _ := h.jobs.get(running.Id)
+	if job.Status != JobFailed || job.Items[0].Status != JobFailed {
+		t.Errorf("running job finished as %s (item %s), want it to run to completion", job.Status, job.Items[0].Status)
+	}
+}
+
+func TestJobsScopedToRequester(t *testing.T) {
+	keys := []config.ApiKeyConfig{{Key: "alice"}, {Key: "bob"}, {Key: "root", Admin: true}}
+	h, auth := newTestHandler(t, keys, &fakeBilibili{handle: func(*http.Request) string { return "" }})
+	opts := JobOptions{Format: "mp4"}
+	alice := h.submitJob("test", keyRequester("alice"), opts, []*JobItem{{Bvid: "BV1alice"}}, nil)
+	bob := h.submitJob("test", keyRequester("bob"), opts, []*JobItem{{Bvid: "BV1bob"}}, nil)
+	waitJob(t, h, alice.Id)
+	waitJob(t, h, bob.Id)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/bilibili/jobs", auth.Authenticate(), h.Jobs)
+	router.GET("/bilibili/jobs/:id", auth.Authenticate(), h.Job)
+	router.DELETE("/bilibili/jobs/:id", auth.Authenticate(), h.CancelJob)
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		url    string
+		status int
+		jobs   []string // 列表请求返回的任务
+	}{
+		{"own jobs", "alice", http.MethodGet, "/bilibili/jobs", http.StatusOK, []string{alice.Id}},
+		{"admin jobs", "root", http.MethodGet, "/bilibili/jobs", http.StatusOK, []string{bob.Id, alice.Id}},
+		{"own job", "alice", http.MethodGet, "/bilibili/jobs/" + alice.Id, http.StatusOK, nil},
+		{"job of other requester", "alice", http.MethodGet, "/bilibili/jobs/" + bob.Id, http.StatusNotFound, nil},
+		{"admin job", "root", http.MethodGet, "/bilibili/jobs/" + bob.Id, http.StatusOK, nil},
+		{"cancel job of other requester", "bob", http.MethodDelete, "/bilibili/jobs/" + alice.Id, http.StatusNotFound, nil},
+		{"cancel own job", "bob", http.MethodDelete, "/bilibili/jobs/" + bob.Id, http.StatusAccepted, nil},
+		{"admin cancel", "root", http.MethodDelete, "/bilibili/jobs/" + alice.Id, http.StatusAccepted, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d, body %s", w.Code, tt.status, w.Body)
+			}
+			if tt.jobs == nil {
+				return
+			}
+			var resp struct {
+				Jobs []jobSummary `json:"jobs"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, j := range resp.Jobs {
+				ids = append(ids, j.Id)
+			}
+			if !slices.Equal(ids, tt.jobs) {
+				t.Errorf("jobs = %v, want %v", ids, tt.jobs)
+			}
+		})
+	}
+}
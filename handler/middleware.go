@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"bilibili-downloader-server/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader 请求 ID 的请求/响应头名称
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength 接受客户端传入请求 ID 的最大长度
+const maxRequestIDLength = 128
+
+// RequestID 返回请求 ID 中间件
+// 优先沿用客户端传入的 X-Request-ID，否则生成新的 ID，
+// 写入请求 context 供后续日志使用，并通过响应头返回
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = logging.NewRequestID()
+		}
+
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// AccessLog 返回结构化访问日志中间件，替代 gin 默认的文本日志
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"query", c.Request.URL.RawQuery,
+			"status", status,
+			"bytes", c.Writer.Size(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		logging.FromContext(c.Request.Context()).Log(c.Request.Context(), level, "HTTP request", attrs...)
+	}
+}
+
+// Recovery 返回 panic 恢复中间件，记录结构化日志并返回 JSON 错误
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		logging.FromContext(c.Request.Context()).Error("Panic recovered", "panic", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": "Server error: internal panic",
+		})
+	})
+}
+
+// validRequestID 检查客户端传入的请求 ID 是否可以直接使用
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
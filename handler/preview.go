@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bilibili-downloader-server/service"
+	"bilibili-downloader-server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// previewQuality 动图预览默认使用的清晰度（720P），宽度上限为 1280，更高的清晰度没有意义
+const previewQuality = 64
+
+// Preview 处理动图预览请求
+// GET /bilibili/preview/:id
+// 截取 start 到 end 的片段生成 GIF 或动态 WebP，只下载视频轨道中覆盖该范围的分段，
+// 和下载共用并发名额和配额
+func (h *Handler) Preview(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing video ID parameter",
+		})
+		return
+	}
+	if h.rejectDraining(c) {
+		return
+	}
+
+	// 获取 URL 参数 p（分 P 页码）、start / end（时间范围）、width（宽度）、fps（帧率）、format（动图格式）和 quality（清晰度）
+	page, ok := parsePage(c, c.DefaultQuery("p", "1"))
+	if !ok {
+		return
+	}
+	clip, err := parseClip(c.Query("start"), c.Query("end"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if clip.End == 0 {
+		clip.End = clip.Start + service.DefaultPreviewDuration
+	}
+	if clip.End-clip.Start > service.MaxPreviewDuration {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Preview is limited to %s", service.MaxPreviewDuration),
+		})
+		return
+	}
+
+	format, ok := service.LookupPreviewFormat(strings.ToLower(c.DefaultQuery("format", "gif")))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid format parameter, expected one of: " + strings.Join(service.PreviewFormatNames(), ", "),
+		})
+		return
+	}
+	opts := service.PreviewOptions{Format: format}
+	var qn int
+	for _, p := range []struct {
+		name     string
+		dst      *int
+		def, max int
+	}{
+		{"width", &opts.Width, service.DefaultPreviewWidth, service.MaxPreviewWidth},
+		{"fps", &opts.Fps, service.DefaultPreviewFps, service.MaxPreviewFps},
+		{"quality", &qn, previewQuality, 0},
+	} {
+		n, err := strconv.Atoi(c.DefaultQuery(p.name, strconv.Itoa(p.def)))
+		if err != nil || n < 1 || (p.max > 0 && n > p.max) {
+			msg := fmt.Sprintf("Invalid %s parameter", p.name)
+			if p.max > 0 {
+				msg += fmt.Sprintf(", expected 1-%d", p.max)
+			}
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": msg,
+			})
+			return
+		}
+		*p.dst = n
+	}
+
+	bvid, ok := h.resolveBvid(c, id, page)
+	if !ok {
+		return
+	}
+	release, ok := h.waitForSlots(c)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx := c.Request.Context()
+	cid, err := h.apiService.GetCid(ctx, bvid, page)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get CID: %w", err))
+		return
+	}
+
+	playUrlData, err := h.apiService.GetPlayUrl(ctx, bvid, cid, qn)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get play URL: %w", err))
+		return
+	}
+	if len(playUrlData.Dash.Video) == 0 {
+		if len(playUrlData.Durl) > 0 {
+			// durl 分段没有 sidx 索引，只能下载整段，不适合生成预览
+			h.handleError(c, fmt.Errorf("%w: preview requires a DASH stream", service.ErrStreamUnavailable))
+			return
+		}
+		h.handleError(c, fmt.Errorf("No video stream found"))
+		return
+	}
+	if duration := time.Duration(playUrlData.Dash.Duration) * time.Second; duration > 0 && clip.Start >= duration {
+		h.handleError(c, fmt.Errorf("%w: start %s, duration %s", service.ErrClipOutOfRange, clip.Start, duration))
+		return
+	}
+
+	// 和下载相同的轨道选择逻辑，优先 AVC 轨道，解码最快
+	track := service.SelectVideoTrack(playUrlData.Dash.Video, playUrlData.Quality, "avc")
+	clip.VideoIndex = track.SegmentBase
+
+	data, err := h.downloader.Preview(ctx, service.GetVideoUrl(track), bvid, *clip, opts)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to generate preview: %w", err))
+		return
+	}
+
+	name := fmt.Sprintf("%s_%s-%s", bvid, clipSeconds(clip.Start), clipSeconds(clip.End))
+	if page > 1 {
+		name = fmt.Sprintf("%s_p%d_%s-%s", bvid, page, clipSeconds(clip.Start), clipSeconds(clip.End))
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	filename := name + "." + format.Extension
+	c.Header("Content-Disposition", utils.InlineContentDisposition(filename, filename))
+	c.Data(http.StatusOK, format.ContentType, data)
+}
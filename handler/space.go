@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bilibili-downloader-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SpaceVideos 处理 UP 主投稿列表请求
+// GET /bilibili/space/:mid/videos
+// 按发布时间倒序分页返回投稿，pn 为页码，ps 为每页数量（1~50）
+func (h *Handler) SpaceVideos(c *gin.Context) {
+	mid, ok := parseMid(c)
+	if !ok {
+		return
+	}
+	page, ok := parsePage(c, c.DefaultQuery("pn", "1"))
+	if !ok {
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("ps", strconv.Itoa(service.DefaultSpacePageSize)))
+	if err != nil || size < 1 || size > service.MaxSpacePageSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invalid ps parameter, expected 1-%d", service.MaxSpacePageSize),
+		})
+		return
+	}
+
+	result, err := h.apiService.GetSpaceVideos(c.Request.Context(), mid, page, size)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get uploader videos: %w", err))
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// SpaceDownload 处理 UP 主投稿批量下载请求
+// POST /bilibili/space/:mid/download
+// 获取 UP 主的全部投稿（或 since 之后发布的投稿），创建批量任务下载到视频库目录
+func (h *Handler) SpaceDownload(c *gin.Context) {
+	mid, ok := parseMid(c)
+	if !ok {
+		return
+	}
+	if !h.requireLibrary(c) {
+		return
+	}
+
+	// 获取 URL 参数 since（发布日期下限，YYYY-MM-DD）和下载参数
+	var since time.Time
+	if v := c.Query("since"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, bilibiliLocation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid since parameter, expected YYYY-MM-DD",
+			})
+			return
+		}
+		since = t
+	}
+	opts, ok := parseJobOptions(c, h.config())
+	if !ok {
+		return
+	}
+
+	videos, err := h.apiService.ListSpaceVideos(c.Request.Context(), mid, since)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get uploader videos: %w", err))
+		return
+	}
+	items := make([]*JobItem, 0, len(videos))
+	for _, v := range videos {
+		items = append(items, &JobItem{Bvid: v.Bvid, Title: v.Title})
+	}
+
+	job := h.submitJob(fmt.Sprintf("space:%d", mid), requesterOf(c), opts, items, nil)
+	c.Header("Location", "/bilibili/jobs/"+job.Id)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// parseMid 解析路径参数中的 UP 主 ID，无效时返回 400
+func parseMid(c *gin.Context) (int64, bool) {
+	mid, err := strconv.ParseInt(c.Param("mid"), 10, 64)
+	if err != nil || mid < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid mid parameter",
+		})
+		return 0, false
+	}
+	return mid, true
+}
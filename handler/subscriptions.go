@@ -0,0 +1,627 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"bilibili-downloader-server/config"
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// MinSubscriptionInterval 订阅的最短检查间隔，避免频繁请求触发风控
+	MinSubscriptionInterval = 5 * time.Minute
+	// DefaultSubscriptionInterval 未指定 interval 时的检查间隔
+	DefaultSubscriptionInterval = time.Hour
+	// subscriptionTick 调度器检查是否有到期订阅的间隔
+	subscriptionTick = 30 * time.Second
+	// subscriptionsFile 视频库目录中保存订阅和检查进度的文件
+	subscriptionsFile = ".subscriptions.json"
+)
+
+// SubscriptionKind 订阅目标类型
+type SubscriptionKind string
+
+const (
+	SubscribeSpace     SubscriptionKind = "space"     // UP 主投稿，mid 为 UP 主 ID
+	SubscribeFavorites SubscriptionKind = "favorites" // 收藏夹，target_id 为收藏夹 ID
+	SubscribeSeason    SubscriptionKind = "season"    // 合集，mid 为 UP 主 ID，target_id 为合集 ID
+	SubscribeSeries    SubscriptionKind = "series"    // 系列，mid 为 UP 主 ID，target_id 为系列 ID
+)
+
+// Subscription 订阅：定期检查目标中的新视频并创建批量下载任务
+type Subscription struct {
+	Id          string           `json:"id"`
+	Kind        SubscriptionKind `json:"kind"`
+	Mid         int64            `json:"mid,omitempty"`
+	TargetId    int64            `json:"target_id,omitempty"`
+	Interval    config.Duration  `json:"interval"`
+	TitleRegex  string           `json:"title_regex,omitempty"`  // 只下载标题匹配的视频
+	MinDuration config.Duration  `json:"min_duration,omitempty"` // 只下载不短于该时长的视频
+	Options     JobOptions       `json:"options"`
+	Requester   string           `json:"requester,omitempty"` // 创建订阅的客户端，记录在订阅创建的任务中
+	// Cursor 已检查过的最新视频时间（Unix 秒）：投稿和合集为发布时间，收藏夹为收藏时间（合集和系列只在第一次检查时使用）
+	Cursor int64 `json:"cursor"`
+	// Seen 合集和系列中已经检查过的视频，按是否检查过而不是发布时间判断新视频，
+	// UP 主把旧视频加入合集后同样会下载；为空时（第一次检查）按 Cursor 判断
+	Seen []string `json:"seen,omitempty"`
+	// Pending 已经创建任务但还没有下载成功的视频，任务失败或被取消（包括服务关闭和重启）时下次检查重新下载
+	Pending   []subscriptionPending `json:"pending,omitempty"`
+	Created   time.Time             `json:"created"`
+	LastCheck *time.Time            `json:"last_check,omitempty"`
+	LastJob   string                `json:"last_job,omitempty"`   // 最近一次创建的任务 ID
+	LastError string                `json:"last_error,omitempty"` // 最近一次检查失败的原因，成功时清空
+}
+
+// subscriptionPending 订阅中已经创建任务、还没有下载成功的视频
+type subscriptionPending struct {
+	Bvid  string `json:"bvid"`
+	Title string `json:"title"`
+	Job   string `json:"job"` // 最近一次下载该视频的任务 ID
+}
+
+// due 判断订阅是否到了检查时间
+func (s *Subscription) due(now time.Time) bool {
+	return s.LastCheck == nil || now.Sub(*s.LastCheck) >= time.Duration(s.Interval)
+}
+
+// subscriptionStore 订阅列表，保存在视频库目录的 .subscriptions.json 中，重启后恢复
+type subscriptionStore struct {
+	mu   sync.Mutex
+	dir  string // 已加载的视频库目录，配置修改后重新加载
+	subs []*Subscription
+
+	// checkMu 保证同一时间只有一个检查在进行，避免定时检查和手动检查重复创建任务
+	checkMu sync.Mutex
+}
+
+// loadLocked 加载视频库目录中的订阅，目录和已加载的相同时不重复读取（调用方需持有锁）
+func (s *subscriptionStore) loadLocked(dir string) error {
+	if dir == s.dir {
+		return nil
+	}
+	var subs []*Subscription
+	data, err := os.ReadFile(filepath.Join(dir, subscriptionsFile))
+	if err == nil {
+		err = json.Unmarshal(data, &subs)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Failed to read subscriptions: %w", err)
+	}
+	s.dir, s.subs = dir, subs
+	return nil
+}
+
+// saveLocked 先写入临时文件再重命名，保存订阅列表（调用方需持有锁）
+func (s *subscriptionStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.subs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("Failed to create library directory: %w", err)
+	}
+	path := filepath.Join(s.dir, subscriptionsFile)
+	tmp := path + ".part"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("Failed to write subscriptions: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Failed to write subscriptions: %w", err)
+	}
+	return nil
+}
+
+// findLocked 查找订阅（调用方需持有锁）
+func (s *subscriptionStore) findLocked(id string) *Subscription {
+	for _, sub := range s.subs {
+		if sub.Id == id {
+			return sub
+		}
+	}
+	return nil
+}
+
+// view 加载订阅后在持有锁时调用 fn，fn 返回 true 时保存修改
+func (s *subscriptionStore) view(dir string, fn func() bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.loadLocked(dir); err != nil {
+		return err
+	}
+	if fn() {
+		return s.saveLocked()
+	}
+	return nil
+}
+
+// subscriptionCandidate 检查订阅时发现的视频
+type subscriptionCandidate struct {
+	bvid     string
+	title    string
+	time     int64 // 与 Subscription.Cursor 比较的时间
+	duration time.Duration
+}
+
+// RunSubscriptions 在后台定期检查到期的订阅，直到 ctx 取消
+// 未配置视频库目录或服务正在关闭时不检查
+func (h *Handler) RunSubscriptions(ctx context.Context) {
+	ticker := time.NewTicker(subscriptionTick)
+	defer ticker.Stop()
+	for {
+		if dir := h.config().Library.Dir; dir != "" && !h.draining.Load() {
+			var due []string
+			err := h.subs.view(dir, func() bool {
+				now := time.Now()
+				for _, sub := range h.subs.subs {
+					if sub.due(now) {
+						due = append(due, sub.Id)
+					}
+				}
+				return false
+			})
+			if err != nil {
+				logging.FromContext(ctx).Warn("Failed to load subscriptions", "error", err)
+			}
+			for _, id := range due {
+				if ctx.Err() != nil {
+					return
+				}
+				h.checkSubscription(ctx, dir, id)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkSubscription 检查订阅中的新视频，有新视频时创建批量任务，并保存检查进度
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 dir: 视频库目录
+// 参数 id: 订阅 ID
+// 返回：更新后的订阅副本、是否存在和检查错误
+func (h *Handler) checkSubscription(ctx context.Context, dir, id string) (Subscription, bool, error) {
+	h.subs.checkMu.Lock()
+	defer h.subs.checkMu.Unlock()
+
+	var sub Subscription
+	found := false
+	if err := h.subs.view(dir, func() bool {
+		if s := h.subs.findLocked(id); s != nil {
+			sub, found = *s, true
+		}
+		return false
+	}); err != nil || !found {
+		return sub, found, err
+	}
+
+	logger := logging.FromContext(ctx).With("subscription", sub.Id, "kind", sub.Kind)
+	candidates, seen, err := h.pollSubscription(ctx, sub)
+	if err != nil {
+		logger.Warn("Subscription check failed", "error", err)
+	}
+
+	// 之前的任务失败或被取消的视频重新下载，任务还在执行的视频保持不变
+	var items []*JobItem
+	var pending []subscriptionPending
+	queued := map[string]bool{}
+	for _, p := range sub.Pending {
+		switch h.pendingStatus(p) {
+		case JobDone:
+			continue
+		case JobRunning:
+			pending = append(pending, p)
+		default:
+			items = append(items, &JobItem{Bvid: p.Bvid, Title: p.Title})
+		}
+		queued[p.Bvid] = true
+	}
+	retries := len(items)
+
+	// 过滤条件不满足的视频同样推进检查进度，之后不会再次检查
+	cursor := sub.Cursor
+	var pattern *regexp.Regexp
+	if sub.TitleRegex != "" {
+		pattern, _ = regexp.Compile(sub.TitleRegex)
+	}
+	for _, v := range candidates {
+		cursor = max(cursor, v.time)
+		if queued[v.bvid] {
+			continue
+		}
+		if pattern != nil && !pattern.MatchString(v.title) {
+			continue
+		}
+		if sub.MinDuration > 0 && v.duration < time.Duration(sub.MinDuration) {
+			continue
+		}
+		// 启用数据库时跳过已经保存到视频库的视频（如同时出现在多个订阅中）
+		if h.store != nil {
+			if ok, err := h.store.InLibrary(ctx, v.bvid); err == nil && ok {
+				continue
+			}
+		}
+		items = append(items, &JobItem{Bvid: v.bvid, Title: v.title})
+		queued[v.bvid] = true
+	}
+
+	jobId := ""
+	if len(items) > 0 {
+		// 下载成功或文件已存在的视频从 Pending 中删除，其余的视频留在 Pending 中等待下次检查
+		job := h.submitJob("subscription:"+sub.Id, sub.Requester, sub.Options, items, func(ctx context.Context, item JobItem) {
+			h.resolvePending(ctx, dir, id, item.Bvid)
+		})
+		jobId = job.Id
+		for _, item := range items {
+			pending = append(pending, subscriptionPending{Bvid: item.Bvid, Title: item.Title, Job: jobId})
+		}
+		logger.Info("Subscription found new videos", "count", len(items)-retries, "retries", retries, "job", jobId)
+	}
+
+	saveErr := h.subs.view(dir, func() bool {
+		s := h.subs.findLocked(id)
+		if s == nil {
+			// 检查期间订阅被删除
+			return false
+		}
+		now := time.Now()
+		s.LastCheck, s.Cursor = &now, cursor
+		if seen != nil {
+			s.Seen = seen
+		}
+		// 任务可能在保存之前就已经下载完一部分视频（resolvePending 找不到对应的记录），这里按任务状态过滤
+		s.Pending = nil
+		for _, p := range pending {
+			if h.pendingStatus(p) != JobDone {
+				s.Pending = append(s.Pending, p)
+			}
+		}
+		s.LastError = ""
+		if err != nil {
+			s.LastError = err.Error()
+		}
+		if jobId != "" {
+			s.LastJob = jobId
+		}
+		sub = *s
+		return true
+	})
+	if saveErr != nil {
+		logger.Warn("Failed to save subscription progress", "error", saveErr)
+	}
+	if err == nil {
+		err = saveErr
+	}
+	return sub, true, err
+}
+
+// pollSubscription 获取订阅目标中比检查进度更新的视频，按时间从早到晚排列
+// 合集和系列按 Seen 判断，同时返回更新后的 Seen，其余类型返回 nil
+func (h *Handler) pollSubscription(ctx context.Context, sub Subscription) ([]subscriptionCandidate, []string, error) {
+	var candidates []subscriptionCandidate
+	var seen []string
+	switch sub.Kind {
+	case SubscribeSpace:
+		videos, err := h.apiService.ListSpaceVideos(ctx, sub.Mid, time.Unix(sub.Cursor+1, 0))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, v := range videos {
+			candidates = append(candidates, subscriptionCandidate{v.Bvid, v.Title, v.Created, v.Duration()})
+		}
+	case SubscribeFavorites:
+		_, medias, err := h.apiService.ListFavMedia(ctx, sub.TargetId)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, m := range medias {
+			if m.Available() && m.FavTime > sub.Cursor {
+				candidates = append(candidates, subscriptionCandidate{m.Bvid, m.Title, m.FavTime, time.Duration(m.Duration) * time.Second})
+			}
+		}
+	case SubscribeSeason, SubscribeSeries:
+		collection, err := h.apiService.GetCollection(ctx, service.CollectionKind(sub.Kind), sub.Mid, sub.TargetId)
+		if err != nil {
+			return nil, nil, err
+		}
+		known := make(map[string]bool, len(sub.Seen))
+		for _, bvid := range sub.Seen {
+			known[bvid] = true
+		}
+		// 从合集中移除的视频保留在 Seen 中，重新加入时不会再次下载
+		seen = append([]string{}, sub.Seen...)
+		for _, a := range collection.Archives {
+			if known[a.Bvid] {
+				continue
+			}
+			known[a.Bvid] = true
+			seen = append(seen, a.Bvid)
+			if sub.Seen == nil && a.Pubdate <= sub.Cursor {
+				continue
+			}
+			candidates = append(candidates, subscriptionCandidate{a.Bvid, a.Title, a.Pubdate, time.Duration(a.Duration) * time.Second})
+		}
+	default:
+		return nil, nil, fmt.Errorf("Unknown subscription kind: %s", sub.Kind)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].time < candidates[j].time })
+	return candidates, seen, nil
+}
+
+// pendingStatus 返回订阅中等待下载的视频所在任务的状态
+// 返回：JobDone 表示已经下载成功或文件已存在，JobRunning 表示任务还没有结束，
+// 其余（失败、取消、任务已经不在任务列表中）需要重新下载
+func (h *Handler) pendingStatus(p subscriptionPending) JobStatus {
+	job, ok := h.jobs.get(p.Job)
+	if !ok {
+		return JobFailed
+	}
+	for _, item := range job.Items {
+		if item.Bvid != p.Bvid {
+			continue
+		}
+		switch {
+		case item.Status == JobDone || item.Status == JobSkipped:
+			return JobDone
+		case !job.exited:
+			return JobRunning
+		}
+		return item.Status
+	}
+	return JobFailed
+}
+
+// resolvePending 视频下载成功或文件已存在时从订阅的 Pending 中删除，保存失败只记录日志
+func (h *Handler) resolvePending(ctx context.Context, dir, id, bvid string) {
+	err := h.subs.view(dir, func() bool {
+		s := h.subs.findLocked(id)
+		if s == nil {
+			return false
+		}
+		for i, p := range s.Pending {
+			if p.Bvid == bvid {
+				s.Pending = append(s.Pending[:i], s.Pending[i+1:]...)
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to save subscription progress", "subscription", id, "error", err)
+	}
+}
+
+// Subscriptions 处理订阅列表请求
+// GET /bilibili/subscriptions
+// 启用认证时非管理员 Key 只能看到自己创建的订阅
+func (h *Handler) Subscriptions(c *gin.Context) {
+	if !h.requireLibrary(c) {
+		return
+	}
+	subs := []Subscription{}
+	err := h.subs.view(h.config().Library.Dir, func() bool {
+		for _, sub := range h.subs.subs {
+			if h.owns(c, sub.Requester) {
+				subs = append(subs, *sub)
+			}
+		}
+		return false
+	})
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"subscriptions": subs,
+	})
+}
+
+// CreateSubscription 处理创建订阅请求
+// POST /bilibili/subscriptions
+// 参数 kind、mid、target_id 指定订阅目标，interval 为检查间隔，since（YYYY-MM-DD）为起始日期，
+// 未指定 since 时只下载创建订阅之后出现的视频；title_regex、min_duration 为过滤条件，
+// quality、codec、format、metadata 为下载参数
+func (h *Handler) CreateSubscription(c *gin.Context) {
+	if !h.requireLibrary(c) {
+		return
+	}
+
+	sub := &Subscription{
+		Id:         logging.NewRequestID(),
+		Kind:       SubscriptionKind(c.Query("kind")),
+		Interval:   config.Duration(DefaultSubscriptionInterval),
+		TitleRegex: c.Query("title_regex"),
+		Requester:  requesterOf(c),
+		Created:    time.Now(),
+		Cursor:     time.Now().Unix(),
+	}
+
+	// 校验订阅目标
+	parseId := func(name string) (int64, bool) {
+		v, err := strconv.ParseInt(c.Query(name), 10, 64)
+		if err != nil || v < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid %s parameter", name),
+			})
+			return 0, false
+		}
+		return v, true
+	}
+	var ok bool
+	switch sub.Kind {
+	case SubscribeSpace:
+		sub.Mid, ok = parseId("mid")
+	case SubscribeFavorites:
+		sub.TargetId, ok = parseId("target_id")
+	case SubscribeSeason, SubscribeSeries:
+		if sub.Mid, ok = parseId("mid"); ok {
+			sub.TargetId, ok = parseId("target_id")
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid kind parameter, expected one of: space, favorites, season, series",
+		})
+		return
+	}
+	if !ok {
+		return
+	}
+
+	// 校验检查间隔和过滤条件
+	if v := c.Query("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < MinSubscriptionInterval {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid interval parameter, expected a duration of at least %s", MinSubscriptionInterval),
+			})
+			return
+		}
+		sub.Interval = config.Duration(d)
+	}
+	if v := c.Query("min_duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid min_duration parameter",
+			})
+			return
+		}
+		sub.MinDuration = config.Duration(d)
+	}
+	if _, err := regexp.Compile(sub.TitleRegex); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid title_regex parameter: " + err.Error(),
+		})
+		return
+	}
+	if v := c.Query("since"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, bilibiliLocation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid since parameter, expected YYYY-MM-DD",
+			})
+			return
+		}
+		// 检查进度记录的是已经检查过的时间，since 当天发布的视频需要下载
+		sub.Cursor = t.Unix() - 1
+	}
+	if sub.Options, ok = parseJobOptions(c, h.config()); !ok {
+		return
+	}
+
+	if err := h.subs.view(h.config().Library.Dir, func() bool {
+		h.subs.subs = append(h.subs.subs, sub)
+		return true
+	}); err != nil {
+		h.handleError(c, err)
+		return
+	}
+	logging.FromContext(c.Request.Context()).Info("Subscription created", "subscription", sub.Id, "kind", sub.Kind)
+	c.Header("Location", "/bilibili/subscriptions/"+sub.Id)
+	c.JSON(http.StatusCreated, sub)
+}
+
+// GetSubscription 处理订阅详情请求
+// GET /bilibili/subscriptions/:id
+func (h *Handler) GetSubscription(c *gin.Context) {
+	if !h.requireLibrary(c) {
+		return
+	}
+	if sub, ok := h.ownSubscription(c); ok {
+		c.JSON(http.StatusOK, sub)
+	}
+}
+
+// ownSubscription 查找当前请求可以访问的订阅，其他请求方创建的订阅按不存在处理（管理员 Key 除外）
+// 返回：订阅副本和是否找到，未找到或加载失败时已经写入响应
+func (h *Handler) ownSubscription(c *gin.Context) (Subscription, bool) {
+	var sub Subscription
+	found := false
+	if err := h.subs.view(h.config().Library.Dir, func() bool {
+		if s := h.subs.findLocked(c.Param("id")); s != nil && h.owns(c, s.Requester) {
+			sub, found = *s, true
+		}
+		return false
+	}); err != nil {
+		h.handleError(c, err)
+		return sub, false
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Subscription not found",
+		})
+	}
+	return sub, found
+}
+
+// DeleteSubscription 处理删除订阅请求，已经创建的任务不受影响
+// DELETE /bilibili/subscriptions/:id
+func (h *Handler) DeleteSubscription(c *gin.Context) {
+	if !h.requireLibrary(c) {
+		return
+	}
+	found := false
+	if err := h.subs.view(h.config().Library.Dir, func() bool {
+		for i, sub := range h.subs.subs {
+			if sub.Id == c.Param("id") && h.owns(c, sub.Requester) {
+				h.subs.subs = append(h.subs.subs[:i], h.subs.subs[i+1:]...)
+				found = true
+				break
+			}
+		}
+		return found
+	}); err != nil {
+		h.handleError(c, err)
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Subscription not found",
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CheckSubscription 处理立即检查订阅请求，不等待检查间隔
+// POST /bilibili/subscriptions/:id/check
+// 返回更新后的订阅，有新视频时 last_job 为新创建的任务；
+// 新任务计入创建订阅的 Key 的配额，因此其他请求方的订阅按不存在处理（管理员 Key 除外）
+func (h *Handler) CheckSubscription(c *gin.Context) {
+	if !h.requireLibrary(c) {
+		return
+	}
+	if _, ok := h.ownSubscription(c); !ok {
+		return
+	}
+	sub, found, err := h.checkSubscription(c.Request.Context(), h.config().Library.Dir, c.Param("id"))
+	if !found && err == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Subscription not found",
+		})
+		return
+	}
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to check subscription: %w", err))
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"bilibili-downloader-server/config"
+	"bilibili-downloader-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSubscriptionRetriesAndSeen(t *testing.T) {
+	var mu sync.Mutex
+	archives := []service.CollectionArchive{
+		{Bvid: "BV1old", Title: "Old", Pubdate: 900},
+		{Bvid: "BV1new", Title: "New", Pubdate: 1100},
+	}
+	failing := map[string]bool{"BV1new": true}
+	api := &fakeBilibili{handle: func(r *http.Request) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case service.SeasonsArchivesEndpoint:
+			data, _ := json.Marshal(gin.H{
+				"code": 0,
+				"data": gin.H{"archives": archives, "page": gin.H{"page_num": 1, "page_size": 30, "total": len(archives)}},
+			})
+			return string(data)
+		case service.ViewEndpoint:
+			bvid := r.URL.Query().Get("bvid")
+			if failing[bvid] {
+				return ""
+			}
+			return fmt.Sprintf(`{"code":0,"data":{"bvid":%q,"title":"Video","pages":[{"cid":1,"page":1,"part":"P1"}]}}`, bvid)
+		}
+		return ""
+	}}
+	h, _ := newTestHandler(t, nil, api)
+	dir := h.config().Library.Dir
+	ctx := context.Background()
+
+	if err := h.subs.view(dir, func() bool {
+		h.subs.subs = append(h.subs.subs, &Subscription{Id: "s1", Kind: SubscribeSeason, Mid: 1, TargetId: 2, Cursor: 1000, Options: JobOptions{Format: "mp4"}})
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// 视频库中已经存在的视频直接跳过，相当于下载成功
+	exists := func(bvid string) {
+		mu.Lock()
+		delete(failing, bvid)
+		mu.Unlock()
+		if err := os.WriteFile(filepath.Join(dir, bvid+".mp4"), []byte("video"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		h.indexLibraryFile(ctx, dir, bvid, 1, bvid+".mp4")
+	}
+	check := func() Subscription {
+		t.Helper()
+		sub, found, err := h.checkSubscription(ctx, dir, "s1")
+		if err != nil || !found {
+			t.Fatalf("check: found %v, error %v", found, err)
+		}
+		return sub
+	}
+	bvids := func(job Job) []string {
+		var out []string
+		for _, item := range job.Items {
+			out = append(out, item.Bvid)
+		}
+		return out
+	}
+
+	// 第一次检查按 Cursor 建立初始列表，只下载新发布的视频
+	sub := check()
+	if !slices.Equal(sub.Seen, []string{"BV1old", "BV1new"}) {
+		t.Errorf("seen = %v", sub.Seen)
+	}
+	job1 := waitJob(t, h, sub.LastJob)
+	if got := bvids(job1); !slices.Equal(got, []string{"BV1new"}) {
+		t.Fatalf("first job items = %v", got)
+	}
+	if job1.Items[0].Status != JobFailed {
+		t.Fatalf("first job item status = %s, want %s", job1.Items[0].Status, JobFailed)
+	}
+
+	// UP 主把旧视频加入合集；下载失败的视频和新加入的视频一起下载
+	mu.Lock()
+	archives = append(archives, service.CollectionArchive{Bvid: "BV1added", Title: "Added", Pubdate: 500})
+	mu.Unlock()
+	exists("BV1new")
+	exists("BV1added")
+	sub = check()
+	if sub.LastJob == job1.Id {
+		t.Fatal("second check did not create a job")
+	}
+	job2 := waitJob(t, h, sub.LastJob)
+	if got := bvids(job2); !slices.Equal(got, []string{"BV1new", "BV1added"}) {
+		t.Fatalf("second job items = %v", got)
+	}
+
+	// 全部下载成功后不再重试
+	sub = check()
+	if sub.LastJob != job2.Id {
+		t.Errorf("third check created job %s", sub.LastJob)
+	}
+	if len(sub.Pending) != 0 {
+		t.Errorf("pending = %v", sub.Pending)
+	}
+	if !slices.Equal(sub.Seen, []string{"BV1old", "BV1new", "BV1added"}) {
+		t.Errorf("seen = %v", sub.Seen)
+	}
+}
+
+func TestSubscriptionRetriesInterruptedJob(t *testing.T) {
+	h, _ := newTestHandler(t, nil, &fakeBilibili{handle: func(r *http.Request) string {
+		if r.URL.Path == service.SeasonsArchivesEndpoint {
+			return `{"code":0,"data":{"archives":[],"page":{"total":0}}}`
+		}
+		return ""
+	}})
+	dir := h.config().Library.Dir
+
+	// 服务重启前创建的任务已经不在任务列表中
+	if err := h.subs.view(dir, func() bool {
+		h.subs.subs = append(h.subs.subs, &Subscription{
+			Id: "s1", Kind: SubscribeSeason, Mid: 1, TargetId: 2, Seen: []string{"BV1lost"},
+			Pending: []subscriptionPending{{Bvid: "BV1lost", Title: "Lost", Job: "gone"}},
+		})
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sub, _, err := h.checkSubscription(context.Background(), dir, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.LastJob == "" {
+		t.Fatal("interrupted video was not retried")
+	}
+	if len(sub.Pending) != 1 || sub.Pending[0].Job != sub.LastJob {
+		t.Errorf("pending = %v, want BV1lost in job %s", sub.Pending, sub.LastJob)
+	}
+}
+
+func TestSubscriptionsScopedToRequester(t *testing.T) {
+	keys := []config.ApiKeyConfig{{Key: "alice"}, {Key: "bob"}, {Key: "root", Admin: true}}
+	api := &fakeBilibili{handle: func(r *http.Request) string {
+		if r.URL.Path == service.SeasonsArchivesEndpoint {
+			return `{"code":0,"data":{"archives":[],"page":{"total":0}}}`
+		}
+		return ""
+	}}
+	h, auth := newTestHandler(t, keys, api)
+	dir := h.config().Library.Dir
+	if err := h.subs.view(dir, func() bool {
+		for _, name := range []string{"alice", "bob"} {
+			h.subs.subs = append(h.subs.subs, &Subscription{Id: name, Kind: SubscribeSeason, Mid: 1, TargetId: 2, Requester: keyRequester(name)})
+		}
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/bilibili/subscriptions", auth.Authenticate(), h.Subscriptions)
+	router.GET("/bilibili/subscriptions/:id", auth.Authenticate(), h.GetSubscription)
+	router.DELETE("/bilibili/subscriptions/:id", auth.Authenticate(), h.DeleteSubscription)
+	router.POST("/bilibili/subscriptions/:id/check", auth.Quota(), h.CheckSubscription)
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		url    string
+		status int
+		subs   []string // 列表请求返回的订阅
+	}{
+		{"own list", "alice", http.MethodGet, "/bilibili/subscriptions", http.StatusOK, []string{"alice"}},
+		{"admin list", "root", http.MethodGet, "/bilibili/subscriptions", http.StatusOK, []string{"alice", "bob"}},
+		{"own detail", "bob", http.MethodGet, "/bilibili/subscriptions/bob", http.StatusOK, nil},
+		{"detail of other requester", "bob", http.MethodGet, "/bilibili/subscriptions/alice", http.StatusNotFound, nil},
+		{"check of other requester", "bob", http.MethodPost, "/bilibili/subscriptions/alice/check", http.StatusNotFound, nil},
+		{"delete of other requester", "bob", http.MethodDelete, "/bilibili/subscriptions/alice", http.StatusNotFound, nil},
+		{"own check", "alice", http.MethodPost, "/bilibili/subscriptions/alice/check", http.StatusOK, nil},
+		{"admin detail", "root", http.MethodGet, "/bilibili/subscriptions/alice", http.StatusOK, nil},
+		{"own delete", "bob", http.MethodDelete, "/bilibili/subscriptions/bob", http.StatusNoContent, nil},
+		{"admin delete", "root", http.MethodDelete, "/bilibili/subscriptions/alice", http.StatusNoContent, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d, body %s", w.Code, tt.status, w.Body)
+			}
+			if tt.subs == nil {
+				return
+			}
+			var resp struct {
+				Subscriptions []Subscription `json:"subscriptions"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, s := range resp.Subscriptions {
+				ids = append(ids, s.Id)
+			}
+			if !slices.Equal(ids, tt.subs) {
+				t.Errorf("subscriptions = %v, want %v", ids, tt.subs)
+			}
+		})
+	}
+	// 只有 alice 自己的检查请求了 Bilibili
+	if n := api.count(service.SeasonsArchivesEndpoint, ""); n != 1 {
+		t.Errorf("collection requested %d times, want 1", n)
+	}
+}
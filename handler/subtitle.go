@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/service"
+	"bilibili-downloader-server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// subtitleInfo 字幕列表中的一项
+type subtitleInfo struct {
+	Lan    string `json:"lan"`
+	LanDoc string `json:"lan_doc"`
+	AI     bool   `json:"ai"`
+}
+
+// Subtitle 处理字幕请求
+// GET /bilibili/subtitle/:id
+// 不带 lang 参数时返回可用字幕列表；带 lang 参数时返回转换为 format（srt/vtt/ass）格式的字幕文件
+func (h *Handler) Subtitle(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing video ID parameter",
+		})
+		return
+	}
+
+	// 获取 URL 参数 p（分 P 页码）、lang（语言代码）和 format（字幕格式）
+	page, ok := parsePage(c, c.DefaultQuery("p", "1"))
+	if !ok {
+		return
+	}
+	lang := c.Query("lang")
+	format, ok := service.LookupSubtitleFormat(strings.ToLower(c.DefaultQuery("format", service.DefaultSubtitleFormat)))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid format parameter, expected one of: " + strings.Join(service.SubtitleFormatNames(), ", "),
+		})
+		return
+	}
+
+	bvid, ok := h.resolveBvid(c, id, page)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	cid, err := h.apiService.GetCid(ctx, bvid, page)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get CID: %w", err))
+		return
+	}
+
+	tracks, err := h.apiService.GetSubtitles(ctx, bvid, cid)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to get subtitle list: %w", err))
+		return
+	}
+
+	// 未指定语言时返回字幕列表
+	if lang == "" {
+		list := make([]subtitleInfo, 0, len(tracks))
+		for _, t := range tracks {
+			list = append(list, subtitleInfo{Lan: t.Lan, LanDoc: t.LanDoc, AI: t.Type == 1})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"bvid":      bvid,
+			"page":      page,
+			"cid":       cid,
+			"subtitles": list,
+		})
+		return
+	}
+
+	track, ok := service.FindSubtitle(tracks, lang)
+	if !ok {
+		h.handleError(c, fmt.Errorf("%w: %s has no %s subtitle", service.ErrSubtitleNotFound, bvid, lang))
+		return
+	}
+	bcc, err := h.apiService.GetSubtitleBody(ctx, track)
+	if err != nil {
+		h.handleError(c, fmt.Errorf("Failed to download subtitle: %w", err))
+		return
+	}
+
+	name := fmt.Sprintf("%s.%s.%s", bvid, track.Lan, format.Extension)
+	if page > 1 {
+		name = fmt.Sprintf("%s_p%d.%s.%s", bvid, page, track.Lan, format.Extension)
+	}
+	c.Header("Content-Disposition", utils.ContentDisposition(utils.SanitizeFilename(name), bvid+"."+format.Extension))
+	c.Data(http.StatusOK, format.ContentType, format.Convert(bcc))
+}
+
+// subtitleFiles 获取要内嵌到输出文件的字幕，统一转换为 SRT
+// 字幕只是附加信息，获取失败或语言不存在时记录日志并跳过
+// 参数 ctx: 上下文
+// 参数 bvid: 视频 BV 号
+// 参数 cid: 分 P 的 CID
+// 参数 langs: 语言代码列表，包含 "all" 时选择全部字幕
+// 返回：字幕文件列表
+func (h *Handler) subtitleFiles(ctx context.Context, bvid string, cid int64, langs []string) []service.SubtitleFile {
+	logger := logging.FromContext(ctx).With("bvid", bvid)
+
+	tracks, err := h.apiService.GetSubtitles(ctx, bvid, cid)
+	if err != nil {
+		logger.Warn("Failed to get subtitle list, skipping subtitles", "error", err)
+		return nil
+	}
+
+	var selected []service.SubtitleTrack
+	for _, lang := range langs {
+		if strings.EqualFold(lang, "all") {
+			selected = tracks
+			break
+		}
+		track, ok := service.FindSubtitle(tracks, lang)
+		if !ok {
+			logger.Warn("Subtitle language not available, skipping", "lang", lang)
+			continue
+		}
+		selected = append(selected, track)
+	}
+
+	srt, _ := service.LookupSubtitleFormat("srt")
+	files := make([]service.SubtitleFile, 0, len(selected))
+	for _, track := range selected {
+		bcc, err := h.apiService.GetSubtitleBody(ctx, track)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			logger.Warn("Failed to download subtitle, skipping", "lang", track.Lan, "error", err)
+			continue
+		}
+		files = append(files, service.SubtitleFile{
+			Language: service.SubtitleLanguage(track.Lan),
+			Title:    track.LanDoc,
+			Data:     srt.Convert(bcc),
+		})
+	}
+	return files
+}
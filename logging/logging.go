@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// requestIDKey context 中保存请求 ID 的键
+type requestIDKey struct{}
+
+// level 全局日志级别，支持运行时调整
+var level slog.LevelVar
+
+// Setup 创建 JSON 格式的结构化日志记录器并设置为全局默认
+// 参数 w: 日志输出目标
+// 参数 lvl: 日志级别（debug/info/warn/error），无法识别时使用 info
+// 返回：配置好的 slog.Logger
+func Setup(w io.Writer, lvl string) *slog.Logger {
+	SetLevel(lvl)
+	logger := slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: &level,
+	}))
+	slog.SetDefault(logger)
+	return logger
+}
+
+// SetLevel 调整全局日志级别
+// 参数 lvl: 日志级别（debug/info/warn/error），无法识别时使用 info
+func SetLevel(lvl string) {
+	level.Set(ParseLevel(lvl))
+}
+
+// ParseLevel 解析日志级别字符串
+func ParseLevel(lvl string) slog.Level {
+	switch strings.ToLower(lvl) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// NewRequestID 生成随机请求 ID（32 位十六进制字符串）
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID 将请求 ID 写入 context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID 从 context 中读取请求 ID，不存在时返回空字符串
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext 返回带有请求 ID 字段的日志记录器
+// 参数 ctx: 上下文，包含请求 ID 时自动附加 request_id 字段
+// 返回：slog.Logger
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := RequestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	return logger
+}
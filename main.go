@@ -1,68 +1,218 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"bilibili-downloader-server/config"
 	"bilibili-downloader-server/handler"
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/store"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-const (
-	// 默认端口
-	defaultPort = "8080"
-	// 环境变量名
-	envCookie = "BILIBILI_COOKIE"
-	envPort   = "PORT"
-)
+// configPollInterval 检查配置文件是否变化的间隔
+const configPollInterval = 5 * time.Second
 
 func main() {
-	// 1. 读取环境变量
-	cookie := os.Getenv(envCookie)
-	if cookie == "" {
-		log.Fatalf("Error: Environment variable %s must be set\n", envCookie)
-	}
+	// 0. 初始化结构化日志
+	logging.Setup(os.Stdout, os.Getenv(config.EnvLogLevel))
+
+	// 1. 加载配置：默认值 -> 配置文件 -> 环境变量
+	configPath := flag.String("config", os.Getenv(config.EnvConfigFile), "path to YAML or TOML config file")
+	flag.Parse()
 
-	port := os.Getenv(envPort)
-	if port == "" {
-		port = defaultPort
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Failed to load config", "error", err)
 	}
+	logging.SetLevel(cfg.Log.Level)
 
 	// 2. 启动检查
 	// 检查 FFmpeg 是否已安装
 	if err := checkFFmpeg(); err != nil {
-		log.Fatalf("Error: %v\nPlease ensure FFmpeg is installed\n", err)
+		fatal("Please ensure FFmpeg is installed", "error", err)
 	}
 
-	log.Println("✓ FFmpeg installed")
-	log.Println("✓ Cookie configured")
+	slog.Info("FFmpeg installed")
+	slog.Info("Cookie configured")
+
+	// 3. 创建 Handler 和认证中间件
+	h := handler.NewHandler(cfg)
+	if cfg.Library.Database != "" {
+		db, err := store.Open(cfg.Library.Database)
+		if err != nil {
+			fatal("Failed to open database", "path", cfg.Library.Database, "error", err)
+		}
+		defer db.Close()
+		if err := h.SetStore(db); err != nil {
+			fatal("Failed to restore jobs from database", "error", err)
+		}
+		slog.Info("Database opened", "path", cfg.Library.Database)
+	}
+	auth := handler.NewAuth(cfg.Auth.ApiKeys)
+	h.SetAuth(auth)
+	if auth.Enabled() {
+		slog.Info("API key authentication enabled", "keys", len(cfg.Auth.ApiKeys))
+	} else {
+		slog.Warn("API keys not set, download endpoints are unauthenticated")
+	}
 
-	// 3. 创建 Handler
-	h := handler.NewHandler(cookie)
+	// 收到 SIGINT/SIGTERM 时进入关闭流程
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 监听 SIGHUP 和配置文件变化，热更新配置
+	var shutdownTimeout atomic.Int64
+	shutdownTimeout.Store(int64(cfg.Server.ShutdownTimeout))
+	go config.Watch(ctx, *configPath, configPollInterval, func(newCfg *config.Config) {
+		if newCfg.Server.Port != cfg.Server.Port {
+			slog.Warn("Port change requires a restart, ignoring", "port", newCfg.Server.Port)
+		}
+		if newCfg.Library.Database != cfg.Library.Database {
+			slog.Warn("Database path change requires a restart, ignoring", "path", newCfg.Library.Database)
+		}
+		if !slices.Equal(newCfg.Server.TrustedProxies, cfg.Server.TrustedProxies) {
+			slog.Warn("Trusted proxies change requires a restart, ignoring", "trusted_proxies", newCfg.Server.TrustedProxies)
+		}
+		logging.SetLevel(newCfg.Log.Level)
+		shutdownTimeout.Store(int64(newCfg.Server.ShutdownTimeout))
+		auth.SetKeys(newCfg.Auth.ApiKeys)
+		h.ApplyConfig(newCfg)
+	})
+
+	// 定期清理崩溃等原因残留的工作目录
+	go h.RunJanitor(ctx)
+
+	// 定期检查订阅中的新视频
+	go h.RunSubscriptions(ctx)
 
 	// 4. 设置 Gin 模式
 	gin.SetMode(gin.ReleaseMode)
-	router := gin.Default()
+	router := gin.New()
+	// 默认信任所有代理，客户端可以通过伪造 X-Forwarded-For 绕过按 IP 的并发限制
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		fatal("Invalid trusted proxies", "error", err)
+	}
+	router.Use(handler.RequestID(), handler.AccessLog(), handler.Recovery())
 
 	// 5. 定义路由
 	// 健康检查路由
+	router.GET("/health/live", h.Live)
+	router.GET("/health/ready", h.Ready)
 	router.GET("/bilibili/download/health", h.Health)
+	// 下载队列状态
+	router.GET("/bilibili/download/queue", h.Queue)
+	// Prometheus 指标
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	// 通用下载路由，支持 AV 号和 BV 号
-	router.GET("/bilibili/download/:id", h.Download)
+	router.GET("/bilibili/download/:id", auth.Middleware(), h.Download)
+	// 字幕列表和字幕文件（SRT/WebVTT/ASS）
+	router.GET("/bilibili/subtitle/:id", auth.Authenticate(), h.Subtitle)
+	// 弹幕文件（Bilibili XML/ASS）
+	router.GET("/bilibili/danmaku/:id", auth.Authenticate(), h.Danmaku)
+	// 封面、分 P 第一帧和指定时间的截图
+	router.GET("/bilibili/cover/:id", auth.Authenticate(), h.Cover)
+	router.GET("/bilibili/thumbnail/:id", auth.Middleware(), h.Thumbnail)
+	// GIF / 动态 WebP 预览
+	router.GET("/bilibili/preview/:id", auth.Middleware(), h.Preview)
+	// 互动视频剧情图和全部节点的 ZIP 下载
+	router.GET("/bilibili/interactive/:id", auth.Authenticate(), h.Interactive)
+	router.GET("/bilibili/interactive/:id/download", auth.Quota(), h.InteractiveDownload)
+	// UP 主投稿列表和批量下载
+	router.GET("/bilibili/space/:mid/videos", auth.Authenticate(), h.SpaceVideos)
+	router.POST("/bilibili/space/:mid/download", auth.Quota(), h.SpaceDownload)
+	// 收藏夹列表、内容和同步
+	router.GET("/bilibili/favorites", auth.Authenticate(), h.Favorites)
+	router.GET("/bilibili/space/:mid/favorites", auth.Authenticate(), h.SpaceFavorites)
+	router.GET("/bilibili/favorites/:media_id", auth.Authenticate(), h.FavoriteMedia)
+	router.POST("/bilibili/favorites/:media_id/sync", auth.Quota(), h.FavoriteSync)
+	// 合集和系列
+	router.GET("/bilibili/collection/:id", auth.Authenticate(), h.VideoCollection)
+	router.POST("/bilibili/collection/:id/download", auth.Quota(), h.VideoCollectionDownload)
+	router.GET("/bilibili/space/:mid/collections", auth.Authenticate(), h.SpaceCollections)
+	router.GET("/bilibili/space/:mid/collections/:kind/:collection_id", auth.Authenticate(), h.SpaceCollection)
+	router.POST("/bilibili/space/:mid/collections/:kind/:collection_id/download", auth.Quota(), h.SpaceCollectionDownload)
+	// 订阅
+	router.GET("/bilibili/subscriptions", auth.Authenticate(), h.Subscriptions)
+	router.POST("/bilibili/subscriptions", auth.Quota(), h.CreateSubscription)
+	router.GET("/bilibili/subscriptions/:id", auth.Authenticate(), h.GetSubscription)
+	router.DELETE("/bilibili/subscriptions/:id", auth.Authenticate(), h.DeleteSubscription)
+	router.POST("/bilibili/subscriptions/:id/check", auth.Quota(), h.CheckSubscription)
+	// 下载历史和视频库
+	router.GET("/bilibili/history", auth.Authenticate(), h.History)
+	router.GET("/bilibili/library", auth.Authenticate(), h.Library)
+	// 批量下载任务
+	router.GET("/bilibili/jobs", auth.Authenticate(), h.Jobs)
+	router.GET("/bilibili/jobs/:id", auth.Authenticate(), h.Job)
+	router.DELETE("/bilibili/jobs/:id", auth.Authenticate(), h.CancelJob)
 
 	// 6. 启动服务器
-	addr := ":" + port
-	log.Printf("🚀 Server starting, listening on: %s\n", addr)
-	log.Printf("📥 Download endpoints:\n")
-	log.Printf("   - GET http://localhost%s/bilibili/download/:bvid\n", addr)
-	log.Printf("   - GET http://localhost%s/bilibili/download/:avid\n", addr)
-
-	if err := router.Run(addr); err != nil {
-		log.Fatalf("Failed to start server: %v\n", err)
+	addr := ":" + cfg.Server.Port
+	slog.Info("Server starting",
+		"addr", addr,
+		"config", *configPath,
+		"max_downloads", cfg.Limits.Downloads,
+		"max_merges", cfg.Limits.Merges,
+		"max_per_ip", cfg.Limits.PerIP,
+	)
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: router,
 	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Failed to start server", "error", err)
+		}
+	}()
+
+	// 7. 优雅关闭
+	<-ctx.Done()
+	stop()
+	shutdown(srv, h, time.Duration(shutdownTimeout.Load()))
+}
+
+// shutdown 优雅关闭服务器
+// 停止接受新的下载和批量任务，在 timeout 内等待进行中的下载和批量任务完成，
+// 超时后终止剩余的下载、批量任务和 FFmpeg 进程，最后清理临时目录
+func shutdown(srv *http.Server, h *handler.Handler, timeout time.Duration) {
+	slog.Info("Shutting down, waiting for in-flight downloads and jobs", "timeout", timeout.String())
+	h.Drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("Shutdown deadline exceeded, aborting in-flight downloads", "error", err)
+	}
+	if err := h.WaitJobs(ctx); err != nil {
+		slog.Warn("Shutdown deadline exceeded, cancelling running jobs", "error", err)
+	}
+
+	// 终止残留的 FFmpeg 进程并删除临时目录
+	h.Abort()
+	srv.Close()
+	slog.Info("Server stopped")
+}
+
+// fatal 记录错误日志并退出进程
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 // checkFFmpeg 检查 FFmpeg 是否已安装
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace 所有指标的统一前缀
+const namespace = "bilibili_downloader"
+
+var (
+	// DownloadsTotal 下载请求数，按结果和清晰度区分
+	DownloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_total",
+		Help:      "Total number of download requests by outcome and quality.",
+	}, []string{"outcome", "quality"})
+
+	// DownloadDuration 下载请求耗时（从开始处理到响应写完）
+	DownloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Duration of download requests by outcome.",
+		Buckets:   []float64{1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200},
+	}, []string{"outcome"})
+
+	// DownloadsInFlight 正在执行的下载数
+	DownloadsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "downloads_in_flight",
+		Help:      "Number of downloads currently being processed.",
+	})
+
+	// DownloadsQueued 正在排队等待的下载数
+	DownloadsQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "downloads_queued",
+		Help:      "Number of downloads waiting for a free slot.",
+	})
+
+	// ApiRequestsTotal Bilibili API 调用次数，按端点和响应 code 区分
+	ApiRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Total number of Bilibili API calls by endpoint and response code.",
+	}, []string{"endpoint", "code"})
+
+	// ApiRequestDuration Bilibili API 调用耗时
+	ApiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Duration of Bilibili API calls by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	// CdnBytesTotal 从 CDN 下载的字节数
+	CdnBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cdn_bytes_total",
+		Help:      "Total number of bytes fetched from the Bilibili CDN.",
+	})
+
+	// FfmpegDuration FFmpeg 合并耗时
+	FfmpegDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ffmpeg_merge_duration_seconds",
+		Help:      "Duration of FFmpeg merge invocations by result.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"result"})
+
+	// TranscodeDuration FFmpeg 转码耗时，按配置档和结果区分
+	TranscodeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ffmpeg_transcode_duration_seconds",
+		Help:      "Duration of FFmpeg transcode invocations by profile and result.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 2400},
+	}, []string{"profile", "result"})
+
+	// CacheRequestsTotal 缓存查询次数，按结果（hit/miss）区分
+	CacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Total number of cache lookups by result.",
+	}, []string{"result"})
+
+	// WbiRefreshesTotal WBI 密钥获取次数
+	WbiRefreshesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wbi_refreshes_total",
+		Help:      "Total number of WBI key fetches by result.",
+	}, []string{"result"})
+)
+
+// ObserveApi 记录一次 Bilibili API 调用
+// 参数 endpoint: API 端点路径
+// 参数 code: 响应中的 code 字段
+// 参数 err: 请求或解析错误，不为 nil 时 code 记为 "error"
+// 参数 start: 调用开始时间
+func ObserveApi(endpoint string, code int, err error, start time.Time) {
+	label := "error"
+	if err == nil {
+		label = strconv.Itoa(code)
+	}
+	ApiRequestsTotal.WithLabelValues(endpoint, label).Inc()
+	ApiRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+}
+
+// knownQualities Bilibili 的清晰度代码（qn），其余值记为 other，避免客户端传入任意值产生无限多的标签
+var knownQualities = map[int]bool{
+	6: true, 16: true, 32: true, 64: true, 74: true, 80: true, 100: true,
+	112: true, 116: true, 120: true, 125: true, 126: true, 127: true,
+}
+
+// QualityLabel 将清晰度代码转换为标签值，未知的清晰度记为 other
+func QualityLabel(qn int) string {
+	if !knownQualities[qn] {
+		return "other"
+	}
+	return strconv.Itoa(qn)
+}
+
+// Result 将错误转换为 success/error 标签值
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,8 @@ import (
 	"sync"
 	"time"
 
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/metrics"
 	"bilibili-downloader-server/utils"
 )
 
@@ -21,6 +24,8 @@ const (
 	BaseURL = "https://api.bilibili.com"
 	// VideoURL 视频页面域名
 	VideoURL = "https://www.bilibili.com"
+	// SpaceURL 用户空间域名
+	SpaceURL = "https://space.bilibili.com"
 )
 
 // API 端点路径常量
@@ -31,6 +36,32 @@ const (
 	NavEndpoint = "/x/web-interface/nav"
 	// PlayUrlEndpoint 获取播放地址的端点
 	PlayUrlEndpoint = "/x/player/wbi/playurl"
+	// ViewEndpoint 获取视频详细信息的端点
+	ViewEndpoint = "/x/web-interface/view"
+	// PlayerEndpoint 获取播放器信息（分段章节等）的端点
+	PlayerEndpoint = "/x/player/v2"
+	// PlayerWbiEndpoint 获取播放器信息（字幕列表等）的端点，需要 WBI 签名
+	PlayerWbiEndpoint = "/x/player/wbi/v2"
+	// DanmakuSegEndpoint 获取分段弹幕（protobuf，每段 6 分钟）的端点
+	DanmakuSegEndpoint = "/x/v2/dm/web/seg.so"
+	// DanmakuXmlEndpoint 获取旧版 XML 弹幕（deflate 压缩，只包含最近的一部分弹幕）的端点
+	DanmakuXmlEndpoint = "/x/v1/dm/list.so"
+	// StoryEdgeEndpoint 获取互动视频剧情图节点的端点
+	StoryEdgeEndpoint = "/x/stein/edgeinfo_v2"
+	// SpaceArcSearchEndpoint 获取 UP 主投稿列表的端点，需要 WBI 签名
+	SpaceArcSearchEndpoint = "/x/space/wbi/arc/search"
+	// FavFolderListEndpoint 获取用户创建的收藏夹列表的端点
+	FavFolderListEndpoint = "/x/v3/fav/folder/created/list-all"
+	// FavResourceListEndpoint 获取收藏夹内容的端点
+	FavResourceListEndpoint = "/x/v3/fav/resource/list"
+	// SeasonsSeriesListEndpoint 获取 UP 主的合集和系列列表的端点
+	SeasonsSeriesListEndpoint = "/x/polymer/web-space/seasons_series_list"
+	// SeasonsArchivesEndpoint 获取合集视频列表的端点
+	SeasonsArchivesEndpoint = "/x/polymer/web-space/seasons_archives_list"
+	// SeriesEndpoint 获取系列信息的端点
+	SeriesEndpoint = "/x/series/series"
+	// SeriesArchivesEndpoint 获取系列视频列表的端点
+	SeriesArchivesEndpoint = "/x/series/archives"
 )
 
 // 默认请求头
@@ -47,7 +78,7 @@ const (
 	DefaultQn = 80
 	// DefaultFnver 默认版本
 	DefaultFnver = 0
-	// DefaultFnval 默认流类型（DASH）
+	// DefaultFnval 默认流类型（DASH + HDR + 4K + 杜比音频 + 杜比视界 + 8K + AV1）
 	DefaultFnval = 4048
 	// DefaultFourk 默认支持 4K
 	DefaultFourk = 1
@@ -55,12 +86,13 @@ const (
 
 // CidInfo 视频 CID 信息
 type CidInfo struct {
-	Cid      int64  `json:"cid"`
-	Page     int    `json:"page"`
-	Part     string `json:"part"`
-	Duration int    `json:"duration"`
-	Vid      string `json:"vid"`
-	Weblink  string `json:"weblink"`
+	Cid        int64  `json:"cid"`
+	Page       int    `json:"page"`
+	Part       string `json:"part"`
+	Duration   int    `json:"duration"`
+	Vid        string `json:"vid"`
+	Weblink    string `json:"weblink"`
+	FirstFrame string `json:"first_frame"` // 分 P 第一帧截图地址
 }
 
 // Dimension 视频尺寸信息
@@ -79,15 +111,36 @@ type PagelistResponse struct {
 }
 
 // PlayUrlData 播放地址数据
+// 没有 DASH 格式时（较早的稿件、部分番剧和地区）只返回 durl 分段
 type PlayUrlData struct {
-	Dash    DashData `json:"dash"`
-	Quality int      `json:"quality"`
-	Format  string   `json:"format"`
+	Dash         DashData      `json:"dash"`
+	Durl         []DurlSegment `json:"durl"`
+	Quality      int           `json:"quality"`
+	Format       string        `json:"format"`
+	Timelength   int64         `json:"timelength"`    // 时长（毫秒）
+	VideoCodecid int           `json:"video_codecid"` // durl 分段的视频编码
+}
+
+// DurlSegment FLV/MP4 分段，每段包含音视频，按顺序拼接得到完整视频
+type DurlSegment struct {
+	Order     int      `json:"order"`
+	Length    int64    `json:"length"` // 时长（毫秒）
+	Size      int64    `json:"size"`
+	Url       string   `json:"url"`
+	BackupUrl []string `json:"backup_url"`
 }
 
 // DashData DASH 数据，包含视频和音频轨道
 type DashData struct {
-	Video []VideoTrack `json:"video"`
+	Duration int          `json:"duration"` // 时长（秒）
+	Video    []VideoTrack `json:"video"`
+	Audio    []AudioTrack `json:"audio"`
+	Dolby    *DolbyAudio  `json:"dolby"` // 杜比音频，没有时为 nil
+}
+
+// DolbyAudio 杜比音频轨道（E-AC-3，全景声）
+type DolbyAudio struct {
+	Type  int          `json:"type"` // 1 普通杜比音效，2 全景声
 	Audio []AudioTrack `json:"audio"`
 }
 
@@ -149,7 +202,10 @@ type NavResponse struct {
 
 // NavData 用户导航数据
 type NavData struct {
-	WbiImg WbiImgData `json:"wbi_img"`
+	IsLogin bool       `json:"isLogin"`
+	Mid     int64      `json:"mid"`
+	Uname   string     `json:"uname"`
+	WbiImg  WbiImgData `json:"wbi_img"`
 }
 
 // WbiImgData WBI 图片数据
@@ -164,66 +220,71 @@ type WbiKeys struct {
 	SubKey string `json:"sub_key"`
 }
 
+// WbiKeysTTL WBI 密钥缓存有效期，Bilibili 每天更换一次密钥
+const WbiKeysTTL = 12 * time.Hour
+
+// NotLoggedInCode 未登录时 API 返回的 code
+const NotLoggedInCode = -101
+
+// ApiError API 返回的业务错误（code 不为 0）
+type ApiError struct {
+	Code    int
+	Message string
+}
+
+// Error 实现 error 接口
+func (e *ApiError) Error() string {
+	return fmt.Sprintf("API returned error: code=%d, message=%s", e.Code, e.Message)
+}
+
 // ApiService Bilibili API 服务
 type ApiService struct {
-	cookie     string
-	httpClient *http.Client
-	wbiKeys    *WbiKeys
-	wbiMutex   sync.RWMutex
+	client       clientState
+	wbiKeys      *WbiKeys
+	wbiFetchedAt time.Time
+	wbiMutex     sync.RWMutex
 }
 
 // NewApiService 创建 ApiService 实例
-// 参数 cookie: 用户 Cookie，用于身份验证
+// 参数 opts: HTTP 客户端配置（Cookie、User-Agent、超时）
 // 返回：配置好的 ApiService 实例
-func NewApiService(cookie string) *ApiService {
-	return &ApiService{
-		cookie: cookie,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+func NewApiService(opts ClientOptions) *ApiService {
+	s := &ApiService{
 		wbiKeys: nil,
 	}
+	s.client.update(opts)
+	return s
+}
+
+// UpdateOptions 热更新 HTTP 客户端配置，进行中的请求不受影响
+// 参数 opts: 新的 HTTP 客户端配置
+func (s *ApiService) UpdateOptions(opts ClientOptions) {
+	s.client.update(opts)
 }
 
 // GetCid 根据 BV 号获取视频 CID
+// 参数 ctx: 上下文，用于取消请求和传递请求 ID
 // 参数 bvid: 视频的 BV 号
 // 参数 page: 分 P 页码（从 1 开始）
 // 返回：视频 CID 和错误信息
 //
 // API 端点：GET /x/player/pagelist?bvid={bvid}&page={page}
-func (s *ApiService) GetCid(bvid string, page int) (int64, error) {
+func (s *ApiService) GetCid(ctx context.Context, bvid string, page int) (int64, error) {
 	apiUrl := fmt.Sprintf("%s%s?bvid=%s&page=%d", BaseURL, PagelistEndpoint, bvid, page)
 
 	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
 	if err != nil {
 		return 0, fmt.Errorf("Failed to create request: %w", err)
 	}
+	req = EnsureContext(ctx, req)
 
 	// 设置请求头
 	s.setHeaders(req, "")
 
-	// 发送请求
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return 0, fmt.Errorf("Request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 读取响应体
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, fmt.Errorf("Failed to read response body: %w", err)
-	}
-
-	// 解析 JSON 响应
+	// 发送请求并解析 JSON 响应
 	var pagelistResp PagelistResponse
-	if err := json.Unmarshal(body, &pagelistResp); err != nil {
-		return 0, fmt.Errorf("Failed to parse JSON: %w", err)
-	}
-
-	// 检查响应码
-	if pagelistResp.Code != 0 {
-		return 0, fmt.Errorf("API returned error: code=%d, message=%s", pagelistResp.Code, pagelistResp.Message)
+	if err := s.getJSON(req, PagelistEndpoint, &pagelistResp); err != nil {
+		return 0, err
 	}
 
 	// 检查数据是否为空
@@ -236,60 +297,68 @@ func (s *ApiService) GetCid(bvid string, page int) (int64, error) {
 }
 
 // GetWbiKeys 获取 WBI 签名密钥
+// 参数 ctx: 上下文，用于取消请求和传递请求 ID
 // 返回：WbiKeys 结构体和错误信息
 //
 // API 端点：GET /x/web-interface/nav
-// 注意：WBI Keys 会被缓存，避免重复请求
-func (s *ApiService) GetWbiKeys() (*WbiKeys, error) {
+// 注意：WBI Keys 会被缓存 WbiKeysTTL，避免重复请求
+func (s *ApiService) GetWbiKeys(ctx context.Context) (*WbiKeys, error) {
 	// 先尝试读取缓存
 	s.wbiMutex.RLock()
-	if s.wbiKeys != nil {
+	if s.wbiKeysFreshLocked() {
+		keys := s.wbiKeys
 		s.wbiMutex.RUnlock()
-		return s.wbiKeys, nil
+		return keys, nil
 	}
 	s.wbiMutex.RUnlock()
 
-	// 缓存未命中，需要获取
+	// 缓存未命中或已过期，需要获取
 	s.wbiMutex.Lock()
 	defer s.wbiMutex.Unlock()
 
 	// 双重检查锁
-	if s.wbiKeys != nil {
+	if s.wbiKeysFreshLocked() {
 		return s.wbiKeys, nil
 	}
 
+	return s.fetchWbiKeysLocked(ctx)
+}
+
+// wbiKeysFreshLocked 判断缓存的 WBI 密钥是否仍然有效（调用方需持有锁）
+func (s *ApiService) wbiKeysFreshLocked() bool {
+	return s.wbiKeys != nil && time.Since(s.wbiFetchedAt) < WbiKeysTTL
+}
+
+// WbiKeysAge 返回缓存的 WBI 密钥已获取的时长
+// 返回：时长和是否已有缓存
+func (s *ApiService) WbiKeysAge() (time.Duration, bool) {
+	s.wbiMutex.RLock()
+	defer s.wbiMutex.RUnlock()
+	if s.wbiKeys == nil {
+		return 0, false
+	}
+	return time.Since(s.wbiFetchedAt), true
+}
+
+// fetchWbiKeysLocked 从 nav API 获取 WBI 密钥并写入缓存（调用方需持有写锁）
+func (s *ApiService) fetchWbiKeysLocked(ctx context.Context) (*WbiKeys, error) {
 	apiUrl := fmt.Sprintf("%s%s", BaseURL, NavEndpoint)
 
 	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create request: %w", err)
 	}
+	req = EnsureContext(ctx, req)
 
 	// 设置请求头
 	s.setHeaders(req, "")
 
-	// 发送请求
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("Request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// 读取响应体
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to read response body: %w", err)
-	}
-
-	// 解析 JSON 响应
+	// 发送请求并解析 JSON 响应
 	var navResp NavResponse
-	if err := json.Unmarshal(body, &navResp); err != nil {
-		return nil, fmt.Errorf("Failed to parse JSON: %w", err)
-	}
-
-	// 检查响应码
-	if navResp.Code != 0 {
-		return nil, fmt.Errorf("API returned error: code=%d, message=%s", navResp.Code, navResp.Message)
+	err = s.getJSON(req, NavEndpoint, &navResp)
+	metrics.WbiRefreshesTotal.WithLabelValues(metrics.Result(err)).Inc()
+	if err != nil {
+		return nil, err
 	}
 
 	// 从 URL 中提取 img_key 和 sub_key
@@ -305,11 +374,45 @@ func (s *ApiService) GetWbiKeys() (*WbiKeys, error) {
 		ImgKey: imgKey,
 		SubKey: subKey,
 	}
+	s.wbiFetchedAt = time.Now()
+	logging.FromContext(ctx).Info("WBI keys refreshed")
 
 	return s.wbiKeys, nil
 }
 
+// CheckLogin 检查 Cookie 的登录状态
+// 参数 ctx: 上下文，用于控制超时
+// 返回：导航数据（包含是否登录、用户名）和错误信息
+//
+// API 端点：GET /x/web-interface/nav
+// 注意：未登录时 API 返回 code=-101，此时不视为错误，返回 IsLogin 为 false
+func (s *ApiService) CheckLogin(ctx context.Context) (*NavData, error) {
+	apiUrl := fmt.Sprintf("%s%s", BaseURL, NavEndpoint)
+
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+
+	// 设置请求头
+	s.setHeaders(req, "")
+
+	// 发送请求并解析 JSON 响应
+	var navResp NavResponse
+	if err := s.getJSON(req, NavEndpoint, &navResp); err != nil {
+		var apiErr *ApiError
+		if errors.As(err, &apiErr) && apiErr.Code == NotLoggedInCode {
+			return &NavData{IsLogin: false}, nil
+		}
+		return nil, err
+	}
+
+	return &navResp.Data, nil
+}
+
 // GetPlayUrl 获取视频播放地址
+// 参数 ctx: 上下文，用于取消请求和传递请求 ID
 // 参数 bvid: 视频 BV 号
 // 参数 cid: 视频 CID
 // 参数 quality: 清晰度（qn 值，默认 80）
@@ -317,13 +420,7 @@ func (s *ApiService) GetWbiKeys() (*WbiKeys, error) {
 //
 // API 端点：GET /x/player/wbi/playurl
 // 注意：此方法需要 WBI 签名，会自动调用 GetWbiKeys 获取密钥
-func (s *ApiService) GetPlayUrl(bvid string, cid int64, quality int) (*PlayUrlData, error) {
-	// 获取 WBI Keys
-	wbiKeys, err := s.GetWbiKeys()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to get WBI Keys: %w", err)
-	}
-
+func (s *ApiService) GetPlayUrl(ctx context.Context, bvid string, cid int64, quality int) (*PlayUrlData, error) {
 	// 构建原始参数
 	params := map[string]interface{}{
 		"bvid":   bvid,
@@ -334,57 +431,109 @@ func (s *ApiService) GetPlayUrl(bvid string, cid int64, quality int) (*PlayUrlDa
 		"fourk":  DefaultFourk,
 	}
 
-	// 生成签名参数
-	signedParams := utils.EncWbi(params, wbiKeys.ImgKey, wbiKeys.SubKey)
-
-	// 构建查询字符串
-	query := buildQueryString(signedParams)
-
-	// 构建完整 URL
-	apiUrl := fmt.Sprintf("%s%s?%s", BaseURL, PlayUrlEndpoint, query)
+	// 生成带 WBI 签名的完整 URL
+	apiUrl, err := s.signedUrl(ctx, PlayUrlEndpoint, params)
+	if err != nil {
+		return nil, err
+	}
 
 	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create request: %w", err)
 	}
+	req = EnsureContext(ctx, req)
 
 	// 设置请求头，Referer 需要包含 BV 号
 	referer := fmt.Sprintf("%s/video/%s/", VideoURL, bvid)
 	s.setHeaders(req, referer)
 
+	// 发送请求并解析 JSON 响应
+	var playUrlResp PlayUrlResponse
+	if err := s.getJSON(req, PlayUrlEndpoint, &playUrlResp); err != nil {
+		return nil, err
+	}
+
+	return &playUrlResp.Data, nil
+}
+
+// signedUrl 使用 WBI 签名生成完整的请求地址
+// 参数 ctx: 上下文，WBI Keys 过期时用于重新获取
+// 参数 endpoint: API 端点路径
+// 参数 params: 原始查询参数
+// 返回：完整 URL 和错误信息
+func (s *ApiService) signedUrl(ctx context.Context, endpoint string, params map[string]interface{}) (string, error) {
+	// 获取 WBI Keys
+	wbiKeys, err := s.GetWbiKeys(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get WBI Keys: %w", err)
+	}
+
+	// 生成签名参数并构建查询字符串
+	signedParams := utils.EncWbi(params, wbiKeys.ImgKey, wbiKeys.SubKey)
+	return fmt.Sprintf("%s%s?%s", BaseURL, endpoint, buildQueryString(signedParams)), nil
+}
+
+// baseResponse 所有 API 响应共有的字段
+type baseResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// getJSON 发送请求并解析 JSON 响应，同时记录 API 调用指标
+// 参数 req: 已设置好请求头的 HTTP 请求
+// 参数 endpoint: API 端点路径，用于指标标签
+// 参数 v: 响应结构体指针
+// 返回：错误信息（响应 code 不为 0 时同样返回错误）
+func (s *ApiService) getJSON(req *http.Request, endpoint string, v interface{}) error {
+	logger := logging.FromContext(req.Context()).With("endpoint", endpoint)
+	start := time.Now()
+
 	// 发送请求
-	resp, err := s.httpClient.Do(req)
+	_, client := s.client.get()
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Request failed: %w", err)
+		metrics.ObserveApi(endpoint, 0, err, start)
+		logger.Warn("Bilibili API request failed", "error", err, "duration_ms", time.Since(start).Milliseconds())
+		return fmt.Errorf("Request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// 读取响应体
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read response body: %w", err)
+		metrics.ObserveApi(endpoint, 0, err, start)
+		return fmt.Errorf("Failed to read response body: %w", err)
 	}
 
-	// 解析 JSON 响应
-	var playUrlResp PlayUrlResponse
-	if err := json.Unmarshal(body, &playUrlResp); err != nil {
-		return nil, fmt.Errorf("Failed to parse JSON: %w", err)
+	// 先解析公共字段，检查响应码
+	var base baseResponse
+	if err := json.Unmarshal(body, &base); err != nil {
+		metrics.ObserveApi(endpoint, 0, err, start)
+		return fmt.Errorf("Failed to parse JSON: %w", err)
 	}
+	metrics.ObserveApi(endpoint, base.Code, nil, start)
+	logger.Debug("Bilibili API call", "code", base.Code, "duration_ms", time.Since(start).Milliseconds())
 
-	// 检查响应码
-	if playUrlResp.Code != 0 {
-		return nil, fmt.Errorf("API returned error: code=%d, message=%s", playUrlResp.Code, playUrlResp.Message)
+	if base.Code != 0 {
+		logger.Warn("Bilibili API returned error", "code", base.Code, "message", base.Message)
+		return &ApiError{Code: base.Code, Message: base.Message}
 	}
 
-	return &playUrlResp.Data, nil
+	// 解析完整响应
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("Failed to parse JSON: %w", err)
+	}
+	return nil
 }
 
 // setHeaders 设置 HTTP 请求头
 // 参数 req: HTTP 请求
 // 参数 referer: Referer 头，如果为空则使用默认值
 func (s *ApiService) setHeaders(req *http.Request, referer string) {
+	opts, _ := s.client.get()
+
 	// 设置 User-Agent
-	req.Header.Set("User-Agent", DefaultUserAgent)
+	req.Header.Set("User-Agent", opts.UserAgent)
 
 	// 设置 Referer
 	if referer != "" {
@@ -394,8 +543,8 @@ func (s *ApiService) setHeaders(req *http.Request, referer string) {
 	}
 
 	// 设置 Cookie
-	if s.cookie != "" {
-		req.Header.Set("Cookie", s.cookie)
+	if opts.Cookie != "" {
+		req.Header.Set("Cookie", opts.Cookie)
 	}
 }
 
@@ -432,15 +581,16 @@ func buildQueryString(params map[string]interface{}) string {
 
 // RefreshWbiKeys 强制刷新 WBI Keys 缓存
 // 用于在缓存失效时重新获取密钥
-func (s *ApiService) RefreshWbiKeys() (*WbiKeys, error) {
+// 参数 ctx: 上下文，用于取消请求和传递请求 ID
+func (s *ApiService) RefreshWbiKeys(ctx context.Context) (*WbiKeys, error) {
 	s.wbiMutex.Lock()
 	defer s.wbiMutex.Unlock()
 
 	// 清空缓存
 	s.wbiKeys = nil
 
-	// 重新获取
-	return s.GetWbiKeys()
+	// 重新获取（已持有写锁，不能再调用 GetWbiKeys）
+	return s.fetchWbiKeysLocked(ctx)
 }
 
 // GetVideoUrl 获取视频下载地址（优先使用 baseUrl，备用 backupUrl）
@@ -456,6 +606,46 @@ func GetVideoUrl(video VideoTrack) string {
 	return ""
 }
 
+// 视频编码名称到 codecid 的映射
+var videoCodecIds = map[string]int{
+	"avc":  7,
+	"hevc": 12,
+	"av1":  13,
+}
+
+// SelectVideoTrack 从 DASH 视频轨道中选择要下载的轨道
+// 参数 tracks: 视频轨道列表（不能为空）
+// 参数 quality: 播放地址接口实际返回的清晰度
+// 参数 codec: 编码偏好（avc/hevc/av1），为空或无匹配时不限制
+// 返回：选中的视频轨道
+//
+// 优先选择 id 与 quality 一致的轨道，没有时使用列表中第一条轨道的清晰度，
+// 在同一清晰度的轨道中优先选择符合编码偏好的轨道
+func SelectVideoTrack(tracks []VideoTrack, quality int, codec string) VideoTrack {
+	var candidates []VideoTrack
+	for _, t := range tracks {
+		if t.Id == quality {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, t := range tracks {
+			if t.Id == tracks[0].Id {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+
+	if codecId, ok := videoCodecIds[codec]; ok {
+		for _, t := range candidates {
+			if t.Codecid == codecId {
+				return t
+			}
+		}
+	}
+	return candidates[0]
+}
+
 // GetAudioUrl 获取音频下载地址（优先使用 baseUrl，备用 backupUrl）
 // 参数 audio: AudioTrack 结构体
 // 返回：音频下载地址
@@ -490,7 +680,8 @@ func (s *ApiService) SetHeadersForRequest(req *http.Request, referer string) {
 // GetHttpClient 获取 HTTP 客户端（导出方法供 handler 使用）
 // 返回：http.Client 指针
 func (s *ApiService) GetHttpClient() *http.Client {
-	return s.httpClient
+	_, client := s.client.get()
+	return client
 }
 
 // UnmarshalPagelistResponse 解析 PagelistResponse JSON（导出函数供 handler 使用）
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache 基于目录的文件缓存
+// 缓存键经过 SHA-256 后作为文件名，按前两位分子目录存放；
+// 命中时刷新修改时间，超过 TTL 未被访问的文件由 Prune 删除
+type Cache struct {
+	mu  sync.RWMutex
+	dir string
+	ttl time.Duration
+}
+
+// NewCache 创建缓存实例
+// 参数 dir: 缓存目录，为空时不启用缓存
+// 参数 ttl: 缓存文件超过该时长未被访问时删除，小于等于 0 表示不过期
+// 返回：Cache 实例
+func NewCache(dir string, ttl time.Duration) *Cache {
+	c := &Cache{}
+	c.Update(dir, ttl)
+	return c
+}
+
+// Update 热更新缓存目录和过期时长，切换目录后旧目录中的文件不再使用
+func (c *Cache) Update(dir string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dir = dir
+	c.ttl = ttl
+}
+
+// Enabled 是否配置了缓存目录
+func (c *Cache) Enabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.dir != ""
+}
+
+// path 返回缓存键对应的文件路径，未启用缓存时返回空字符串
+func (c *Cache) path(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.dir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// Open 打开缓存文件
+// 参数 key: 缓存键
+// 返回：缓存文件，未命中或未启用缓存时返回 nil 和 fs.ErrNotExist
+func (c *Cache) Open(key string) (*os.File, error) {
+	path := c.path(key)
+	if path == "" {
+		return nil, fs.ErrNotExist
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// 刷新修改时间，作为最近访问时间
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return file, nil
+}
+
+// Store 把文件存入缓存，先写入临时文件再重命名，读取方不会看到写了一半的文件
+// 参数 key: 缓存键
+// 参数 src: 要缓存的文件路径，调用后仍然保留
+// 返回：错误信息，未启用缓存时直接返回 nil
+func (c *Cache) Store(key, src string) error {
+	path := c.path(key)
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("Failed to create cache directory: %w", err)
+	}
+
+	tmp := fmt.Sprintf("%s.tmp%d", path, time.Now().UnixNano())
+	// 同一文件系统时使用硬链接，避免复制大文件
+	if err := os.Link(src, tmp); err != nil {
+		if err := copyFile(src, tmp); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("Failed to copy into cache: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Failed to store cache entry: %w", err)
+	}
+	return nil
+}
+
+// Prune 删除超过 TTL 未被访问的缓存文件和残留的临时文件
+// 返回：删除的文件数量和错误信息
+func (c *Cache) Prune() (int, error) {
+	c.mu.RLock()
+	dir, ttl := c.dir, c.ttl
+	c.mu.RUnlock()
+	if dir == "" || ttl <= 0 {
+		return 0, nil
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-ttl)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("Failed to prune cache: %w", err)
+	}
+	return removed, nil
+}
+
+// copyFile 复制文件内容
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/metrics"
+)
+
+// ErrClipOutOfRange 截取的时间范围超出视频时长
+var ErrClipOutOfRange = errors.New("Clip range is out of video duration")
+
+// Clip 截取片段的时间范围
+// DASH 轨道带有 sidx 索引时只下载覆盖该范围的分段，否则下载整个文件后截取
+type Clip struct {
+	Start      time.Duration // 开始时间
+	End        time.Duration // 结束时间，0 表示到视频结尾
+	VideoIndex SegmentBase   // 视频轨道的初始化段和 sidx 索引位置
+	AudioIndex SegmentBase   // 音频轨道的初始化段和 sidx 索引位置
+}
+
+// Key 返回时间范围的标识，用于生成缓存键
+func (c *Clip) Key() string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", c.Start.Milliseconds(), c.End.Milliseconds())
+}
+
+// inputArgs 返回音视频输入文件之前的截取参数
+// 分段下载的文件去掉了 sidx，忽略 tfdt 使时间戳从第一个分段开始计算，再定位到开始时间
+// 参数 offset: 文件中第一个分段的开始时间，下载完整文件时为 0
+func (c *Clip) inputArgs(offset time.Duration) []string {
+	if c == nil {
+		return nil
+	}
+	return []string{"-use_tfdt", "0", "-ss", ffmpegTime(max(c.Start-offset, 0))}
+}
+
+// outputArgs 返回限制输出时长的参数
+func (c *Clip) outputArgs() []string {
+	if c == nil || c.End <= 0 {
+		return nil
+	}
+	return []string{"-t", ffmpegTime(c.End - c.Start)}
+}
+
+// ffmpegTime 把时长格式化为 FFmpeg 的秒数
+func ffmpegTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// sidx 索引（ISO/IEC 14496-12 SegmentIndexBox）
+type sidx struct {
+	timescale   uint64
+	earliestPts uint64
+	firstOffset uint64 // 第一个分段相对 sidx 结尾的偏移
+	size        int64  // sidx box 大小
+	refs        []sidxRef
+}
+
+// sidxRef sidx 中的一个分段
+type sidxRef struct {
+	size     uint32 // 分段字节数
+	duration uint32 // 分段时长（timescale 单位）
+}
+
+// parseRange 解析 "start-end" 形式的字节范围
+func parseRange(s string) (start, end int64, err error) {
+	a, b, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("Invalid byte range %q", s)
+	}
+	if start, err = strconv.ParseInt(a, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("Invalid byte range %q", s)
+	}
+	if end, err = strconv.ParseInt(b, 10, 64); err != nil || end < start {
+		return 0, 0, fmt.Errorf("Invalid byte range %q", s)
+	}
+	return start, end, nil
+}
+
+// parseSidx 解析 sidx box
+func parseSidx(data []byte) (*sidx, error) {
+	errTruncated := errors.New("Truncated sidx box")
+	if len(data) < 8 {
+		return nil, errTruncated
+	}
+	size := int64(binary.BigEndian.Uint32(data))
+	if string(data[4:8]) != "sidx" {
+		return nil, fmt.Errorf("Expected sidx box, got %q", data[4:8])
+	}
+	p := 8
+	if size == 1 {
+		if len(data) < 16 {
+			return nil, errTruncated
+		}
+		size = int64(binary.BigEndian.Uint64(data[8:]))
+		p = 16
+	}
+	if int64(len(data)) < size {
+		return nil, errTruncated
+	}
+	data = data[:size]
+
+	// version(1) flags(3) reference_ID(4) timescale(4)
+	if len(data) < p+12 {
+		return nil, errTruncated
+	}
+	version := data[p]
+	s := &sidx{size: size, timescale: uint64(binary.BigEndian.Uint32(data[p+8:]))}
+	p += 12
+	if version == 0 {
+		if len(data) < p+8 {
+			return nil, errTruncated
+		}
+		s.earliestPts = uint64(binary.BigEndian.Uint32(data[p:]))
+		s.firstOffset = uint64(binary.BigEndian.Uint32(data[p+4:]))
+		p += 8
+	} else {
+		if len(data) < p+16 {
+			return nil, errTruncated
+		}
+		s.earliestPts = binary.BigEndian.Uint64(data[p:])
+		s.firstOffset = binary.BigEndian.Uint64(data[p+8:])
+		p += 16
+	}
+	if s.timescale == 0 {
+		return nil, fmt.Errorf("Invalid sidx timescale")
+	}
+
+	// reserved(2) reference_count(2)，每个分段 12 字节
+	if len(data) < p+4 {
+		return nil, errTruncated
+	}
+	count := int(binary.BigEndian.Uint16(data[p+2:]))
+	p += 4
+	if count == 0 {
+		return nil, fmt.Errorf("Empty sidx box")
+	}
+	if len(data) < p+count*12 {
+		return nil, errTruncated
+	}
+	for i := 0; i < count; i++ {
+		ref := data[p+i*12:]
+		s.refs = append(s.refs, sidxRef{
+			size:     binary.BigEndian.Uint32(ref) & 0x7fffffff,
+			duration: binary.BigEndian.Uint32(ref[4:]),
+		})
+	}
+	return s, nil
+}
+
+// span 计算覆盖时间范围的分段
+// 参数 sidxStart: sidx box 在文件中的起始位置
+// 参数 start, end: 时间范围，end 为 0 表示到结尾
+// 返回：分段的字节范围和第一个分段的开始时间
+func (s *sidx) span(sidxStart int64, start, end time.Duration) (from, to int64, segStart time.Duration) {
+	offset := sidxStart + s.size + int64(s.firstOffset)
+	var t uint64
+	first := -1
+	for i, ref := range s.refs {
+		segFrom := time.Duration(t * uint64(time.Second) / s.timescale)
+		t += uint64(ref.duration)
+		segTo := time.Duration(t * uint64(time.Second) / s.timescale)
+
+		if first < 0 && (segTo > start || i == len(s.refs)-1) {
+			first = i
+			from, segStart = offset, segFrom
+		}
+		offset += int64(ref.size)
+		if first >= 0 {
+			to = offset - 1
+			if end > 0 && segTo >= end {
+				break
+			}
+		}
+	}
+	return from, to, segStart
+}
+
+// downloadClip 下载轨道中覆盖时间范围的部分：初始化段加上对应的分段，
+// 输出是去掉 sidx 的 fragmented MP4，时间戳从第一个分段开始计算
+// 参数 ctx: 上下文
+// 参数 url: 轨道地址
+// 参数 referer: Referer 头
+// 参数 filename: 保存的文件名
+// 参数 index: 轨道的初始化段和 sidx 索引位置
+// 参数 clip: 时间范围
+// 返回：文件中第一个分段的开始时间和错误信息
+func (d *Downloader) downloadClip(ctx context.Context, url, referer, filename string, index SegmentBase, clip *Clip) (time.Duration, error) {
+	_, initEnd, err := parseRange(index.Initialization)
+	if err != nil {
+		return 0, err
+	}
+	indexStart, indexEnd, err := parseRange(index.IndexRange)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create file: %w", err)
+	}
+	defer file.Close()
+
+	// 初始化段和 sidx 通常相邻，一次请求取回
+	head, err := d.fetchRange(ctx, url, referer, 0, indexEnd)
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(head)) <= indexEnd || initEnd >= indexStart {
+		return 0, fmt.Errorf("Unexpected index layout")
+	}
+	idx, err := parseSidx(head[indexStart:])
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.Write(head[:initEnd+1]); err != nil {
+		return 0, fmt.Errorf("Failed to write file: %w", err)
+	}
+
+	from, to, segStart := idx.span(indexStart, clip.Start, clip.End)
+	if to < from {
+		return 0, fmt.Errorf("%w: no segment covers the range", ErrClipOutOfRange)
+	}
+	start := time.Now()
+	n, err := d.copyRange(ctx, url, referer, from, to, file)
+	if err != nil {
+		return 0, err
+	}
+
+	logging.FromContext(ctx).Debug("CDN range download finished",
+		"file", filepath.Base(filename),
+		"bytes", n,
+		"range", fmt.Sprintf("%d-%d", from, to),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+	return segStart, nil
+}
+
+// fetchRange 下载文件的一段并返回内容
+func (d *Downloader) fetchRange(ctx context.Context, url, referer string, from, to int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := d.copyRange(ctx, url, referer, from, to, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// copyRange 使用 Range 请求下载文件的一段并写入 w
+// 返回：写入的字节数和错误信息
+func (d *Downloader) copyRange(ctx context.Context, url, referer string, from, to int64, w io.Writer) (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+	d.setDownloadHeaders(req, referer)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
+
+	_, client := d.client.get()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("Request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("Range request failed, status code: %d", resp.StatusCode)
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, to-from+1))
+	metrics.CdnBytesTotal.Add(float64(n))
+	if err != nil {
+		return n, fmt.Errorf("Failed to write file: %w", err)
+	}
+	if n != to-from+1 {
+		return n, fmt.Errorf("Short range response: got %d of %d bytes", n, to-from+1)
+	}
+	return n, nil
+}
+
+// downloadTrack 下载一条轨道：指定时间范围时只下载所需分段，索引不可用时退回下载整个文件
+// 返回：文件中第一个分段的开始时间和错误信息
+func (d *Downloader) downloadTrack(ctx context.Context, url, referer, filename string, index SegmentBase, clip *Clip) (time.Duration, error) {
+	if clip != nil && index.IndexRange != "" {
+		segStart, err := d.downloadClip(ctx, url, referer, filename, index, clip)
+		if err == nil || ctx.Err() != nil {
+			return segStart, err
+		}
+		logging.FromContext(ctx).Warn("Range download failed, downloading whole file", "file", filepath.Base(filename), "error", err)
+	}
+	return 0, d.DownloadFile(ctx, url, referer, filename)
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+	"time"
+)
+
+// buildSidx 按 ISO/IEC 14496-12 生成 sidx box
+// 参数 version: 0 时 earliest_presentation_time 和 first_offset 为 32 位，1 时为 64 位
+// 参数 largeSize: 是否使用 64 位 largesize 表示 box 大小
+// 参数 refs: 每个分段的字节数和时长
+func buildSidx(version byte, largeSize bool, timescale uint32, earliestPts, firstOffset uint64, refs []sidxRef) []byte {
+	var body []byte
+	body = append(body, version, 0, 0, 0)
+	body = binary.BigEndian.AppendUint32(body, 1) // reference_ID
+	body = binary.BigEndian.AppendUint32(body, timescale)
+	if version == 0 {
+		body = binary.BigEndian.AppendUint32(body, uint32(earliestPts))
+		body = binary.BigEndian.AppendUint32(body, uint32(firstOffset))
+	} else {
+		body = binary.BigEndian.AppendUint64(body, earliestPts)
+		body = binary.BigEndian.AppendUint64(body, firstOffset)
+	}
+	body = binary.BigEndian.AppendUint16(body, 0) // reserved
+	body = binary.BigEndian.AppendUint16(body, uint16(len(refs)))
+	for _, ref := range refs {
+		// reference_type 为 0；最高位设置时解析结果应当忽略
+		body = binary.BigEndian.AppendUint32(body, ref.size|0x80000000)
+		body = binary.BigEndian.AppendUint32(body, ref.duration)
+		body = binary.BigEndian.AppendUint32(body, 0x90000000) // starts_with_SAP, SAP_type
+	}
+
+	var box []byte
+	if largeSize {
+		box = binary.BigEndian.AppendUint32(box, 1)
+		box = append(box, "sidx"...)
+		box = binary.BigEndian.AppendUint64(box, uint64(16+len(body)))
+	} else {
+		box = binary.BigEndian.AppendUint32(box, uint32(8+len(body)))
+		box = append(box, "sidx"...)
+	}
+	return append(box, body...)
+}
+
+func TestParseSidx(t *testing.T) {
+	refs := []sidxRef{{size: 1000, duration: 2000}, {size: 1500, duration: 2000}, {size: 800, duration: 1000}}
+	tests := []struct {
+		name      string
+		data      []byte
+		wantPts   uint64
+		wantFirst uint64
+	}{
+		{"version 0", buildSidx(0, false, 1000, 10, 20, refs), 10, 20},
+		{"version 1", buildSidx(1, false, 1000, 1<<33, 1<<34, refs), 1 << 33, 1 << 34},
+		{"largesize", buildSidx(1, true, 1000, 0, 0, refs), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// sidx 之后的数据不属于 box
+			s, err := parseSidx(append(tt.data, 0xff, 0xff))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.size != int64(len(tt.data)) {
+				t.Errorf("size = %d, want %d", s.size, len(tt.data))
+			}
+			if s.timescale != 1000 || s.earliestPts != tt.wantPts || s.firstOffset != tt.wantFirst {
+				t.Errorf("timescale %d, earliest %d, first offset %d", s.timescale, s.earliestPts, s.firstOffset)
+			}
+			if !slices.Equal(s.refs, refs) {
+				t.Errorf("refs = %v, want %v", s.refs, refs)
+			}
+		})
+	}
+}
+
+func TestParseSidxErrors(t *testing.T) {
+	valid := buildSidx(0, false, 1000, 0, 0, []sidxRef{{size: 1000, duration: 1000}})
+	zeroTimescale := buildSidx(0, false, 0, 0, 0, []sidxRef{{size: 1000, duration: 1000}})
+	empty := buildSidx(1, false, 1000, 0, 0, nil)
+	wrongType := slices.Clone(valid)
+	copy(wrongType[4:], "moof")
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", valid[:4]},
+		{"wrong box type", wrongType},
+		{"truncated box", valid[:len(valid)-1]},
+		{"truncated largesize", buildSidx(0, true, 1000, 0, 0, nil)[:12]},
+		{"zero timescale", zeroTimescale},
+		{"no references", empty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s, err := parseSidx(tt.data); err == nil {
+				t.Errorf("parseSidx succeeded: %+v", s)
+			}
+		})
+	}
+}
+
+func TestSidxSpan(t *testing.T) {
+	// 3 个分段：0-2s（1000 字节）、2-4s（1500 字节）、4-5s（800 字节），第一个分段紧跟在 sidx 之后 20 字节处
+	s := &sidx{timescale: 1000, firstOffset: 20, size: 80, refs: []sidxRef{{1000, 2000}, {1500, 2000}, {800, 1000}}}
+	const sidxStart = 500
+	first := int64(sidxStart + 80 + 20)
+
+	tests := []struct {
+		name       string
+		start, end time.Duration
+		from, to   int64
+		segStart   time.Duration
+	}{
+		{"whole track", 0, 0, first, first + 3300 - 1, 0},
+		{"first segment", 0, time.Second, first, first + 1000 - 1, 0},
+		{"segment boundary", 2 * time.Second, 4 * time.Second, first + 1000, first + 2500 - 1, 2 * time.Second},
+		{"across segments", 1500 * time.Millisecond, 4500 * time.Millisecond, first, first + 3300 - 1, 0},
+		{"to the end", 3 * time.Second, 0, first + 1000, first + 3300 - 1, 2 * time.Second},
+		{"past the end", 10 * time.Second, 0, first + 2500, first + 3300 - 1, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, segStart := s.span(sidxStart, tt.start, tt.end)
+			if from != tt.from || to != tt.to || segStart != tt.segStart {
+				t.Errorf("span = %d-%d from %s, want %d-%d from %s", from, to, segStart, tt.from, tt.to, tt.segStart)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end int64
+		ok         bool
+	}{
+		{"0-931", 0, 931, true},
+		{"932-1234", 932, 1234, true},
+		{"5-5", 5, 5, true},
+		{"10-5", 0, 0, false},
+		{"10", 0, 0, false},
+		{"a-5", 0, 0, false},
+		{"0-", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, err := parseRange(tt.in)
+		if (err == nil) != tt.ok || start != tt.start || end != tt.end {
+			t.Errorf("parseRange(%q) = %d, %d, %v", tt.in, start, end, err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// 合集和系列的分页参数
+const (
+	collectionListPageSize    = 20  // 合集和系列列表每页数量
+	collectionArchivePageSize = 100 // 合集和系列视频列表每页数量
+)
+
+// ErrNotInCollection 视频不属于任何合集
+var ErrNotInCollection = errors.New("Video does not belong to a collection")
+
+// CollectionKind 视频分组类型
+type CollectionKind string
+
+const (
+	CollectionSeason CollectionKind = "season" // 合集（ugc_season），视频页面会显示合集列表
+	CollectionSeries CollectionKind = "series" // 系列，只在 UP 主空间中显示
+)
+
+// CollectionMeta 合集或系列的基本信息
+type CollectionMeta struct {
+	Kind        CollectionKind `json:"kind"`
+	Id          int64          `json:"id"`
+	Mid         int64          `json:"mid"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Cover       string         `json:"cover"`
+	Total       int            `json:"total"`
+}
+
+// CollectionArchive 合集或系列中的一个视频
+type CollectionArchive struct {
+	Aid      int64  `json:"aid"`
+	Bvid     string `json:"bvid"`
+	Title    string `json:"title"`
+	Pic      string `json:"pic"`
+	Duration int    `json:"duration"` // 时长（秒）
+	Pubdate  int64  `json:"pubdate"`  // 发布时间（Unix 秒）
+}
+
+// Collection 合集或系列及其全部视频（按合集中的顺序排列）
+type Collection struct {
+	CollectionMeta
+	Archives []CollectionArchive `json:"archives"`
+}
+
+// collectionMetaData 合集和系列 API 中的 meta 字段
+type collectionMetaData struct {
+	SeasonId    int64  `json:"season_id"`
+	SeriesId    int64  `json:"series_id"`
+	Mid         int64  `json:"mid"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Cover       string `json:"cover"`
+	Total       int    `json:"total"`
+}
+
+// meta 转换为合集或系列的基本信息
+func (m collectionMetaData) meta(kind CollectionKind) CollectionMeta {
+	id := m.SeasonId
+	if kind == CollectionSeries {
+		id = m.SeriesId
+	}
+	return CollectionMeta{
+		Kind:        kind,
+		Id:          id,
+		Mid:         m.Mid,
+		Title:       m.Name,
+		Description: m.Description,
+		Cover:       m.Cover,
+		Total:       m.Total,
+	}
+}
+
+// seasonsSeriesListResponse 合集和系列列表 API 响应
+type seasonsSeriesListResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		ItemsLists struct {
+			Page struct {
+				PageNum  int `json:"page_num"`
+				PageSize int `json:"page_size"`
+				Total    int `json:"total"`
+			} `json:"page"`
+			SeasonsList []struct {
+				Meta collectionMetaData `json:"meta"`
+			} `json:"seasons_list"`
+			SeriesList []struct {
+				Meta collectionMetaData `json:"meta"`
+			} `json:"series_list"`
+		} `json:"items_lists"`
+	} `json:"data"`
+}
+
+// seasonsArchivesResponse 合集视频列表 API 响应
+type seasonsArchivesResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Archives []CollectionArchive `json:"archives"`
+		Meta     collectionMetaData  `json:"meta"`
+		Page     struct {
+			PageNum  int `json:"page_num"`
+			PageSize int `json:"page_size"`
+			Total    int `json:"total"`
+		} `json:"page"`
+	} `json:"data"`
+}
+
+// seriesResponse 系列信息 API 响应
+type seriesResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Meta collectionMetaData `json:"meta"`
+	} `json:"data"`
+}
+
+// seriesArchivesResponse 系列视频列表 API 响应
+type seriesArchivesResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Archives []CollectionArchive `json:"archives"`
+		Page     struct {
+			Num   int `json:"num"`
+			Size  int `json:"size"`
+			Total int `json:"total"`
+		} `json:"page"`
+	} `json:"data"`
+}
+
+// ListSpaceCollections 获取 UP 主创建的全部合集和系列
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 mid: UP 主 ID
+// 返回：合集和系列列表（合集在前）和错误信息
+func (s *ApiService) ListSpaceCollections(ctx context.Context, mid int64) ([]CollectionMeta, error) {
+	var seasons, series []CollectionMeta
+	for page := 1; ; page++ {
+		apiUrl := fmt.Sprintf("%s%s?mid=%d&page_num=%d&page_size=%d", BaseURL, SeasonsSeriesListEndpoint, mid, page, collectionListPageSize)
+		var listResp seasonsSeriesListResponse
+		if err := s.getCollectionJSON(ctx, apiUrl, SeasonsSeriesListEndpoint, mid, &listResp); err != nil {
+			return nil, fmt.Errorf("Failed to get page %d: %w", page, err)
+		}
+
+		lists := listResp.Data.ItemsLists
+		for _, item := range lists.SeasonsList {
+			seasons = append(seasons, item.Meta.meta(CollectionSeason))
+		}
+		for _, item := range lists.SeriesList {
+			series = append(series, item.Meta.meta(CollectionSeries))
+		}
+		if len(lists.SeasonsList)+len(lists.SeriesList) == 0 || page*collectionListPageSize >= lists.Page.Total {
+			return append(seasons, series...), nil
+		}
+	}
+}
+
+// GetCollection 获取合集或系列的基本信息和全部视频
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 kind: 合集或系列
+// 参数 mid: UP 主 ID
+// 参数 id: 合集 ID（season_id）或系列 ID（series_id）
+// 返回：合集或系列和错误信息
+func (s *ApiService) GetCollection(ctx context.Context, kind CollectionKind, mid, id int64) (*Collection, error) {
+	if kind == CollectionSeries {
+		return s.getSeries(ctx, mid, id)
+	}
+	return s.getSeason(ctx, mid, id)
+}
+
+// getSeason 逐页获取合集的全部视频
+func (s *ApiService) getSeason(ctx context.Context, mid, seasonId int64) (*Collection, error) {
+	collection := &Collection{}
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("mid", strconv.FormatInt(mid, 10))
+		query.Set("season_id", strconv.FormatInt(seasonId, 10))
+		query.Set("sort_reverse", "false")
+		query.Set("page_num", strconv.Itoa(page))
+		query.Set("page_size", strconv.Itoa(collectionArchivePageSize))
+		apiUrl := fmt.Sprintf("%s%s?%s", BaseURL, SeasonsArchivesEndpoint, query.Encode())
+
+		var archivesResp seasonsArchivesResponse
+		if err := s.getCollectionJSON(ctx, apiUrl, SeasonsArchivesEndpoint, mid, &archivesResp); err != nil {
+			return nil, fmt.Errorf("Failed to get page %d: %w", page, err)
+		}
+
+		data := archivesResp.Data
+		collection.CollectionMeta = data.Meta.meta(CollectionSeason)
+		collection.Archives = append(collection.Archives, data.Archives...)
+		if len(data.Archives) == 0 || page*collectionArchivePageSize >= data.Page.Total {
+			return collection, nil
+		}
+	}
+}
+
+// getSeries 获取系列信息，再逐页获取系列的全部视频
+func (s *ApiService) getSeries(ctx context.Context, mid, seriesId int64) (*Collection, error) {
+	apiUrl := fmt.Sprintf("%s%s?series_id=%d", BaseURL, SeriesEndpoint, seriesId)
+	var seriesResp seriesResponse
+	if err := s.getCollectionJSON(ctx, apiUrl, SeriesEndpoint, mid, &seriesResp); err != nil {
+		return nil, err
+	}
+	collection := &Collection{CollectionMeta: seriesResp.Data.Meta.meta(CollectionSeries)}
+
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("mid", strconv.FormatInt(mid, 10))
+		query.Set("series_id", strconv.FormatInt(seriesId, 10))
+		query.Set("only_normal", "true")
+		query.Set("sort", "asc")
+		query.Set("pn", strconv.Itoa(page))
+		query.Set("ps", strconv.Itoa(collectionArchivePageSize))
+		apiUrl := fmt.Sprintf("%s%s?%s", BaseURL, SeriesArchivesEndpoint, query.Encode())
+
+		var archivesResp seriesArchivesResponse
+		if err := s.getCollectionJSON(ctx, apiUrl, SeriesArchivesEndpoint, mid, &archivesResp); err != nil {
+			return nil, fmt.Errorf("Failed to get page %d: %w", page, err)
+		}
+
+		data := archivesResp.Data
+		collection.Archives = append(collection.Archives, data.Archives...)
+		if len(data.Archives) == 0 || page*collectionArchivePageSize >= data.Page.Total {
+			return collection, nil
+		}
+	}
+}
+
+// getCollectionJSON 请求合集和系列相关 API，Referer 为 UP 主空间页面
+func (s *ApiService) getCollectionJSON(ctx context.Context, apiUrl, endpoint string, mid int64, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+	s.setHeaders(req, fmt.Sprintf("%s/%d", SpaceURL, mid))
+	return s.getJSON(req, endpoint, v)
+}
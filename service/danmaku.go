@@ -0,0 +1,530 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/metrics"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// danmakuSegment 分段弹幕每段的时长
+const danmakuSegment = 6 * time.Minute
+
+// 弹幕模式
+const (
+	DanmakuScroll  = 1 // 滚动弹幕（2、3 同样按滚动处理）
+	DanmakuBottom  = 4 // 底部弹幕
+	DanmakuTop     = 5 // 顶部弹幕
+	DanmakuReverse = 6 // 逆向弹幕
+)
+
+// Danmaku 单条弹幕
+type Danmaku struct {
+	Id       int64
+	Progress time.Duration // 出现时间
+	Mode     int           // 弹幕模式
+	FontSize int           // 字号，25 为标准
+	Color    uint32        // 颜色 0xRRGGBB
+	MidHash  string        // 发送者 mid 的哈希
+	Content  string
+	Ctime    int64 // 发送时间戳
+	Pool     int   // 弹幕池：0 普通，1 字幕，2 特殊
+	Weight   int   // 屏蔽权重
+}
+
+// GetDanmaku 获取分 P 的全部弹幕，按出现时间排序
+// 优先使用分段 protobuf 接口（完整弹幕），失败时退回旧版 XML 接口（只有最近的一部分）
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 cid: 分 P 的 CID
+// 参数 duration: 分 P 时长，用于计算分段数量
+// 返回：弹幕列表和错误信息
+func (s *ApiService) GetDanmaku(ctx context.Context, cid int64, duration time.Duration) ([]Danmaku, error) {
+	list, err := s.getDanmakuSegments(ctx, cid, duration)
+	if err == nil {
+		return list, nil
+	}
+	if ctx.Err() != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Warn("Segmented danmaku failed, falling back to XML", "cid", cid, "error", err)
+	list, err = s.getDanmakuXml(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// getDanmakuSegments 按 6 分钟分段获取 protobuf 弹幕
+func (s *ApiService) getDanmakuSegments(ctx context.Context, cid int64, duration time.Duration) ([]Danmaku, error) {
+	segments := int((duration + danmakuSegment - 1) / danmakuSegment)
+	if segments < 1 {
+		segments = 1
+	}
+
+	var list []Danmaku
+	for i := 1; i <= segments; i++ {
+		apiUrl := fmt.Sprintf("%s%s?type=1&oid=%d&segment_index=%d", BaseURL, DanmakuSegEndpoint, cid, i)
+		data, err := s.getBytes(ctx, apiUrl, DanmakuSegEndpoint)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get danmaku segment %d: %w", i, err)
+		}
+		elems, err := decodeDanmakuSegment(data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode danmaku segment %d: %w", i, err)
+		}
+		list = append(list, elems...)
+	}
+
+	sortDanmaku(list)
+	return list, nil
+}
+
+// getDanmakuXml 获取旧版 XML 弹幕
+func (s *ApiService) getDanmakuXml(ctx context.Context, cid int64) ([]Danmaku, error) {
+	apiUrl := fmt.Sprintf("%s%s?oid=%d", BaseURL, DanmakuXmlEndpoint, cid)
+	data, err := s.getBytes(ctx, apiUrl, DanmakuXmlEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get XML danmaku: %w", err)
+	}
+
+	list, err := ParseDanmakuXml(data)
+	if err != nil {
+		return nil, err
+	}
+	sortDanmaku(list)
+	return list, nil
+}
+
+// getBytes 发送 GET 请求并返回响应体（不是 JSON 的接口），同时记录 API 调用指标
+// 服务端返回 deflate 压缩时自动解压
+func (s *ApiService) getBytes(ctx context.Context, apiUrl, endpoint string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+	s.setHeaders(req, "")
+
+	start := time.Now()
+	_, client := s.client.get()
+	resp, err := client.Do(req)
+	if err != nil {
+		metrics.ObserveApi(endpoint, 0, err, start)
+		return nil, fmt.Errorf("Request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "deflate") {
+		body = flate.NewReader(resp.Body)
+	}
+	data, err := io.ReadAll(body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+	// 不是 JSON 的接口没有 code 字段，成功记为 0，HTTP 状态码不作为标签
+	code := 0
+	if err != nil {
+		code = -1
+	}
+	metrics.ObserveApi(endpoint, code, err, start)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// decodeDanmakuSegment 解析 DmSegMobileReply：字段 1 为重复的 DanmakuElem
+func decodeDanmakuSegment(data []byte) ([]Danmaku, error) {
+	var list []Danmaku
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if num == 1 && typ == protowire.BytesType {
+			elem, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			d, err := decodeDanmakuElem(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, d)
+			data = data[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return list, nil
+}
+
+// decodeDanmakuElem 解析 DanmakuElem
+func decodeDanmakuElem(data []byte) (Danmaku, error) {
+	var d Danmaku
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return d, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return d, protowire.ParseError(n)
+			}
+			data = data[n:]
+			switch num {
+			case 1:
+				d.Id = int64(v)
+			case 2:
+				d.Progress = time.Duration(int32(v)) * time.Millisecond
+			case 3:
+				d.Mode = int(v)
+			case 4:
+				d.FontSize = int(v)
+			case 5:
+				d.Color = uint32(v)
+			case 8:
+				d.Ctime = int64(v)
+			case 9:
+				d.Weight = int(v)
+			case 11:
+				d.Pool = int(v)
+			}
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return d, protowire.ParseError(n)
+			}
+			data = data[n:]
+			switch num {
+			case 6:
+				d.MidHash = string(v)
+			case 7:
+				d.Content = string(v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return d, protowire.ParseError(n)
+			}
+			data = data[n:]
+		}
+	}
+	return d, nil
+}
+
+// danmakuXml Bilibili 标准 XML 弹幕文件
+type danmakuXml struct {
+	XMLName    xml.Name         `xml:"i"`
+	ChatServer string           `xml:"chatserver"`
+	ChatId     int64            `xml:"chatid"`
+	Mission    int              `xml:"mission"`
+	MaxLimit   int              `xml:"maxlimit"`
+	State      int              `xml:"state"`
+	RealName   int              `xml:"real_name"`
+	Source     string           `xml:"source"`
+	Items      []danmakuXmlItem `xml:"d"`
+}
+
+// danmakuXmlItem XML 中的单条弹幕
+// p 属性：出现时间(秒),模式,字号,颜色,发送时间,弹幕池,发送者哈希,弹幕 ID,权重
+type danmakuXmlItem struct {
+	P       string `xml:"p,attr"`
+	Content string `xml:",chardata"`
+}
+
+// ParseDanmakuXml 解析 Bilibili 标准 XML 弹幕
+// 参数 data: XML 内容
+// 返回：弹幕列表和错误信息
+func ParseDanmakuXml(data []byte) ([]Danmaku, error) {
+	var doc danmakuXml
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Failed to parse danmaku XML: %w", err)
+	}
+
+	list := make([]Danmaku, 0, len(doc.Items))
+	for _, item := range doc.Items {
+		f := strings.Split(item.P, ",")
+		if len(f) < 4 {
+			continue
+		}
+		sec, _ := strconv.ParseFloat(f[0], 64)
+		d := Danmaku{
+			Progress: time.Duration(sec * float64(time.Second)),
+			Content:  item.Content,
+		}
+		d.Mode, _ = strconv.Atoi(f[1])
+		d.FontSize, _ = strconv.Atoi(f[2])
+		color, _ := strconv.ParseUint(f[3], 10, 32)
+		d.Color = uint32(color)
+		if len(f) >= 9 {
+			d.Ctime, _ = strconv.ParseInt(f[4], 10, 64)
+			d.Pool, _ = strconv.Atoi(f[5])
+			d.MidHash = f[6]
+			d.Id, _ = strconv.ParseInt(f[7], 10, 64)
+			d.Weight, _ = strconv.Atoi(f[8])
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+// DanmakuToXml 把弹幕转换为 Bilibili 标准 XML 格式
+// 参数 cid: 分 P 的 CID
+// 参数 list: 弹幕列表
+// 返回：XML 内容
+func DanmakuToXml(cid int64, list []Danmaku) []byte {
+	doc := danmakuXml{
+		ChatServer: "chat.bilibili.com",
+		ChatId:     cid,
+		MaxLimit:   len(list),
+		Source:     "k-v",
+		Items:      make([]danmakuXmlItem, 0, len(list)),
+	}
+	for _, d := range list {
+		doc.Items = append(doc.Items, danmakuXmlItem{
+			P: fmt.Sprintf("%.5f,%d,%d,%d,%d,%d,%s,%d,%d",
+				d.Progress.Seconds(), d.Mode, d.FontSize, d.Color, d.Ctime, d.Pool, d.MidHash, d.Id, d.Weight),
+			Content: d.Content,
+		})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	enc.Encode(doc)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// sortDanmaku 按出现时间排序，时间相同时按 ID 排序
+func sortDanmaku(list []Danmaku) {
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Progress != list[j].Progress {
+			return list[i].Progress < list[j].Progress
+		}
+		return list[i].Id < list[j].Id
+	})
+}
+
+// DanmakuOptions 弹幕转换为 ASS 字幕的参数
+type DanmakuOptions struct {
+	Font           string        // 字体名称
+	FontSize       int           // 标准字号（25）弹幕在 1080p 画布上的像素大小
+	Lanes          int           // 滚动弹幕的轨道数，0 表示铺满整个画面
+	Density        float64       // 保留的弹幕比例（0~1）
+	Opacity        float64       // 弹幕不透明度（0~1）
+	ScrollDuration time.Duration // 滚动弹幕划过画面的时长
+	FixedDuration  time.Duration // 顶部、底部弹幕的显示时长
+}
+
+// Fingerprint 返回包含所有参数的标识，用于生成缓存键
+func (o DanmakuOptions) Fingerprint() string {
+	return fmt.Sprintf("%s|%d|%d|%g|%g|%s|%s", o.Font, o.FontSize, o.Lanes, o.Density, o.Opacity,
+		o.ScrollDuration, o.FixedDuration)
+}
+
+// 弹幕 ASS 画布大小
+const (
+	danmakuWidth  = 1920
+	danmakuHeight = 1080
+)
+
+// danmakuAssHeader 弹幕 ASS 文件头，参数依次为字体、字号和主颜色的透明度
+const danmakuAssHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 1920
+PlayResY: 1080
+WrapStyle: 2
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Danmaku,%s,%d,&H%02XFFFFFF,&H%02XFFFFFF,&H%02X000000,&H%02X000000,1,0,0,0,100,100,0,0,1,1.5,0,7,0,0,0,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+// danmakuLane 一条弹幕轨道上最后一条弹幕的位置信息
+type danmakuLane struct {
+	start time.Duration // 出现时间
+	width float64       // 文字宽度
+	used  bool
+}
+
+// DanmakuToAss 把弹幕转换为 ASS 字幕：滚动弹幕从右向左划过画面（逆向弹幕从左向右），
+// 顶部、底部弹幕居中固定显示。弹幕按轨道排布，没有空闲轨道时丢弃，避免互相重叠；
+// 高级弹幕、代码弹幕等无法用 ASS 表示的弹幕会被忽略
+// 参数 list: 按出现时间排序的弹幕列表
+// 参数 opts: 转换参数
+// 返回：ASS 内容
+func DanmakuToAss(list []Danmaku, opts DanmakuOptions) []byte {
+	laneHeight := float64(opts.FontSize) * 1.2
+	maxLanes := int(danmakuHeight / laneHeight)
+	scrollLanes := opts.Lanes
+	if scrollLanes <= 0 || scrollLanes > maxLanes {
+		scrollLanes = maxLanes
+	}
+	scroll := make([]danmakuLane, scrollLanes)
+	reverse := make([]danmakuLane, scrollLanes)
+	top := make([]danmakuLane, maxLanes)
+	bottom := make([]danmakuLane, maxLanes)
+
+	alpha := int((1-opts.Opacity)*255 + 0.5)
+	var b strings.Builder
+	fmt.Fprintf(&b, danmakuAssHeader, opts.Font, opts.FontSize, alpha, alpha, alpha, alpha)
+
+	// 按比例均匀抽样：累加保留比例，每满 1 保留一条
+	kept := 0.0
+	for _, d := range list {
+		if d.Pool == 2 || strings.TrimSpace(d.Content) == "" {
+			continue
+		}
+		kept += opts.Density
+		if kept < 1 {
+			continue
+		}
+		kept--
+
+		size := float64(opts.FontSize)
+		if d.FontSize > 0 {
+			size = size * float64(d.FontSize) / 25
+		}
+		width := danmakuTextWidth(d.Content, size)
+		text := assEscaper.Replace(d.Content)
+
+		var tags string
+		var end time.Duration
+		switch d.Mode {
+		case 1, 2, 3, DanmakuReverse:
+			lanes := scroll
+			if d.Mode == DanmakuReverse {
+				lanes = reverse
+			}
+			lane := scrollLane(lanes, d.Progress, width, opts.ScrollDuration)
+			if lane < 0 {
+				continue
+			}
+			y := float64(lane) * laneHeight
+			from, to := float64(danmakuWidth), -width
+			if d.Mode == DanmakuReverse {
+				from, to = to, from
+			}
+			tags = fmt.Sprintf("\\move(%.0f,%.0f,%.0f,%.0f)", from, y, to, y)
+			end = d.Progress + opts.ScrollDuration
+		case DanmakuTop, DanmakuBottom:
+			lanes := top
+			if d.Mode == DanmakuBottom {
+				lanes = bottom
+			}
+			lane := fixedLane(lanes, d.Progress, opts.FixedDuration)
+			if lane < 0 {
+				continue
+			}
+			if d.Mode == DanmakuTop {
+				tags = fmt.Sprintf("\\an8\\pos(%d,%.0f)", danmakuWidth/2, float64(lane)*laneHeight)
+			} else {
+				tags = fmt.Sprintf("\\an2\\pos(%d,%.0f)", danmakuWidth/2, danmakuHeight-float64(lane)*laneHeight)
+			}
+			end = d.Progress + opts.FixedDuration
+		default:
+			continue
+		}
+
+		if int(size) != opts.FontSize {
+			tags += fmt.Sprintf("\\fs%.0f", size)
+		}
+		color := d.Color & 0xFFFFFF
+		if color != 0xFFFFFF {
+			// ASS 颜色顺序为 BGR
+			tags += fmt.Sprintf("\\c&H%02X%02X%02X&", color&0xFF, color>>8&0xFF, color>>16)
+			if color == 0 {
+				// 黑色弹幕使用白色描边，保证可读
+				tags += "\\3c&HFFFFFF&"
+			}
+		}
+		fmt.Fprintf(&b, "Dialogue: 0,%s,%s,Danmaku,,0,0,0,,{%s}%s\n",
+			formatTimestamp(d.Progress, ".", 2, 1), formatTimestamp(end, ".", 2, 1), tags, text)
+	}
+	return []byte(b.String())
+}
+
+// scrollLane 为滚动弹幕选择轨道：前一条弹幕已经完全进入画面，
+// 并且新弹幕在前一条离开画面之前不会追上它
+// 返回：轨道序号，没有空闲轨道时返回 -1
+func scrollLane(lanes []danmakuLane, start time.Duration, width float64, duration time.Duration) int {
+	secs := duration.Seconds()
+	speed := (danmakuWidth + width) / secs
+	for i := range lanes {
+		l := &lanes[i]
+		if l.used {
+			prevSpeed := (danmakuWidth + l.width) / secs
+			elapsed := (start - l.start).Seconds()
+			// 前一条尾部还没进入画面
+			if elapsed*prevSpeed < l.width {
+				continue
+			}
+			// 新弹幕头部到达左边缘时，前一条还没离开画面
+			if elapsed+danmakuWidth/speed < secs {
+				continue
+			}
+		}
+		*l = danmakuLane{start: start, width: width, used: true}
+		return i
+	}
+	return -1
+}
+
+// fixedLane 为顶部、底部弹幕选择轨道：前一条弹幕已经消失
+// 返回：轨道序号，没有空闲轨道时返回 -1
+func fixedLane(lanes []danmakuLane, start time.Duration, duration time.Duration) int {
+	for i := range lanes {
+		l := &lanes[i]
+		if l.used && start-l.start < duration {
+			continue
+		}
+		*l = danmakuLane{start: start, used: true}
+		return i
+	}
+	return -1
+}
+
+// danmakuTextWidth 估算文字宽度：全角字符按一个字号计算，半角字符按半个字号计算
+func danmakuTextWidth(s string, size float64) float64 {
+	width := 0.0
+	for _, r := range s {
+		if r < 0x2E80 || (r >= 0xFF61 && r <= 0xFFDC) {
+			width += size / 2
+		} else {
+			width += size
+		}
+	}
+	return width
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// appendDanmakuElem 按 DanmakuElem 的字段编号编码一条弹幕，并附加一个未知字段
+func appendDanmakuElem(b []byte, d Danmaku) []byte {
+	var elem []byte
+	elem = protowire.AppendTag(elem, 1, protowire.VarintType)
+	elem = protowire.AppendVarint(elem, uint64(d.Id))
+	elem = protowire.AppendTag(elem, 2, protowire.VarintType)
+	// int32 字段的负数按 64 位补码编码
+	elem = protowire.AppendVarint(elem, uint64(int64(d.Progress/time.Millisecond)))
+	elem = protowire.AppendTag(elem, 3, protowire.VarintType)
+	elem = protowire.AppendVarint(elem, uint64(d.Mode))
+	elem = protowire.AppendTag(elem, 4, protowire.VarintType)
+	elem = protowire.AppendVarint(elem, uint64(d.FontSize))
+	elem = protowire.AppendTag(elem, 5, protowire.VarintType)
+	elem = protowire.AppendVarint(elem, uint64(d.Color))
+	elem = protowire.AppendTag(elem, 6, protowire.BytesType)
+	elem = protowire.AppendString(elem, d.MidHash)
+	elem = protowire.AppendTag(elem, 7, protowire.BytesType)
+	elem = protowire.AppendString(elem, d.Content)
+	elem = protowire.AppendTag(elem, 8, protowire.VarintType)
+	elem = protowire.AppendVarint(elem, uint64(d.Ctime))
+	elem = protowire.AppendTag(elem, 9, protowire.VarintType)
+	elem = protowire.AppendVarint(elem, uint64(d.Weight))
+	elem = protowire.AppendTag(elem, 10, protowire.BytesType) // action
+	elem = protowire.AppendString(elem, "picture:example")
+	elem = protowire.AppendTag(elem, 11, protowire.VarintType)
+	elem = protowire.AppendVarint(elem, uint64(d.Pool))
+	elem = protowire.AppendTag(elem, 15, protowire.Fixed32Type) // 未知字段
+	elem = protowire.AppendFixed32(elem, 7)
+
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, elem)
+}
+
+func TestDecodeDanmakuSegment(t *testing.T) {
+	want := []Danmaku{
+		{Id: 1, Progress: 1500 * time.Millisecond, Mode: DanmakuScroll, FontSize: 25, Color: 0xFFFFFF, MidHash: "abcd1234", Content: "第一条", Ctime: 1700000000, Weight: 3},
+		{Id: 2, Progress: -200 * time.Millisecond, Mode: DanmakuTop, FontSize: 18, Color: 0xFF0000, MidHash: "ef567890", Content: "negative", Ctime: 1700000001, Pool: 1, Weight: 10},
+	}
+	var data []byte
+	data = appendDanmakuElem(data, want[0])
+	// DmSegMobileReply 中的其他字段（state、ai_flag 等）跳过
+	data = protowire.AppendTag(data, 2, protowire.VarintType)
+	data = protowire.AppendVarint(data, 1)
+	data = appendDanmakuElem(data, want[1])
+
+	got, err := decodeDanmakuSegment(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeDanmakuSegment =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if list, err := decodeDanmakuSegment(nil); err != nil || len(list) != 0 {
+		t.Errorf("empty segment = %v, %v", list, err)
+	}
+}
+
+func TestDecodeDanmakuSegmentErrors(t *testing.T) {
+	valid := appendDanmakuElem(nil, Danmaku{Id: 1, Content: "text"})
+	var badElem []byte
+	badElem = protowire.AppendTag(badElem, 1, protowire.BytesType)
+	badElem = protowire.AppendBytes(badElem, []byte{0x38, 0x05, 'a'}) // 字段 7 的长度超出范围
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated element", valid[:len(valid)-1]},
+		{"truncated tag", []byte{0x80}},
+		{"truncated varint field", []byte{0x10, 0x80}},
+		{"invalid element", badElem},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if list, err := decodeDanmakuSegment(tt.data); err == nil {
+				t.Errorf("decodeDanmakuSegment succeeded: %+v", list)
+			}
+		})
+	}
+}
+
+func TestDanmakuXmlRoundTrip(t *testing.T) {
+	list := []Danmaku{
+		{Id: 10, Progress: 1500 * time.Millisecond, Mode: DanmakuScroll, FontSize: 25, Color: 0xFFFFFF, MidHash: "abcd", Content: "<弹幕> & 'text'", Ctime: 1700000000, Weight: 2},
+		{Id: 11, Progress: 62 * time.Second, Mode: DanmakuBottom, FontSize: 18, Color: 0x00FF00, MidHash: "ef01", Content: "bottom", Ctime: 1700000001, Pool: 1},
+	}
+	got, err := ParseDanmakuXml(DanmakuToXml(1234, list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, list) {
+		t.Errorf("round trip =\n%+v\nwant\n%+v", got, list)
+	}
+
+	// 旧版接口只有前 4 个属性；属性不足的弹幕跳过
+	old := `<i><d p="3.5,5,25,16711680">old</d><d p="1,1">broken</d></i>`
+	got, err = ParseDanmakuXml([]byte(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Danmaku{{Progress: 3500 * time.Millisecond, Mode: DanmakuTop, FontSize: 25, Color: 0xFF0000, Content: "old"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseDanmakuXml(old) = %+v, want %+v", got, want)
+	}
+
+	if _, err := ParseDanmakuXml([]byte("<i><d>")); err == nil {
+		t.Error("invalid XML should fail")
+	}
+}
+
+func TestDanmakuToAss(t *testing.T) {
+	// 字号 50 时每条轨道高 60 像素，"abc" 宽 75 像素
+	opts := DanmakuOptions{
+		Font:           "Sans",
+		FontSize:       50,
+		Density:        1,
+		Opacity:        1,
+		ScrollDuration: 8 * time.Second,
+		FixedDuration:  4 * time.Second,
+	}
+	white := func(sec float64, mode int, content string) Danmaku {
+		return Danmaku{Progress: time.Duration(sec * float64(time.Second)), Mode: mode, Color: 0xFFFFFF, Content: content}
+	}
+
+	tests := []struct {
+		name   string
+		list   []Danmaku
+		modify func(*DanmakuOptions)
+		want   []string
+	}{
+		{
+			name: "simultaneous scroll uses separate lanes",
+			list: []Danmaku{white(1, DanmakuScroll, "abc"), white(1, 2, "def")},
+			want: []string{
+				`0:00:01.00,0:00:09.00,{\move(1920,0,-75,0)}abc`,
+				`0:00:01.00,0:00:09.00,{\move(1920,60,-75,60)}def`,
+			},
+		},
+		{
+			// 前一条尾部还没有进入画面时换轨道，完全进入并且不会被追上时复用
+			name: "scroll lane reuse",
+			list: []Danmaku{white(0, DanmakuScroll, "abc"), white(0.2, DanmakuScroll, "abc"), white(1, DanmakuScroll, "abc")},
+			want: []string{
+				`0:00:00.00,0:00:08.00,{\move(1920,0,-75,0)}abc`,
+				`0:00:00.20,0:00:08.20,{\move(1920,60,-75,60)}abc`,
+				`0:00:01.00,0:00:09.00,{\move(1920,0,-75,0)}abc`,
+			},
+		},
+		{
+			// 较短的弹幕速度较慢，较长的弹幕在画面中会追上它，不能使用同一条轨道
+			name: "faster danmaku does not overtake",
+			list: []Danmaku{white(0, DanmakuScroll, "a"), white(1, DanmakuScroll, strings.Repeat("长", 20))},
+			want: []string{
+				`0:00:00.00,0:00:08.00,{\move(1920,0,-25,0)}a`,
+				`0:00:01.00,0:00:09.00,{\move(1920,60,-1000,60)}` + strings.Repeat("长", 20),
+			},
+		},
+		{
+			name: "reverse",
+			list: []Danmaku{white(0, DanmakuReverse, "abc")},
+			want: []string{`0:00:00.00,0:00:08.00,{\move(-75,0,1920,0)}abc`},
+		},
+		{
+			name: "top and bottom",
+			list: []Danmaku{
+				white(0, DanmakuTop, "t1"), white(1, DanmakuTop, "t2"), white(4, DanmakuTop, "t3"),
+				white(0, DanmakuBottom, "b1"), white(2, DanmakuBottom, "b2"),
+			},
+			want: []string{
+				`0:00:00.00,0:00:04.00,{\an8\pos(960,0)}t1`,
+				`0:00:01.00,0:00:05.00,{\an8\pos(960,60)}t2`,
+				`0:00:04.00,0:00:08.00,{\an8\pos(960,0)}t3`,
+				`0:00:00.00,0:00:04.00,{\an2\pos(960,1080)}b1`,
+				`0:00:02.00,0:00:06.00,{\an2\pos(960,1020)}b2`,
+			},
+		},
+		{
+			name:   "lane limit drops danmaku",
+			list:   []Danmaku{white(0, DanmakuScroll, "abc"), white(0, DanmakuScroll, "def")},
+			modify: func(o *DanmakuOptions) { o.Lanes = 1 },
+			want:   []string{`0:00:00.00,0:00:08.00,{\move(1920,0,-75,0)}abc`},
+		},
+		{
+			name:   "density",
+			list:   []Danmaku{white(0, DanmakuTop, "1"), white(5, DanmakuTop, "2"), white(10, DanmakuTop, "3"), white(15, DanmakuTop, "4")},
+			modify: func(o *DanmakuOptions) { o.Density = 0.5 },
+			want: []string{
+				`0:00:05.00,0:00:09.00,{\an8\pos(960,0)}2`,
+				`0:00:15.00,0:00:19.00,{\an8\pos(960,0)}4`,
+			},
+		},
+		{
+			name: "unsupported danmaku are skipped",
+			list: []Danmaku{
+				{Mode: 7, Content: "advanced", Color: 0xFFFFFF},
+				{Mode: 8, Content: "code", Color: 0xFFFFFF},
+				{Mode: DanmakuScroll, Pool: 2, Content: "special", Color: 0xFFFFFF},
+				{Mode: DanmakuScroll, Content: "  ", Color: 0xFFFFFF},
+			},
+			want: nil,
+		},
+		{
+			name: "size, color and escaping",
+			list: []Danmaku{
+				{Mode: DanmakuTop, FontSize: 18, Color: 0xFF8000, Content: "{x}\\n"},
+				{Progress: 5 * time.Second, Mode: DanmakuTop, Color: 0, Content: "black"},
+			},
+			want: []string{
+				`0:00:00.00,0:00:04.00,{\an8\pos(960,0)\fs36\c&H0080FF&}\{x\}\\n`,
+				`0:00:05.00,0:00:09.00,{\an8\pos(960,0)\c&H000000&\3c&HFFFFFF&}black`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := opts
+			if tt.modify != nil {
+				tt.modify(&o)
+			}
+			var got []string
+			for _, line := range strings.Split(string(DanmakuToAss(tt.list, o)), "\n") {
+				if rest, ok := strings.CutPrefix(line, "Dialogue: 0,"); ok {
+					got = append(got, strings.Replace(rest, ",Danmaku,,0,0,0,,", ",", 1))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dialogues =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestDanmakuAssHeader(t *testing.T) {
+	ass := string(DanmakuToAss(nil, DanmakuOptions{Font: "Noto Sans", FontSize: 40, Opacity: 0.5, Density: 1}))
+	// 不透明度 0.5 对应 ASS 透明度 0x80
+	want := "Style: Danmaku,Noto Sans,40,&H80FFFFFF,&H80FFFFFF,&H80000000,&H80000000,"
+	if !strings.Contains(ass, want) {
+		t.Errorf("header does not contain %q:\n%s", want, ass)
+	}
+}
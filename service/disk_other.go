@@ -0,0 +1,12 @@
+//go:build !unix
+
+package service
+
+import "errors"
+
+// FreeDiskSpace 获取路径所在文件系统的剩余空间（当前平台不支持）
+// 参数 path: 文件系统中的任意路径
+// 返回：剩余字节数和错误信息
+func FreeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("Free disk space check is not supported on this platform")
+}
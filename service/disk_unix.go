@@ -0,0 +1,19 @@
+//go:build unix
+
+package service
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// FreeDiskSpace 获取路径所在文件系统对非特权用户可用的剩余空间
+// 参数 path: 文件系统中的任意路径
+// 返回：剩余字节数和错误信息
+func FreeDiskSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("Failed to stat filesystem: %w", err)
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -1,59 +1,225 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/metrics"
 )
 
 // Downloader 视频下载器
 type Downloader struct {
-	httpClient *http.Client
-	cookie     string
-	referer    string
+	client       clientState
+	referer      string
+	tempDir      string
+	tempDirMu    sync.RWMutex
+	mergeLimiter *Limiter
+
+	// 转码使用独立的并发限制，避免耗时的转码占满合并名额
+	transcodeLimiter *Limiter
+	cache            *Cache
+
+	// 关闭服务时用于终止所有下载和 FFmpeg 进程
+	baseCtx context.Context
+	abort   context.CancelFunc
+
+	// 正在使用的临时工作目录
+	workDirs   map[string]struct{}
+	workDirsMu sync.Mutex
+
+	// 磁盘空间保护和残留目录清理参数
+	minFreeDisk     atomic.Uint64
+	staleAfter      atomic.Int64
+	janitorInterval atomic.Int64
+}
+
+// DownloaderOptions 下载器配置
+type DownloaderOptions struct {
+	Client          ClientOptions // HTTP 客户端配置，Timeout 为单个文件的下载超时
+	TempDir         string        // 工作根目录，为空时使用系统临时目录下的 bilibili-downloader
+	MaxMerges       int           // 同时运行的 FFmpeg 合并进程上限，小于等于 0 表示不限制
+	MaxTranscodes   int           // 同时运行的 FFmpeg 转码进程上限，小于等于 0 表示不限制
+	CacheDir        string        // 转码结果缓存目录，为空时不缓存
+	CacheTTL        time.Duration // 缓存文件超过该时长未被访问时删除，小于等于 0 表示不过期
+	MinFreeDisk     uint64        // 工作根目录最小剩余空间（字节），低于时拒绝新的下载，0 表示不限制
+	StaleAfter      time.Duration // 工作目录超过该时长未修改视为残留
+	JanitorInterval time.Duration // 残留工作目录的清理间隔
+}
+
+// defaultWorkRoot 未配置工作根目录时使用的目录名（位于系统临时目录下）
+const defaultWorkRoot = "bilibili-downloader"
+
+// mergeInputs FFmpeg 合并使用的本地输入文件
+type mergeInputs struct {
+	video     string           // 视频文件
+	audio     string           // 音频文件
+	concat    bool             // video 是 durl 分段的 concat 列表，音视频都从中读取，audio 为空
+	offsets   [2]time.Duration // 截取片段时视频、音频文件中第一个分段的开始时间
+	cover     string           // 封面图片，为空时不嵌入封面
+	chapters  string           // FFmetadata 章节文件，为空时不写入章节
+	subtitles []subtitleInput  // 内嵌字幕
+}
+
+// subtitleInput 内嵌字幕的本地文件
+type subtitleInput struct {
+	path     string
+	language string
+	title    string
+	ass      bool // ASS 字幕，原样复制
 }
 
 // DownloadResult 下载结果
 type DownloadResult struct {
-	VideoPath string // 视频文件本地路径
-	AudioPath string // 音频文件本地路径
-	Err       error  // 错误信息
+	VideoPath string        // 视频文件本地路径
+	AudioPath string        // 音频文件本地路径
+	Offset    time.Duration // 截取片段时文件中第一个分段的开始时间
+	Err       error         // 错误信息
 }
 
 // NewDownloader 创建下载器实例
-// 参数 cookie: 用户 Cookie，用于身份验证
+// 参数 opts: 下载器配置
 // 返回：配置好的 Downloader 实例
-func NewDownloader(cookie string) *Downloader {
-	return &Downloader{
-		httpClient: &http.Client{
-			Timeout: 300 * time.Second, // 下载超时时间设置为 5 分钟
-		},
-		cookie: cookie,
+func NewDownloader(opts DownloaderOptions) *Downloader {
+	d := &Downloader{
+		mergeLimiter:     NewLimiter(opts.MaxMerges),
+		transcodeLimiter: NewLimiter(opts.MaxTranscodes),
+		cache:            NewCache(opts.CacheDir, opts.CacheTTL),
+		workDirs:         make(map[string]struct{}),
 	}
+	d.baseCtx, d.abort = context.WithCancel(context.Background())
+	d.UpdateOptions(opts)
+	return d
+}
+
+// UpdateOptions 热更新下载器配置，进行中的下载和合并不受影响
+// 参数 opts: 新的下载器配置
+func (d *Downloader) UpdateOptions(opts DownloaderOptions) {
+	d.client.update(opts.Client)
+	d.mergeLimiter.SetLimit(opts.MaxMerges)
+	d.transcodeLimiter.SetLimit(opts.MaxTranscodes)
+	d.cache.Update(opts.CacheDir, opts.CacheTTL)
+
+	d.minFreeDisk.Store(opts.MinFreeDisk)
+	d.staleAfter.Store(int64(opts.StaleAfter))
+	d.janitorInterval.Store(int64(opts.JanitorInterval))
+
+	d.tempDirMu.Lock()
+	d.tempDir = opts.TempDir
+	d.tempDirMu.Unlock()
+}
+
+// TempDir 返回当前使用的工作根目录
+func (d *Downloader) TempDir() string {
+	d.tempDirMu.RLock()
+	defer d.tempDirMu.RUnlock()
+	if d.tempDir == "" {
+		return filepath.Join(os.TempDir(), defaultWorkRoot)
+	}
+	return d.tempDir
+}
+
+// Abort 终止所有进行中的下载和 FFmpeg 进程，并删除所有临时工作目录
+// 用于服务关闭时等待超时后的强制清理，调用后 Downloader 不能再使用
+func (d *Downloader) Abort() {
+	d.abort()
+
+	d.workDirsMu.Lock()
+	dirs := make([]string, 0, len(d.workDirs))
+	for dir := range d.workDirs {
+		dirs = append(dirs, dir)
+	}
+	d.workDirsMu.Unlock()
+
+	for _, dir := range dirs {
+		d.removeWorkDir(dir)
+	}
+}
+
+// newWorkDir 在工作根目录下创建临时工作目录并登记，便于关闭时统一清理
+func (d *Downloader) newWorkDir() (string, error) {
+	root := d.TempDir()
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(root, workDirPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+
+	d.workDirsMu.Lock()
+	d.workDirs[dir] = struct{}{}
+	d.workDirsMu.Unlock()
+	return dir, nil
+}
+
+// removeWorkDir 删除临时工作目录并取消登记
+func (d *Downloader) removeWorkDir(dir string) {
+	os.RemoveAll(dir)
+
+	d.workDirsMu.Lock()
+	delete(d.workDirs, dir)
+	d.workDirsMu.Unlock()
+}
+
+// MergeStats 返回当前执行中和排队中的 FFmpeg 合并数量
+func (d *Downloader) MergeStats() (active, waiting int) {
+	return d.mergeLimiter.Stats()
+}
+
+// TranscodeStats 返回当前执行中和排队中的 FFmpeg 转码数量
+func (d *Downloader) TranscodeStats() (active, waiting int) {
+	return d.transcodeLimiter.Stats()
+}
+
+// OpenCached 打开缓存中的输出文件
+// 参数 key: 缓存键，与 MergeOptions.CacheKey 一致
+// 返回：缓存文件和是否命中，未配置缓存目录时总是未命中
+func (d *Downloader) OpenCached(key string) (io.ReadCloser, bool) {
+	if !d.cache.Enabled() {
+		return nil, false
+	}
+	file, err := d.cache.Open(key)
+	if err != nil {
+		metrics.CacheRequestsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	metrics.CacheRequestsTotal.WithLabelValues("hit").Inc()
+	return file, true
 }
 
 // DownloadFile 下载单个文件
+// 参数 ctx: 上下文，取消时中断下载
 // 参数 url: 下载地址
 // 参数 referer: Referer 头
 // 参数 filename: 保存的文件名
 // 返回：错误信息
-func (d *Downloader) DownloadFile(url, referer, filename string) error {
+func (d *Downloader) DownloadFile(ctx context.Context, url, referer, filename string) error {
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return fmt.Errorf("Failed to create request: %w", err)
 	}
+	req = EnsureContext(ctx, req)
 
 	// 设置请求头
 	d.setDownloadHeaders(req, referer)
 
 	// 发送请求
-	resp, err := d.httpClient.Do(req)
+	start := time.Now()
+	_, client := d.client.get()
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("Request failed: %w", err)
 	}
@@ -72,11 +238,18 @@ func (d *Downloader) DownloadFile(url, referer, filename string) error {
 	defer file.Close()
 
 	// 写入文件
-	_, err = io.Copy(file, resp.Body)
+	n, err := io.Copy(file, resp.Body)
+	metrics.CdnBytesTotal.Add(float64(n))
 	if err != nil {
 		return fmt.Errorf("Failed to write file: %w", err)
 	}
 
+	logging.FromContext(ctx).Debug("CDN download finished",
+		"file", filepath.Base(filename),
+		"bytes", n,
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+
 	return nil
 }
 
@@ -84,8 +257,10 @@ func (d *Downloader) DownloadFile(url, referer, filename string) error {
 // 参数 req: HTTP 请求
 // 参数 referer: Referer 头
 func (d *Downloader) setDownloadHeaders(req *http.Request, referer string) {
+	opts, _ := d.client.get()
+
 	// 设置 User-Agent
-	req.Header.Set("User-Agent", DefaultUserAgent)
+	req.Header.Set("User-Agent", opts.UserAgent)
 
 	// 设置 Referer
 	if referer != "" {
@@ -97,8 +272,8 @@ func (d *Downloader) setDownloadHeaders(req *http.Request, referer string) {
 	}
 
 	// 设置 Cookie
-	if d.cookie != "" {
-		req.Header.Set("Cookie", d.cookie)
+	if opts.Cookie != "" {
+		req.Header.Set("Cookie", opts.Cookie)
 	}
 
 	// 设置 Accept-Encoding 为 identity，避免压缩
@@ -106,26 +281,63 @@ func (d *Downloader) setDownloadHeaders(req *http.Request, referer string) {
 }
 
 // DownloadAndMerge 并发下载音视频并合并
+// 参数 ctx: 上下文，取消时中断下载并放弃排队
 // 参数 videoUrl: 视频下载地址
 // 参数 audioUrl: 音频下载地址
 // 参数 bvid: 视频 BV 号，用于生成 Referer
+// 参数 opts: 输出选项（容器格式、转码配置档、元数据、字幕、缓存键）
 // 返回：合并后的视频流和错误信息
-func (d *Downloader) DownloadAndMerge(videoUrl, audioUrl, bvid string) (io.ReadCloser, error) {
+func (d *Downloader) DownloadAndMerge(ctx context.Context, videoUrl, audioUrl, bvid string, opts MergeOptions) (io.ReadCloser, error) {
+	return d.downloadAndMerge(ctx, mediaSource{videoUrl: videoUrl, audioUrl: audioUrl}, bvid, opts)
+}
+
+// DownloadAndConcat 下载 durl 分段并拼接，用于没有 DASH 格式的视频
+// 参数 ctx: 上下文，取消时中断下载并放弃排队
+// 参数 segments: 按顺序排列的分段，每段包含音视频
+// 参数 bvid: 视频 BV 号，用于生成 Referer
+// 参数 opts: 输出选项，和 DownloadAndMerge 相同
+// 返回：拼接后的视频流和错误信息
+func (d *Downloader) DownloadAndConcat(ctx context.Context, segments []Segment, bvid string, opts MergeOptions) (io.ReadCloser, error) {
+	return d.downloadAndMerge(ctx, mediaSource{segments: segments}, bvid, opts)
+}
+
+// mediaSource 下载来源：DASH 的音视频轨道，或者 durl 的音视频分段
+type mediaSource struct {
+	videoUrl string
+	audioUrl string
+	segments []Segment
+}
+
+// downloadAndMerge 下载来源中的音视频，再交给 FFmpeg 合并或拼接
+func (d *Downloader) downloadAndMerge(ctx context.Context, src mediaSource, bvid string, opts MergeOptions) (io.ReadCloser, error) {
+	// 服务关闭时（Abort）同样取消下载和合并
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(d.baseCtx, cancel)
+	defer stop()
+
+	// 剩余空间不足时拒绝下载
+	if err := d.checkDiskSpace(ctx); err != nil {
+		return nil, err
+	}
+
 	// 创建临时目录
-	tempDir, err := os.MkdirTemp("", "bilibili_downloader_*")
+	tempDir, err := d.newWorkDir()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create temporary directory: %w", err)
 	}
-	defer func() {
-		// 延迟清理临时目录（在函数返回前）
-		// 注意：这里不能立即删除，因为需要等合并完成
-	}()
 
 	// 生成唯一文件名
 	timestamp := time.Now().UnixNano()
 	videoPath := filepath.Join(tempDir, fmt.Sprintf("video_%d.mp4", timestamp))
 	audioPath := filepath.Join(tempDir, fmt.Sprintf("audio_%d.m4a", timestamp))
-	outputPath := filepath.Join(tempDir, fmt.Sprintf("output_%d.mp4", timestamp))
+	outputPath := filepath.Join(tempDir, fmt.Sprintf("output_%d.%s", timestamp, opts.Format.Extension))
+	segmentDir := ""
+	if src.segments != nil {
+		// durl 分段保存在单独的目录，视频路径指向 concat 列表文件
+		segmentDir = filepath.Join(tempDir, fmt.Sprintf("segments_%d", timestamp))
+		videoPath = filepath.Join(tempDir, fmt.Sprintf("segments_%d.ffconcat", timestamp))
+	}
 
 	// 设置 Referer
 	referer := fmt.Sprintf("%s/video/%s/", VideoURL, bvid)
@@ -134,27 +346,55 @@ func (d *Downloader) DownloadAndMerge(videoUrl, audioUrl, bvid string) (io.ReadC
 	resultChan := make(chan *DownloadResult, 2)
 	var wg sync.WaitGroup
 
-	// 并发下载视频
+	// 截取片段时只下载覆盖时间范围的分段
+	var videoIndex, audioIndex SegmentBase
+	if opts.Clip != nil {
+		videoIndex, audioIndex = opts.Clip.VideoIndex, opts.Clip.AudioIndex
+	}
+
+	// 并发下载视频，durl 分段同时包含音频
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		err := d.DownloadFile(videoUrl, referer, videoPath)
+		var offset time.Duration
+		var err error
+		if src.segments != nil {
+			offset, err = d.downloadSegments(ctx, src.segments, referer, segmentDir, videoPath, opts.Clip)
+		} else {
+			offset, err = d.downloadTrack(ctx, src.videoUrl, referer, videoPath, videoIndex, opts.Clip)
+		}
 		resultChan <- &DownloadResult{
 			VideoPath: videoPath,
+			Offset:    offset,
 			Err:       err,
 		}
 	}()
 
 	// 并发下载音频
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		err := d.DownloadFile(audioUrl, referer, audioPath)
-		resultChan <- &DownloadResult{
-			AudioPath: audioPath,
-			Err:       err,
-		}
-	}()
+	if src.segments == nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			offset, err := d.downloadTrack(ctx, src.audioUrl, referer, audioPath, audioIndex, opts.Clip)
+			resultChan <- &DownloadResult{
+				AudioPath: audioPath,
+				Offset:    offset,
+				Err:       err,
+			}
+		}()
+	}
+
+	// 并发下载封面，容器或图片格式不支持时跳过
+	var coverPath string
+	var coverErr error
+	if m := opts.Metadata; m != nil && opts.Format.CoverArt && coverExt(m.CoverUrl) != "" {
+		coverPath = filepath.Join(tempDir, fmt.Sprintf("cover_%d%s", timestamp, coverExt(m.CoverUrl)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			coverErr = d.DownloadFile(ctx, m.CoverUrl, referer, coverPath)
+		}()
+	}
 
 	// 等待所有下载完成
 	go func() {
@@ -164,61 +404,133 @@ func (d *Downloader) DownloadAndMerge(videoUrl, audioUrl, bvid string) (io.ReadC
 
 	// 收集下载结果
 	var videoErr, audioErr error
+	var offsets [2]time.Duration
 	for result := range resultChan {
-		if result.Err != nil {
-			if result.VideoPath != "" {
-				videoErr = result.Err
-			} else {
-				audioErr = result.Err
-			}
+		if result.VideoPath != "" {
+			videoErr, offsets[0] = result.Err, result.Offset
+		} else {
+			audioErr, offsets[1] = result.Err, result.Offset
 		}
 	}
 
 	// 检查下载错误
 	if videoErr != nil {
 		// 清理临时文件
-		cleanupFiles(tempDir, videoPath, audioPath, outputPath)
+		d.removeWorkDir(tempDir)
 		return nil, fmt.Errorf("Video download failed: %w", videoErr)
 	}
 	if audioErr != nil {
 		// 清理临时文件
-		cleanupFiles(tempDir, videoPath, audioPath, outputPath)
+		d.removeWorkDir(tempDir)
 		return nil, fmt.Errorf("Audio download failed: %w", audioErr)
 	}
 
-	// 使用 FFmpeg 合并
-	err = d.mergeWithFfmpeg(videoPath, audioPath, outputPath)
+	// 封面和章节只是附加信息，准备失败时跳过并继续合并
+	logger := logging.FromContext(ctx).With("bvid", bvid)
+	inputs := mergeInputs{video: videoPath, audio: audioPath, offsets: offsets}
+	if src.segments != nil {
+		inputs.audio, inputs.concat = "", true
+	}
+	if coverPath != "" {
+		if coverErr != nil {
+			logger.Warn("Cover download failed, skipping cover art", "error", coverErr)
+		} else {
+			inputs.cover = coverPath
+		}
+	}
+	if m := opts.Metadata; m != nil && len(m.Chapters) > 0 && opts.Clip == nil {
+		chaptersPath := filepath.Join(tempDir, fmt.Sprintf("chapters_%d.txt", timestamp))
+		if err := writeChapters(chaptersPath, m.Chapters); err != nil {
+			logger.Warn("Failed to write chapters, skipping", "error", err)
+		} else {
+			inputs.chapters = chaptersPath
+		}
+	}
+	if opts.Format.SubtitleCodec != "" {
+		for i, sub := range opts.Subtitles {
+			ext := "srt"
+			if sub.Ass {
+				if !opts.Format.AssSubtitles {
+					logger.Warn("Format does not support ASS subtitles, skipping", "format", opts.Format.Name, "title", sub.Title)
+					continue
+				}
+				ext = "ass"
+			}
+			subPath := filepath.Join(tempDir, fmt.Sprintf("subtitle_%d_%d.%s", timestamp, i, ext))
+			if err := os.WriteFile(subPath, sub.Data, 0o644); err != nil {
+				logger.Warn("Failed to write subtitle, skipping", "language", sub.Language, "error", err)
+				continue
+			}
+			inputs.subtitles = append(inputs.subtitles, subtitleInput{path: subPath, language: sub.Language, title: sub.Title, ass: sub.Ass})
+		}
+	}
+
+	// 使用 FFmpeg 合并，直接复制流受合并并发数限制，转码受转码并发数限制
+	limiter := d.mergeLimiter
+	if opts.Profile != nil {
+		limiter = d.transcodeLimiter
+	}
+	release, err := limiter.Acquire(ctx, nil)
+	if err != nil {
+		d.removeWorkDir(tempDir)
+		return nil, fmt.Errorf("Waiting for FFmpeg slot cancelled: %w", err)
+	}
+	mergeStart := time.Now()
+	err = d.mergeWithFfmpeg(ctx, inputs, outputPath, opts)
+	release()
+	logger = logger.With("duration_ms", time.Since(mergeStart).Milliseconds())
+	if opts.Profile != nil {
+		metrics.TranscodeDuration.WithLabelValues(opts.Profile.Name, metrics.Result(err)).Observe(time.Since(mergeStart).Seconds())
+		logger = logger.With("profile", opts.Profile.Name)
+	} else {
+		metrics.FfmpegDuration.WithLabelValues(metrics.Result(err)).Observe(time.Since(mergeStart).Seconds())
+	}
 	if err != nil {
-		cleanupFiles(tempDir, videoPath, audioPath, outputPath)
+		logger.Error("FFmpeg merge failed", "error", err)
+		d.removeWorkDir(tempDir)
 		return nil, fmt.Errorf("FFmpeg merge failed: %w", err)
 	}
 
-	// 清理音视频临时文件，保留输出文件
-	cleanupFiles("", videoPath, audioPath)
+	logger.Info("FFmpeg merge finished")
+
+	// 存入缓存，失败时不影响本次响应
+	if opts.CacheKey != "" {
+		if err := d.cache.Store(opts.CacheKey, outputPath); err != nil {
+			logger.Warn("Failed to store output in cache", "error", err)
+		}
+	}
+
+	// 清理输入临时文件，保留输出文件
+	cleanupFiles(segmentDir, inputs.video, inputs.audio, coverPath, inputs.chapters)
+	for _, sub := range inputs.subtitles {
+		cleanupFiles("", sub.path)
+	}
 
 	// 打开合并后的文件
 	file, err := os.Open(outputPath)
 	if err != nil {
 		// 清理临时目录
-		os.RemoveAll(tempDir)
+		d.removeWorkDir(tempDir)
 		return nil, fmt.Errorf("Failed to open output file: %w", err)
 	}
 
 	// 返回文件读取器，并在关闭时清理临时文件
 	return &cleanupReadCloser{
-		File:     file,
-		tempDir:  tempDir,
-		filePath: outputPath,
+		File:       file,
+		tempDir:    tempDir,
+		filePath:   outputPath,
+		downloader: d,
 	}, nil
 }
 
 // cleanupReadCloser 包装 os.File，在关闭时清理临时文件
 type cleanupReadCloser struct {
 	*os.File
-	tempDir  string
-	filePath string
-	closed   bool
-	once     sync.Once
+	tempDir    string
+	filePath   string
+	downloader *Downloader
+	closed     bool
+	once       sync.Once
 }
 
 // Close 关闭文件并清理临时文件
@@ -230,17 +542,18 @@ func (c *cleanupReadCloser) Close() error {
 		err = c.File.Close()
 		// 清理临时文件和目录
 		os.Remove(c.filePath)
-		os.RemoveAll(c.tempDir)
+		c.downloader.removeWorkDir(c.tempDir)
 	})
 	return err
 }
 
-// mergeWithFfmpeg 调用 FFmpeg 合并音视频
-// 参数 videoPath: 视频文件路径
-// 参数 audioPath: 音频文件路径
+// mergeWithFfmpeg 调用 FFmpeg 合并音视频，同时写入元数据、封面、字幕和章节
+// 参数 ctx: 上下文，取消时终止 FFmpeg 进程
+// 参数 inputs: 本地输入文件
 // 参数 outputPath: 输出文件路径
+// 参数 opts: 输出选项，决定编码和容器相关的 FFmpeg 参数
 // 返回：错误信息
-func (d *Downloader) mergeWithFfmpeg(videoPath, audioPath, outputPath string) error {
+func (d *Downloader) mergeWithFfmpeg(ctx context.Context, inputs mergeInputs, outputPath string, opts MergeOptions) error {
 	// 检查 FFmpeg 是否安装
 	ffmpegPath, err := exec.LookPath("ffmpeg")
 	if err != nil {
@@ -248,14 +561,73 @@ func (d *Downloader) mergeWithFfmpeg(videoPath, audioPath, outputPath string) er
 	}
 
 	// 构建 FFmpeg 命令
-	// ffmpeg -y -i video.mp4 -i audio.m4a -c copy output.mp4
-	cmd := exec.Command(ffmpegPath,
-		"-y",           // 覆盖输出文件
-		"-i", videoPath, // 输入视频
-		"-i", audioPath, // 输入音频
-		"-c", "copy",   // 直接复制流，不重新编码
-		outputPath,     // 输出文件
-	)
+	// ffmpeg -y [截取参数] -i video.m4s [截取参数] -i audio.m4s [-i cover.jpg] [[-ss 开始] -i subtitle.srt ...] [-i chapters.txt]
+	//        -map 0:v:0 -map 1:a:0 [-map 2:v:0] [-map 3:s:0 ...] [-map_chapters N] [-t 时长] <格式参数> [元数据] output.<ext>
+	args := []string{"-y"} // 覆盖输出文件
+	var maps []string
+	next := 2
+	if inputs.concat {
+		// durl 分段：ffmpeg -y [-ss 开始] -f concat -safe 0 -i segments.ffconcat -map 0:v:0 -map 0:a:0 ...
+		if opts.Clip != nil {
+			args = append(args, "-ss", ffmpegTime(max(opts.Clip.Start-inputs.offsets[0], 0)))
+		}
+		args = append(args, "-f", "concat", "-safe", "0", "-i", inputs.video)
+		maps = []string{"-map", "0:v:0", "-map", "0:a:0"}
+		next = 1
+	} else {
+		args = append(args, opts.Clip.inputArgs(inputs.offsets[0])...)
+		args = append(args, "-i", inputs.video) // 输入视频
+		args = append(args, opts.Clip.inputArgs(inputs.offsets[1])...)
+		args = append(args, "-i", inputs.audio) // 输入音频
+		maps = []string{
+			"-map", "0:v:0", // 只取视频输入的视频流
+			"-map", "1:a:0", // 只取音频输入的音频流
+		}
+	}
+	if inputs.cover != "" {
+		args = append(args, "-i", inputs.cover)
+		maps = append(maps, "-map", fmt.Sprintf("%d:v:0", next))
+		next++
+	}
+	for _, sub := range inputs.subtitles {
+		if opts.Clip != nil {
+			// 字幕时间从视频开头计算
+			args = append(args, "-ss", ffmpegTime(opts.Clip.Start))
+		}
+		args = append(args, "-i", sub.path)
+		maps = append(maps, "-map", fmt.Sprintf("%d:s:0", next))
+		next++
+	}
+	if inputs.chapters != "" {
+		args = append(args, "-i", inputs.chapters)
+		maps = append(maps, "-map_chapters", strconv.Itoa(next))
+	}
+	args = append(args, maps...)
+	args = append(args, opts.Clip.outputArgs()...)
+	args = append(args, opts.args()...) // 编码参数（复制流或转码）和容器参数（faststart 等）
+	if inputs.cover != "" {
+		// 封面作为第二条视频流原样复制，并标记为 attached_pic
+		args = append(args, "-c:v:1", "copy", "-disposition:v:1", "attached_pic")
+	}
+	if len(inputs.subtitles) > 0 {
+		// 字幕转换为容器支持的格式（ASS 字幕原样复制），并标记语言和名称
+		args = append(args, "-c:s", opts.Format.SubtitleCodec)
+		for i, sub := range inputs.subtitles {
+			if sub.ass {
+				args = append(args, fmt.Sprintf("-c:s:%d", i), "copy")
+			}
+			args = append(args,
+				fmt.Sprintf("-metadata:s:s:%d", i), "language="+sub.language,
+				fmt.Sprintf("-metadata:s:s:%d", i), "title="+sub.title,
+			)
+		}
+	}
+	if opts.Metadata != nil {
+		args = append(args, opts.Metadata.args()...) // 标题、UP 主等全局元数据
+	}
+	args = append(args, outputPath) // 输出文件
+
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
 
 	// 执行命令
 	output, err := cmd.CombinedOutput()
@@ -266,6 +638,20 @@ func (d *Downloader) mergeWithFfmpeg(videoPath, audioPath, outputPath string) er
 	return nil
 }
 
+// coverExt 根据封面地址返回扩展名，不是 JPEG/PNG 时返回空字符串
+// FFmpeg 按扩展名识别图片格式，且 MP4/MOV 封面只支持 JPEG 和 PNG
+func coverExt(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+	case ".jpg", ".jpeg", ".png":
+		return ext
+	}
+	return ""
+}
+
 // cleanupFiles 清理临时文件
 // 参数 tempDir: 临时目录路径（如果为空则不删除目录）
 // 参数 files: 要删除的文件路径列表
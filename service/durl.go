@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bilibili-downloader-server/logging"
+)
+
+// segmentConcurrency 同时下载的 durl 分段数
+const segmentConcurrency = 3
+
+// Segment 需要按顺序拼接的音视频分段
+type Segment struct {
+	Url      string        // 下载地址
+	Duration time.Duration // 分段时长，未知时为 0
+}
+
+// HasDash 判断播放地址是否为 DASH 格式（音视频轨道分离）
+func (p *PlayUrlData) HasDash() bool {
+	return len(p.Dash.Video) > 0 && len(p.Dash.Audio) > 0
+}
+
+// Segments 返回按顺序排列的 durl 分段，主地址为空时使用第一个备用地址
+func (p *PlayUrlData) Segments() []Segment {
+	durl := append([]DurlSegment(nil), p.Durl...)
+	sort.SliceStable(durl, func(i, j int) bool { return durl[i].Order < durl[j].Order })
+
+	segments := make([]Segment, 0, len(durl))
+	for _, s := range durl {
+		u := s.Url
+		if u == "" && len(s.BackupUrl) > 0 {
+			u = s.BackupUrl[0]
+		}
+		segments = append(segments, Segment{Url: u, Duration: time.Duration(s.Length) * time.Millisecond})
+	}
+	return segments
+}
+
+// Duration 返回播放地址对应的视频时长，DASH 和 durl 格式都适用，未知时为 0
+func (p *PlayUrlData) Duration() time.Duration {
+	if p.Dash.Duration > 0 {
+		return time.Duration(p.Dash.Duration) * time.Second
+	}
+	return time.Duration(p.Timelength) * time.Millisecond
+}
+
+// selectSegments 选出覆盖时间范围的分段，分段时长未知时保留全部分段
+// 返回：选中的分段和第一个分段的开始时间
+func selectSegments(segments []Segment, clip *Clip) ([]Segment, time.Duration) {
+	if clip == nil {
+		return segments, 0
+	}
+	var t, offset time.Duration
+	first, last := -1, len(segments)-1
+	for i, s := range segments {
+		if s.Duration <= 0 {
+			return segments, 0
+		}
+		segFrom := t
+		t += s.Duration
+		if first < 0 && (t > clip.Start || i == len(segments)-1) {
+			first, offset = i, segFrom
+		}
+		if first >= 0 && clip.End > 0 && t >= clip.End {
+			last = i
+			break
+		}
+	}
+	return segments[first : last+1], offset
+}
+
+// SegmentAt 查找包含指定时间的分段，分段时长未知时返回第一个分段
+// 返回：分段、分段的开始时间和是否找到
+func SegmentAt(segments []Segment, at time.Duration) (Segment, time.Duration, bool) {
+	if len(segments) == 0 {
+		return Segment{}, 0, false
+	}
+	selected, offset := selectSegments(segments, &Clip{Start: at})
+	return selected[0], offset, true
+}
+
+// segmentExt 根据分段地址推断文件扩展名，无法识别时使用 .flv
+func segmentExt(rawUrl string) string {
+	if u, err := url.Parse(rawUrl); err == nil {
+		switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+		case ".flv", ".mp4":
+			return ext
+		}
+	}
+	return ".flv"
+}
+
+// downloadSegments 并发下载 durl 分段，并生成 FFmpeg concat 分离器使用的列表文件
+// 参数 ctx: 上下文，任一分段失败时取消其余下载
+// 参数 segments: 按顺序排列的分段
+// 参数 referer: Referer 头
+// 参数 dir: 保存分段的目录
+// 参数 listPath: 列表文件路径
+// 参数 clip: 截取的时间范围，为 nil 时下载全部分段
+// 返回：列表中第一个分段的开始时间和错误信息
+func (d *Downloader) downloadSegments(ctx context.Context, segments []Segment, referer, dir, listPath string, clip *Clip) (time.Duration, error) {
+	segments, offset := selectSegments(segments, clip)
+	if len(segments) == 0 {
+		return 0, fmt.Errorf("No segment to download")
+	}
+	for i, s := range segments {
+		if s.Url == "" {
+			return 0, fmt.Errorf("Segment %d has no URL", i)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, fmt.Errorf("Failed to create segment directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 第一个失败的分段取消其余下载，只报告它的错误
+	paths := make([]string, len(segments))
+	sem := make(chan struct{}, segmentConcurrency)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i, s := range segments {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("segment_%03d%s", i, segmentExt(s.Url)))
+		wg.Add(1)
+		go func(i int, s Segment) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			if err := d.DownloadFile(ctx, s.Url, referer, paths[i]); err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("Segment %d: %w", i, err)
+					cancel()
+				})
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// ffconcat 列表，路径中的单引号需要转义
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+	for _, p := range paths {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(p, "'", `'\''`))
+	}
+	if err := os.WriteFile(listPath, []byte(list.String()), 0o644); err != nil {
+		return 0, fmt.Errorf("Failed to write segment list: %w", err)
+	}
+
+	logging.FromContext(ctx).Debug("Segments downloaded", "count", len(segments), "offset", offset)
+	return offset, nil
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// 收藏夹内容分页参数，接口每页最多返回 20 条
+const (
+	DefaultFavPageSize = 20
+	MaxFavPageSize     = 20
+)
+
+// FavMediaTypeVideo 收藏夹内容类型：视频（其他类型如音频、合集不能直接下载）
+const FavMediaTypeVideo = 2
+
+// FavFolder 用户创建的收藏夹
+type FavFolder struct {
+	Id         int64  `json:"id"` // 收藏夹 ID（media_id）
+	Fid        int64  `json:"fid"`
+	Mid        int64  `json:"mid"` // 创建者 ID
+	Title      string `json:"title"`
+	MediaCount int    `json:"media_count"`
+}
+
+// FavFolderInfo 收藏夹详情
+type FavFolderInfo struct {
+	Id         int64  `json:"id"`
+	Title      string `json:"title"`
+	Intro      string `json:"intro"`
+	Cover      string `json:"cover"`
+	MediaCount int    `json:"media_count"`
+	Upper      struct {
+		Mid  int64  `json:"mid"`
+		Name string `json:"name"`
+	} `json:"upper"`
+}
+
+// FavMedia 收藏夹中的一条内容
+type FavMedia struct {
+	Id       int64  `json:"id"`   // 视频为 AV 号
+	Type     int    `json:"type"` // 2 表示视频
+	Title    string `json:"title"`
+	Cover    string `json:"cover"`
+	Intro    string `json:"intro"`
+	Page     int    `json:"page"`     // 分 P 数
+	Duration int    `json:"duration"` // 时长（秒）
+	Upper    struct {
+		Mid  int64  `json:"mid"`
+		Name string `json:"name"`
+	} `json:"upper"`
+	Attr    int    `json:"attr"`     // 0 正常，9 被 UP 主删除，1 因其他原因失效
+	FavTime int64  `json:"fav_time"` // 收藏时间（Unix 秒）
+	Pubtime int64  `json:"pubtime"`  // 发布时间（Unix 秒）
+	Bvid    string `json:"bvid"`
+}
+
+// Available 判断内容是否为可以下载的视频（未失效）
+func (m FavMedia) Available() bool {
+	return m.Type == FavMediaTypeVideo && m.Attr == 0 && m.Bvid != ""
+}
+
+// FavMediaPage 收藏夹内容的一页
+type FavMediaPage struct {
+	Info    FavFolderInfo `json:"info"`
+	Page    int           `json:"page"`
+	HasMore bool          `json:"has_more"`
+	Medias  []FavMedia    `json:"medias"`
+}
+
+// favFolderListResponse 收藏夹列表 API 响应
+type favFolderListResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    *struct {
+		Count int         `json:"count"`
+		List  []FavFolder `json:"list"`
+	} `json:"data"`
+}
+
+// favResourceListResponse 收藏夹内容 API 响应
+type favResourceListResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Info    FavFolderInfo `json:"info"`
+		Medias  []FavMedia    `json:"medias"`
+		HasMore bool          `json:"has_more"`
+	} `json:"data"`
+}
+
+// GetFavFolders 获取用户创建的全部收藏夹，私密收藏夹只有 Cookie 对应的账号本人可见
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 mid: 用户 ID
+// 返回：收藏夹列表和错误信息
+func (s *ApiService) GetFavFolders(ctx context.Context, mid int64) ([]FavFolder, error) {
+	apiUrl := fmt.Sprintf("%s%s?up_mid=%d", BaseURL, FavFolderListEndpoint, mid)
+
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+
+	// 设置请求头，Referer 为用户的收藏夹页面
+	s.setHeaders(req, fmt.Sprintf("%s/%d/favlist", SpaceURL, mid))
+
+	// 发送请求并解析 JSON 响应，没有收藏夹时 data 为 null
+	var listResp favFolderListResponse
+	if err := s.getJSON(req, FavFolderListEndpoint, &listResp); err != nil {
+		return nil, err
+	}
+	if listResp.Data == nil {
+		return []FavFolder{}, nil
+	}
+	return listResp.Data.List, nil
+}
+
+// GetFavMedia 获取收藏夹的一页内容（按收藏时间倒序）
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 mediaId: 收藏夹 ID
+// 参数 page: 页码（从 1 开始）
+// 参数 pageSize: 每页数量（1~20）
+// 返回：收藏夹内容的一页和错误信息
+func (s *ApiService) GetFavMedia(ctx context.Context, mediaId int64, page, pageSize int) (*FavMediaPage, error) {
+	query := url.Values{}
+	query.Set("media_id", strconv.FormatInt(mediaId, 10))
+	query.Set("pn", strconv.Itoa(page))
+	query.Set("ps", strconv.Itoa(pageSize))
+	query.Set("order", "mtime")
+	query.Set("type", "0")
+	query.Set("platform", "web")
+	apiUrl := fmt.Sprintf("%s%s?%s", BaseURL, FavResourceListEndpoint, query.Encode())
+
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+
+	// 设置请求头
+	s.setHeaders(req, "")
+
+	// 发送请求并解析 JSON 响应
+	var resourceResp favResourceListResponse
+	if err := s.getJSON(req, FavResourceListEndpoint, &resourceResp); err != nil {
+		return nil, err
+	}
+	data := resourceResp.Data
+	medias := data.Medias
+	if medias == nil {
+		medias = []FavMedia{}
+	}
+	return &FavMediaPage{
+		Info:    data.Info,
+		Page:    page,
+		HasMore: data.HasMore,
+		Medias:  medias,
+	}, nil
+}
+
+// ListFavMedia 逐页获取收藏夹的全部内容
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 mediaId: 收藏夹 ID
+// 返回：收藏夹详情、全部内容和错误信息
+func (s *ApiService) ListFavMedia(ctx context.Context, mediaId int64) (*FavFolderInfo, []FavMedia, error) {
+	var info FavFolderInfo
+	var medias []FavMedia
+	for page := 1; ; page++ {
+		result, err := s.GetFavMedia(ctx, mediaId, page, MaxFavPageSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to get page %d: %w", page, err)
+		}
+		info = result.Info
+		medias = append(medias, result.Medias...)
+		if !result.HasMore || len(result.Medias) == 0 {
+			return &info, medias, nil
+		}
+	}
+}
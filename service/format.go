@@ -0,0 +1,143 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"sort"
+)
+
+// ErrStreamUnavailable 没有满足输出格式要求的音视频流
+var ErrStreamUnavailable = errors.New("Required stream not available")
+
+// OutputFormat 输出容器格式
+type OutputFormat struct {
+	Name          string   // 格式名称，同时也是 format 参数的取值
+	Extension     string   // 文件扩展名（不含点）
+	ContentType   string   // HTTP Content-Type
+	RequiredCodec string   // 直接复制流时容器要求的视频编码（avc/hevc/av1），为空表示不限制
+	CopyArgs      []string // 不转码时的编码参数（直接复制流等）
+	MuxArgs       []string // 容器相关参数，转码与否都会使用
+	VideoEncoders []string // 转码时容器允许的 FFmpeg 视频编码器，为空表示不限制
+	AudioEncoders []string // 转码时容器允许的 FFmpeg 音频编码器，为空表示不限制
+	CoverArt      bool     // 是否支持嵌入封面图片（attached_pic）
+	SubtitleCodec string   // 内嵌字幕使用的 FFmpeg 编码器，为空表示不支持内嵌字幕
+	AssSubtitles  bool     // 是否支持内嵌 ASS 字幕（保留位置、颜色和动画，用于弹幕）
+	SampleEntries bool     // 是否为 ISO BMFF 容器，复制 HEVC 轨道时需要指定 sample entry（hvc1/dvh1）
+	DolbyAudio    bool     // 是否能直接复制 E-AC-3 杜比音频
+}
+
+// DefaultFormat 默认输出格式
+const DefaultFormat = "mp4"
+
+// outputFormats 支持的输出格式
+var outputFormats = map[string]OutputFormat{
+	// MP4：moov 移到文件开头（faststart），浏览器无需下载完整文件即可播放
+	"mp4": {
+		Name:          "mp4",
+		Extension:     "mp4",
+		ContentType:   "video/mp4",
+		CoverArt:      true,
+		SubtitleCodec: "mov_text",
+		SampleEntries: true,
+		DolbyAudio:    true,
+		CopyArgs:      []string{"-c", "copy"},
+		MuxArgs:       []string{"-movflags", "+faststart"},
+	},
+	// MKV：可以容纳 FLAC、E-AC-3 等 MP4 不支持或支持不佳的音频
+	"mkv": {
+		Name:          "mkv",
+		Extension:     "mkv",
+		ContentType:   "video/x-matroska",
+		CoverArt:      true,
+		SubtitleCodec: "srt",
+		AssSubtitles:  true,
+		DolbyAudio:    true,
+		CopyArgs:      []string{"-c", "copy"},
+	},
+	// WebM：只允许 VP8/VP9/AV1 + Vorbis/Opus，视频直接复制 AV1 轨道，音频转码为 Opus，不支持封面
+	"webm": {
+		Name:          "webm",
+		Extension:     "webm",
+		ContentType:   "video/webm",
+		RequiredCodec: "av1",
+		SubtitleCodec: "webvtt",
+		CopyArgs:      []string{"-c:v", "copy", "-c:a", "libopus", "-b:a", "160k"},
+		VideoEncoders: []string{"libvpx", "libvpx-vp9", "libaom-av1", "libsvtav1", "librav1e"},
+		AudioEncoders: []string{"libopus", "libvorbis"},
+	},
+	// MOV：QuickTime 容器，同样启用 faststart
+	"mov": {
+		Name:          "mov",
+		Extension:     "mov",
+		ContentType:   "video/quicktime",
+		CoverArt:      true,
+		SubtitleCodec: "mov_text",
+		SampleEntries: true,
+		DolbyAudio:    true,
+		CopyArgs:      []string{"-c", "copy"},
+		MuxArgs:       []string{"-movflags", "+faststart"},
+	},
+}
+
+// LookupFormat 根据名称查找输出格式
+// 参数 name: 格式名称（mp4/mkv/webm/mov）
+// 返回：输出格式和是否存在
+func LookupFormat(name string) (OutputFormat, bool) {
+	f, ok := outputFormats[name]
+	return f, ok
+}
+
+// FormatNames 返回所有支持的输出格式名称（按字母排序）
+func FormatNames() []string {
+	names := make([]string, 0, len(outputFormats))
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Accepts 判断视频轨道的编码是否能放入该容器
+func (f OutputFormat) Accepts(track VideoTrack) bool {
+	if f.RequiredCodec == "" {
+		return true
+	}
+	return track.Codecid == videoCodecIds[f.RequiredCodec]
+}
+
+// Supports 判断转码配置档使用的编码器能否放入该容器
+func (f OutputFormat) Supports(p TranscodeProfile) bool {
+	return (len(f.VideoEncoders) == 0 || slices.Contains(f.VideoEncoders, p.VideoCodec)) &&
+		(len(f.AudioEncoders) == 0 || slices.Contains(f.AudioEncoders, p.AudioCodec))
+}
+
+// MergeOptions 合并音视频时的输出选项
+type MergeOptions struct {
+	Format    OutputFormat      // 输出容器格式
+	Profile   *TranscodeProfile // 转码配置档，为 nil 时直接复制流
+	Metadata  *Metadata         // 元数据、封面和章节，为 nil 时不写入
+	Subtitles []SubtitleFile    // 内嵌字幕，容器不支持时忽略
+	Clip      *Clip             // 截取的时间范围，为 nil 时输出完整视频
+	Hdr       *HdrOptions       // HDR / 杜比视界轨道的容器参数，为 nil 时表示普通轨道
+	CacheKey  string            // 缓存键，不为空时把输出文件存入缓存
+}
+
+// SubtitleFile 要内嵌到输出文件的字幕
+type SubtitleFile struct {
+	Language string // ISO 639-2 语言代码，如 chi、eng
+	Title    string // 字幕轨道名称，如 中文（中国）
+	Data     []byte // 字幕内容，默认为 SRT 格式
+	Ass      bool   // Data 是否为 ASS 格式，ASS 字幕原样复制，只能放入支持 ASS 的容器
+}
+
+// args 返回输入之后、输出文件之前的 FFmpeg 参数
+func (o MergeOptions) args() []string {
+	var args []string
+	if o.Profile != nil {
+		args = append(args, o.Profile.args()...)
+	} else {
+		args = append(args, o.Format.CopyArgs...)
+		args = append(args, o.Hdr.args(o.Format)...)
+	}
+	return append(args, o.Format.MuxArgs...)
+}
@@ -0,0 +1,71 @@
+package service
+
+// 高动态范围和 8K 清晰度代码
+const (
+	QualityHdr         = 125 // HDR 真彩（HDR10 / HLG）
+	QualityDolbyVision = 126 // 杜比视界
+	Quality8K          = 127 // 超高清 8K，通常同样是 HDR
+)
+
+// HdrMode 高动态范围轨道的选择方式
+type HdrMode string
+
+const (
+	HdrNone  HdrMode = ""      // 不要求 HDR，按 quality 参数选择
+	HdrHdr10 HdrMode = "hdr"   // HDR 真彩或 8K 轨道
+	HdrDolby HdrMode = "dolby" // 杜比视界轨道，搭配杜比音频
+)
+
+// Quality 返回向播放地址接口请求的清晰度
+// 参数 requested: 请求的清晰度，HDR 模式下请求 8K 时保留 8K
+func (m HdrMode) Quality(requested int) int {
+	switch m {
+	case HdrHdr10:
+		if requested >= Quality8K {
+			return Quality8K
+		}
+		return QualityHdr
+	case HdrDolby:
+		return QualityDolbyVision
+	}
+	return requested
+}
+
+// Matches 判断视频轨道是否满足 HDR 模式，账号没有权限或视频没有对应轨道时接口会返回较低的清晰度
+func (m HdrMode) Matches(track VideoTrack) bool {
+	switch m {
+	case HdrHdr10:
+		return track.Id == QualityHdr || track.Id == Quality8K
+	case HdrDolby:
+		return track.Id == QualityDolbyVision
+	}
+	return true
+}
+
+// SelectAudioTrack 选择要下载的音频轨道（不能为空）
+// 杜比视界模式下优先使用杜比音频（E-AC-3 / 全景声），没有时使用普通音频
+func SelectAudioTrack(dash DashData, mode HdrMode) AudioTrack {
+	if mode == HdrDolby && dash.Dolby != nil && len(dash.Dolby.Audio) > 0 {
+		return dash.Dolby.Audio[0]
+	}
+	return dash.Audio[0]
+}
+
+// HdrOptions 直接复制 HDR / 杜比视界轨道时保留色彩信息所需的参数
+type HdrOptions struct {
+	Mode    HdrMode // HDR 模式
+	Codecid int     // 视频轨道的编码，决定 MP4 中使用的 sample entry
+}
+
+// args 返回容器相关的 FFmpeg 参数
+// 色彩信息在码流中，直接复制即可保留；MP4 / MOV 还需要正确的 sample entry：
+// HEVC 使用 hvc1（Apple 设备只识别 hvc1），杜比视界使用 dvh1 并写入 dvcC 配置（需要 -strict unofficial）
+func (h *HdrOptions) args(f OutputFormat) []string {
+	if h == nil || !f.SampleEntries || h.Codecid != videoCodecIds["hevc"] {
+		return nil
+	}
+	if h.Mode == HdrDolby {
+		return []string{"-tag:v:0", "dvh1", "-strict", "unofficial"}
+	}
+	return []string{"-tag:v:0", "hvc1"}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/metrics"
+)
+
+// ImageFormat 封面和缩略图的输出格式
+type ImageFormat struct {
+	Name        string // 格式名称，同时也是 format 参数的取值
+	Extension   string // 文件扩展名（不含点）
+	ContentType string // HTTP Content-Type
+	Encoder     string // FFmpeg 图片编码器
+}
+
+// DefaultImageFormat 默认图片格式
+const DefaultImageFormat = "jpg"
+
+// MaxImageSize 缩放后图片宽高的上限
+const MaxImageSize = 4096
+
+// imageFormats 支持的图片格式
+var imageFormats = map[string]ImageFormat{
+	"jpg":  {Name: "jpg", Extension: "jpg", ContentType: "image/jpeg", Encoder: "mjpeg"},
+	"png":  {Name: "png", Extension: "png", ContentType: "image/png", Encoder: "png"},
+	"webp": {Name: "webp", Extension: "webp", ContentType: "image/webp", Encoder: "libwebp"},
+}
+
+// LookupImageFormat 根据名称查找图片格式，jpeg 视为 jpg
+// 参数 name: 格式名称（jpg/png/webp）
+// 返回：图片格式和是否存在
+func LookupImageFormat(name string) (ImageFormat, bool) {
+	if name == "jpeg" {
+		name = "jpg"
+	}
+	f, ok := imageFormats[name]
+	return f, ok
+}
+
+// ImageFormatNames 返回所有支持的图片格式名称（按字母排序）
+func ImageFormatNames() []string {
+	names := make([]string, 0, len(imageFormats))
+	for name := range imageFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ImageOptions 图片输出选项
+type ImageOptions struct {
+	Format ImageFormat // 输出格式
+	Width  int         // 输出宽度，0 表示按高度等比缩放
+	Height int         // 输出高度，0 表示按宽度等比缩放；宽高都为 0 时保持原尺寸
+}
+
+// args 返回输入之后的 FFmpeg 参数：只输出一帧，按需缩放并编码为目标格式
+func (o ImageOptions) args() []string {
+	args := []string{"-frames:v", "1", "-an"}
+	if o.Width > 0 || o.Height > 0 {
+		w, h := o.Width, o.Height
+		if w == 0 {
+			w = -2
+		}
+		if h == 0 {
+			h = -2
+		}
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:%d", w, h))
+	}
+	return append(args, "-c:v", o.Format.Encoder, "-f", "image2pipe", "pipe:1")
+}
+
+// resized 是否需要缩放
+func (o ImageOptions) resized() bool {
+	return o.Width > 0 || o.Height > 0
+}
+
+// FetchImage 下载图片，需要缩放或源格式与目标格式不同时使用 FFmpeg 转换
+// 参数 ctx: 上下文，取消时中断下载和转换
+// 参数 imageUrl: 图片地址
+// 参数 opts: 输出选项
+// 返回：图片内容和错误信息
+func (d *Downloader) FetchImage(ctx context.Context, imageUrl string, opts ImageOptions) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, imageUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+	d.setDownloadHeaders(req, "")
+
+	_, client := d.client.get()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Image request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Image download failed, status code: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	metrics.CdnBytesTotal.Add(float64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read image: %w", err)
+	}
+
+	// 不需要转换时原样返回
+	contentType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if !opts.resized() && strings.EqualFold(strings.TrimSpace(contentType), opts.Format.ContentType) {
+		return data, nil
+	}
+
+	// ffmpeg -i pipe:0 -frames:v 1 -an [-vf scale=W:H] -c:v <编码器> -f image2pipe pipe:1
+	args := append([]string{"-i", "pipe:0"}, opts.args()...)
+	return d.runImageFfmpeg(ctx, args, bytes.NewReader(data))
+}
+
+// Thumbnail 从视频流中截取指定时间的一帧
+// FFmpeg 通过 HTTP Range 请求按索引定位到目标位置附近，只读取所需的片段，不会下载整个文件
+// 参数 ctx: 上下文，取消时中断截取
+// 参数 videoUrl: DASH 视频流地址
+// 参数 bvid: 视频 BV 号，用于生成 Referer
+// 参数 at: 截取时间
+// 参数 opts: 输出选项
+// 返回：图片内容和错误信息
+func (d *Downloader) Thumbnail(ctx context.Context, videoUrl, bvid string, at time.Duration, opts ImageOptions) ([]byte, error) {
+	clientOpts, _ := d.client.get()
+	referer := fmt.Sprintf("%s/video/%s/", VideoURL, bvid)
+
+	// ffmpeg -user_agent <UA> -headers "Referer: ..." -ss <时间> -i <地址> -frames:v 1 ... pipe:1
+	// -ss 放在 -i 之前为输入定位，先按关键帧索引跳转再解码到精确时间
+	args := []string{
+		"-user_agent", clientOpts.UserAgent,
+		"-headers", "Referer: " + referer + "\r\n",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", videoUrl,
+	}
+	args = append(args, opts.args()...)
+	return d.runImageFfmpeg(ctx, args, nil)
+}
+
+// runImageFfmpeg 运行输出单张图片的 FFmpeg 命令，受合并并发数限制
+// 参数 ctx: 上下文
+// 参数 args: 除 -y、-loglevel 以外的 FFmpeg 参数，输出为 pipe:1
+// 参数 stdin: 标准输入，为 nil 时不使用
+// 返回：图片内容和错误信息
+func (d *Downloader) runImageFfmpeg(ctx context.Context, args []string, stdin io.Reader) ([]byte, error) {
+	// 服务关闭时（Abort）同样终止 FFmpeg
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(d.baseCtx, cancel)
+	defer stop()
+
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("FFmpeg not found, please ensure it is installed: %w", err)
+	}
+
+	release, err := d.mergeLimiter.Acquire(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Waiting for FFmpeg slot cancelled: %w", err)
+	}
+	defer release()
+
+	start := time.Now()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath, append([]string{"-y", "-loglevel", "error"}, args...)...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err == nil && stdout.Len() == 0 {
+		err = fmt.Errorf("No frame decoded")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FFmpeg execution failed: %w, output: %s", err, stderr.String())
+	}
+
+	logging.FromContext(ctx).Debug("FFmpeg image finished",
+		"bytes", stdout.Len(),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+	return stdout.Bytes(), nil
+}
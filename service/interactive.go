@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// MaxStoryNodes 遍历互动视频剧情图时最多请求的节点数，防止异常的图无限展开
+const MaxStoryNodes = 300
+
+// ErrNotInteractive 视频不是互动视频
+var ErrNotInteractive = errors.New("Video is not an interactive video")
+
+// StoryGraph 互动视频的剧情图
+type StoryGraph struct {
+	Bvid         string      `json:"bvid"`
+	Title        string      `json:"title"` // 视频标题，由调用方填写
+	GraphVersion int64       `json:"graph_version"`
+	Nodes        []StoryNode `json:"nodes"`     // 按广度优先顺序排列，第一个是起始节点
+	Truncated    bool        `json:"truncated"` // 节点数超过 MaxStoryNodes，剩余节点没有展开
+}
+
+// StoryNode 剧情图节点，每个节点播放一个 CID，不同节点可能指向同一个 CID
+type StoryNode struct {
+	EdgeId    int64           `json:"edge_id"`
+	Cid       int64           `json:"cid"`
+	Title     string          `json:"title"`
+	IsLeaf    bool            `json:"is_leaf"` // 结局节点
+	Questions []StoryQuestion `json:"questions,omitempty"`
+}
+
+// StoryQuestion 节点结束时出现的选择
+type StoryQuestion struct {
+	Title      string        `json:"title"`
+	Duration   int           `json:"duration"`    // 选择时限（毫秒），-1 表示不限时
+	PauseVideo bool          `json:"pause_video"` // 出现选择时是否暂停视频
+	Choices    []StoryChoice `json:"choices"`
+}
+
+// StoryChoice 一个选项，指向下一个节点
+type StoryChoice struct {
+	EdgeId    int64  `json:"edge_id"`
+	Cid       int64  `json:"cid"`
+	Option    string `json:"option"`
+	Condition string `json:"condition,omitempty"` // 出现条件（隐藏变量表达式），为空表示总是出现
+	Default   bool   `json:"default"`             // 超时时自动选择
+}
+
+// storyEdgeResponse 剧情图节点 API 响应
+type storyEdgeResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Title  string `json:"title"`
+		EdgeId int64  `json:"edge_id"`
+		IsLeaf int    `json:"is_leaf"`
+		Edges  struct {
+			Questions []struct {
+				Title      string `json:"title"`
+				Duration   int    `json:"duration"`
+				PauseVideo int    `json:"pause_video"`
+				Choices    []struct {
+					Id        int64  `json:"id"`
+					Cid       int64  `json:"cid"`
+					Option    string `json:"option"`
+					Condition string `json:"condition"`
+					IsDefault int    `json:"is_default"`
+				} `json:"choices"`
+			} `json:"questions"`
+		} `json:"edges"`
+	} `json:"data"`
+}
+
+// GetStoryGraph 从起始节点开始广度优先遍历互动视频的剧情图，返回的剧情图不含视频标题
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 bvid: 视频 BV 号
+// 参数 cid: 第一个分 P 的 CID（起始节点）
+// 返回：剧情图和错误信息，普通视频返回 ErrNotInteractive
+func (s *ApiService) GetStoryGraph(ctx context.Context, bvid string, cid int64) (*StoryGraph, error) {
+	player, err := s.getPlayerInfo(ctx, bvid, cid)
+	if err != nil {
+		return nil, err
+	}
+	if player.Data.Interaction == nil || player.Data.Interaction.GraphVersion == 0 {
+		return nil, ErrNotInteractive
+	}
+
+	graph := &StoryGraph{Bvid: bvid, GraphVersion: player.Data.Interaction.GraphVersion}
+	type pending struct {
+		edgeId int64
+		cid    int64
+	}
+	// 起始节点的 edge_id 由接口返回，请求时省略；其余节点在入队时标记，避免重复请求
+	queue := []pending{{cid: cid}}
+	seen := map[int64]bool{}
+	for len(queue) > 0 {
+		if len(graph.Nodes) >= MaxStoryNodes {
+			graph.Truncated = true
+			break
+		}
+		next := queue[0]
+		queue = queue[1:]
+
+		node, err := s.getStoryNode(ctx, bvid, graph.GraphVersion, next.edgeId)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get story node %d: %w", next.edgeId, err)
+		}
+		seen[node.EdgeId] = true
+		node.Cid = next.cid
+		graph.Nodes = append(graph.Nodes, *node)
+
+		for _, q := range node.Questions {
+			for _, choice := range q.Choices {
+				if !seen[choice.EdgeId] {
+					seen[choice.EdgeId] = true
+					queue = append(queue, pending{edgeId: choice.EdgeId, cid: choice.Cid})
+				}
+			}
+		}
+	}
+	return graph, nil
+}
+
+// getStoryNode 获取剧情图中的一个节点
+// 参数 edgeId: 节点 ID，0 表示起始节点
+// 返回：节点（不含 CID）和错误信息
+func (s *ApiService) getStoryNode(ctx context.Context, bvid string, graphVersion, edgeId int64) (*StoryNode, error) {
+	query := url.Values{}
+	query.Set("bvid", bvid)
+	query.Set("graph_version", strconv.FormatInt(graphVersion, 10))
+	if edgeId != 0 {
+		query.Set("edge_id", strconv.FormatInt(edgeId, 10))
+	}
+	apiUrl := fmt.Sprintf("%s%s?%s", BaseURL, StoryEdgeEndpoint, query.Encode())
+
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+
+	// 设置请求头，Referer 需要包含 BV 号
+	s.setHeaders(req, fmt.Sprintf("%s/video/%s/", VideoURL, bvid))
+
+	// 发送请求并解析 JSON 响应
+	var edgeResp storyEdgeResponse
+	if err := s.getJSON(req, StoryEdgeEndpoint, &edgeResp); err != nil {
+		return nil, err
+	}
+
+	data := edgeResp.Data
+	node := &StoryNode{EdgeId: data.EdgeId, Title: data.Title, IsLeaf: data.IsLeaf == 1}
+	if node.EdgeId == 0 {
+		node.EdgeId = edgeId
+	}
+	for _, q := range data.Edges.Questions {
+		question := StoryQuestion{Title: q.Title, Duration: q.Duration, PauseVideo: q.PauseVideo == 1}
+		for _, c := range q.Choices {
+			question.Choices = append(question.Choices, StoryChoice{
+				EdgeId:    c.Id,
+				Cid:       c.Cid,
+				Option:    c.Option,
+				Condition: c.Condition,
+				Default:   c.IsDefault == 1,
+			})
+		}
+		node.Questions = append(node.Questions, question)
+	}
+	return node, nil
+}
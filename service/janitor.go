@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"bilibili-downloader-server/logging"
+)
+
+// workDirPrefix 临时工作目录名前缀
+const workDirPrefix = "bilibili_downloader_"
+
+// ErrInsufficientDisk 剩余磁盘空间不足，拒绝新的下载
+var ErrInsufficientDisk = errors.New("Insufficient disk space")
+
+// SweepWorkDirs 删除目录下超过指定时长未修改的工作目录
+// 参数 root: 工作目录所在的根目录
+// 参数 maxAge: 修改时间早于 now-maxAge 的工作目录视为残留
+// 参数 inUse: 判断目录是否仍在使用，返回 true 的目录不会被删除，可以为 nil
+// 返回：删除的目录数量和错误信息
+func SweepWorkDirs(root string, maxAge time.Duration, inUse func(dir string) bool) (int, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("Failed to read work root: %w", err)
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workDirPrefix) {
+			continue
+		}
+
+		dir := filepath.Join(root, entry.Name())
+		if inUse != nil && inUse(dir) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		if err := os.RemoveAll(dir); err != nil {
+			logging.FromContext(context.Background()).Warn("Failed to remove stale work directory", "dir", dir, "error", err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Sweep 清理工作根目录下的残留工作目录，跳过正在使用的目录
+// 参数 maxAge: 修改时间早于 now-maxAge 的工作目录视为残留
+// 返回：删除的目录数量和错误信息
+func (d *Downloader) Sweep(maxAge time.Duration) (int, error) {
+	return d.SweepDir(d.TempDir(), maxAge)
+}
+
+// SweepDir 清理指定目录下的残留工作目录，跳过正在使用的目录
+// 用于清理旧版本或修改 TEMP_DIR 前留在其他目录中的工作目录，目录可能与当前的工作根目录相同
+// 参数 root: 工作目录所在的根目录
+// 参数 maxAge: 修改时间早于 now-maxAge 的工作目录视为残留
+// 返回：删除的目录数量和错误信息
+func (d *Downloader) SweepDir(root string, maxAge time.Duration) (int, error) {
+	return SweepWorkDirs(root, maxAge, d.workDirInUse)
+}
+
+// RunJanitor 定期清理残留的工作目录和过期的缓存文件，启动时立即执行一次
+// 清理间隔和残留判定时长取自 DownloaderOptions，热更新后在下一轮生效
+// 参数 ctx: 上下文，取消时停止
+func (d *Downloader) RunJanitor(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	for {
+		root := d.TempDir()
+		removed, err := d.Sweep(time.Duration(d.staleAfter.Load()))
+		if err != nil {
+			logger.Warn("Work directory sweep failed", "root", root, "error", err)
+		} else if removed > 0 {
+			logger.Info("Removed stale work directories", "root", root, "count", removed)
+		}
+
+		if removed, err := d.cache.Prune(); err != nil {
+			logger.Warn("Cache prune failed", "error", err)
+		} else if removed > 0 {
+			logger.Info("Removed expired cache entries", "count", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(d.janitorInterval.Load())):
+		}
+	}
+}
+
+// workDirInUse 判断工作目录是否属于进行中的下载
+func (d *Downloader) workDirInUse(dir string) bool {
+	d.workDirsMu.Lock()
+	defer d.workDirsMu.Unlock()
+	_, ok := d.workDirs[dir]
+	return ok
+}
+
+// checkDiskSpace 检查工作根目录剩余空间，低于下限时返回 ErrInsufficientDisk
+// 当前平台无法获取剩余空间时不做限制
+func (d *Downloader) checkDiskSpace(ctx context.Context) error {
+	minFree := d.minFreeDisk.Load()
+	if minFree == 0 {
+		return nil
+	}
+
+	root := d.TempDir()
+	free, err := FreeDiskSpace(root)
+	if err != nil {
+		logging.FromContext(ctx).Debug("Skipping disk space guard", "root", root, "error", err)
+		return nil
+	}
+	if free < minFree {
+		return fmt.Errorf("%w: %d MiB free in %s, %d MiB required", ErrInsufficientDisk, free>>20, root, minFree>>20)
+	}
+	return nil
+}
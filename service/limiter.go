@@ -0,0 +1,185 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Limiter 带 FIFO 等待队列的并发限制器
+// 超出并发上限的请求会排队等待而不是直接被拒绝
+type Limiter struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiters *list.List // 元素类型为 chan struct{}
+}
+
+// NewLimiter 创建并发限制器
+// 参数 limit: 最大并发数，小于等于 0 表示不限制
+// 返回：配置好的 Limiter 实例
+func NewLimiter(limit int) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		waiters: list.New(),
+	}
+}
+
+// Acquire 获取一个执行名额，名额不足时排队等待
+// 参数 ctx: 上下文，取消时放弃排队
+// 参数 onQueued: 需要排队时回调，参数为排队位置（从 1 开始），可以为 nil
+// 返回：释放名额的函数和错误信息
+func (l *Limiter) Acquire(ctx context.Context, onQueued func(position int)) (func(), error) {
+	l.mu.Lock()
+	if l.limit <= 0 || (l.active < l.limit && l.waiters.Len() == 0) {
+		l.active++
+		l.mu.Unlock()
+		return l.releaseFunc(), nil
+	}
+
+	// 进入等待队列
+	ready := make(chan struct{})
+	elem := l.waiters.PushBack(ready)
+	position := l.waiters.Len()
+	l.mu.Unlock()
+
+	if onQueued != nil {
+		onQueued(position)
+	}
+
+	select {
+	case <-ready:
+		return l.releaseFunc(), nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-ready:
+			// 取消的同时已经被唤醒，把名额交给下一个等待者
+			l.releaseLocked()
+		default:
+			l.waiters.Remove(elem)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// SetLimit 调整并发上限，调大时立即唤醒排队中的请求
+// 调小时不会中断已经在执行的请求，只是暂停放行新的请求
+// 参数 limit: 新的最大并发数，小于等于 0 表示不限制
+func (l *Limiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+	for l.waiters.Len() > 0 && (l.limit <= 0 || l.active < l.limit) {
+		front := l.waiters.Front()
+		l.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		l.active++
+	}
+}
+
+// Stats 返回当前执行中和排队中的数量
+func (l *Limiter) Stats() (active, waiting int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active, l.waiters.Len()
+}
+
+// releaseFunc 返回只会生效一次的释放函数
+func (l *Limiter) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.releaseLocked()
+		})
+	}
+}
+
+// releaseLocked 释放一个名额，优先直接转交给队首等待者（调用方需持有锁）
+func (l *Limiter) releaseLocked() {
+	if front := l.waiters.Front(); front != nil && (l.limit <= 0 || l.active <= l.limit) {
+		l.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	l.active--
+}
+
+// KeyedLimiter 按 key（例如客户端 IP）分别限制并发
+type KeyedLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	limiters map[string]*keyedEntry
+}
+
+// keyedEntry 单个 key 的限制器及引用计数
+type keyedEntry struct {
+	limiter *Limiter
+	refs    int
+}
+
+// NewKeyedLimiter 创建按 key 限制并发的限制器
+// 参数 limit: 每个 key 的最大并发数，小于等于 0 表示不限制
+// 返回：配置好的 KeyedLimiter 实例
+func NewKeyedLimiter(limit int) *KeyedLimiter {
+	return &KeyedLimiter{
+		limit:    limit,
+		limiters: make(map[string]*keyedEntry),
+	}
+}
+
+// Acquire 为指定 key 获取一个执行名额，名额不足时排队等待
+// 参数 ctx: 上下文，取消时放弃排队
+// 参数 key: 限制维度的 key
+// 参数 onQueued: 需要排队时回调，参数为排队位置（从 1 开始），可以为 nil
+// 返回：释放名额的函数和错误信息
+func (k *KeyedLimiter) Acquire(ctx context.Context, key string, onQueued func(position int)) (func(), error) {
+	k.mu.Lock()
+	entry, ok := k.limiters[key]
+	if !ok {
+		entry = &keyedEntry{limiter: NewLimiter(k.limit)}
+		k.limiters[key] = entry
+	}
+	entry.refs++
+	k.mu.Unlock()
+
+	release, err := entry.limiter.Acquire(ctx, onQueued)
+	if err != nil {
+		k.unref(key, entry)
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			release()
+			k.unref(key, entry)
+		})
+	}, nil
+}
+
+// SetLimit 调整每个 key 的并发上限，对已存在的 key 立即生效
+// 参数 limit: 新的最大并发数，小于等于 0 表示不限制
+func (k *KeyedLimiter) SetLimit(limit int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.limit = limit
+	for _, entry := range k.limiters {
+		entry.limiter.SetLimit(limit)
+	}
+}
+
+// unref 减少引用计数，空闲时删除对应的限制器
+func (k *KeyedLimiter) unref(key string, entry *keyedEntry) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	entry.refs--
+	if entry.refs == 0 {
+		delete(k.limiters, key)
+	}
+}
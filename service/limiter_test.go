@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// acquireAsync 在后台获取名额，返回排队位置和获取结果的通道
+func acquireAsync(ctx context.Context, acquire func(context.Context, func(int)) (func(), error)) (<-chan int, <-chan func()) {
+	queued := make(chan int, 1)
+	acquired := make(chan func(), 1)
+	go func() {
+		release, err := acquire(ctx, func(position int) { queued <- position })
+		if err == nil {
+			acquired <- release
+		}
+		close(acquired)
+	}()
+	return queued, acquired
+}
+
+// waitQueued 等待请求进入队列并返回排队位置
+func waitQueued(t *testing.T, queued <-chan int) int {
+	t.Helper()
+	select {
+	case position := <-queued:
+		return position
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not queued")
+		return 0
+	}
+}
+
+// waitAcquired 等待请求获取到名额
+func waitAcquired(t *testing.T, acquired <-chan func()) func() {
+	t.Helper()
+	select {
+	case release, ok := <-acquired:
+		if !ok {
+			t.Fatal("acquire failed")
+		}
+		return release
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not acquire a slot")
+		return nil
+	}
+}
+
+// assertWaiting 确认请求仍在排队
+func assertWaiting(t *testing.T, acquired <-chan func()) {
+	t.Helper()
+	select {
+	case <-acquired:
+		t.Fatal("request acquired a slot while it should be waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestLimiterFIFO(t *testing.T) {
+	l := NewLimiter(1)
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx, func(int) { t.Error("first request should not be queued") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued1, acquired1 := acquireAsync(ctx, l.Acquire)
+	if position := waitQueued(t, queued1); position != 1 {
+		t.Errorf("first waiter position = %d, want 1", position)
+	}
+	queued2, acquired2 := acquireAsync(ctx, l.Acquire)
+	if position := waitQueued(t, queued2); position != 2 {
+		t.Errorf("second waiter position = %d, want 2", position)
+	}
+	if active, waiting := l.Stats(); active != 1 || waiting != 2 {
+		t.Errorf("stats = %d active, %d waiting; want 1, 2", active, waiting)
+	}
+
+	// 释放的名额按排队顺序直接转交，执行中的数量不变
+	release()
+	release() // 重复释放不生效
+	release1 := waitAcquired(t, acquired1)
+	assertWaiting(t, acquired2)
+	if active, waiting := l.Stats(); active != 1 || waiting != 1 {
+		t.Errorf("stats after handoff = %d active, %d waiting; want 1, 1", active, waiting)
+	}
+
+	release1()
+	release2 := waitAcquired(t, acquired2)
+	release2()
+	if active, waiting := l.Stats(); active != 0 || waiting != 0 {
+		t.Errorf("stats after release = %d active, %d waiting; want 0, 0", active, waiting)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := NewLimiter(1)
+	release, err := l.Acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued1, acquired1 := acquireAsync(ctx, l.Acquire)
+	waitQueued(t, queued1)
+	queued2, acquired2 := acquireAsync(context.Background(), l.Acquire)
+	waitQueued(t, queued2)
+
+	// 取消排队的请求离开队列，不占用名额
+	cancel()
+	if _, ok := <-acquired1; ok {
+		t.Fatal("cancelled request acquired a slot")
+	}
+	if _, err := l.Acquire(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire with cancelled context: %v, want context.Canceled", err)
+	}
+	if active, waiting := l.Stats(); active != 1 || waiting != 1 {
+		t.Errorf("stats after cancel = %d active, %d waiting; want 1, 1", active, waiting)
+	}
+
+	release()
+	waitAcquired(t, acquired2)()
+	if active, waiting := l.Stats(); active != 0 || waiting != 0 {
+		t.Errorf("stats after release = %d active, %d waiting; want 0, 0", active, waiting)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := NewLimiter(0)
+	var releases []func()
+	for i := 0; i < 10; i++ {
+		release, err := l.Acquire(context.Background(), func(int) { t.Error("unlimited limiter queued a request") })
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+	if active, _ := l.Stats(); active != 10 {
+		t.Errorf("active = %d, want 10", active)
+	}
+	for _, release := range releases {
+		release()
+	}
+	if active, _ := l.Stats(); active != 0 {
+		t.Errorf("active after release = %d, want 0", active)
+	}
+}
+
+func TestLimiterSetLimit(t *testing.T) {
+	l := NewLimiter(1)
+	ctx := context.Background()
+	release1, err := l.Acquire(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued2, acquired2 := acquireAsync(ctx, l.Acquire)
+	waitQueued(t, queued2)
+	queued3, acquired3 := acquireAsync(ctx, l.Acquire)
+	waitQueued(t, queued3)
+
+	// 调大上限时立即放行排队的请求
+	l.SetLimit(2)
+	release2 := waitAcquired(t, acquired2)
+	assertWaiting(t, acquired3)
+
+	// 调小上限不中断执行中的请求，释放后也不放行新的请求，直到低于新的上限
+	l.SetLimit(1)
+	release1()
+	assertWaiting(t, acquired3)
+	if active, waiting := l.Stats(); active != 1 || waiting != 1 {
+		t.Errorf("stats = %d active, %d waiting; want 1, 1", active, waiting)
+	}
+	release2()
+	waitAcquired(t, acquired3)()
+	if active, waiting := l.Stats(); active != 0 || waiting != 0 {
+		t.Errorf("stats after release = %d active, %d waiting; want 0, 0", active, waiting)
+	}
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	k := NewKeyedLimiter(1)
+	ctx := context.Background()
+	acquire := func(key string) func(context.Context, func(int)) (func(), error) {
+		return func(ctx context.Context, onQueued func(int)) (func(), error) {
+			return k.Acquire(ctx, key, onQueued)
+		}
+	}
+
+	releaseA, err := k.Acquire(ctx, "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不同 key 互不影响
+	releaseB, err := k.Acquire(ctx, "b", func(int) { t.Error("key b should not be queued") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, acquired := acquireAsync(ctx, acquire("a"))
+	if position := waitQueued(t, queued); position != 1 {
+		t.Errorf("position = %d, want 1", position)
+	}
+
+	// 排队中取消的请求同样释放引用
+	cancelCtx, cancel := context.WithCancel(ctx)
+	queuedCancelled, acquiredCancelled := acquireAsync(cancelCtx, acquire("a"))
+	waitQueued(t, queuedCancelled)
+	cancel()
+	if _, ok := <-acquiredCancelled; ok {
+		t.Fatal("cancelled request acquired a slot")
+	}
+
+	releaseA()
+	releaseA()
+	release := waitAcquired(t, acquired)
+	releaseB()
+	release()
+
+	// 空闲的 key 不再保留限制器
+	k.mu.Lock()
+	n := len(k.limiters)
+	k.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d limiters left after release, want 0", n)
+	}
+}
+
+func TestKeyedLimiterSetLimit(t *testing.T) {
+	k := NewKeyedLimiter(1)
+	ctx := context.Background()
+	release1, err := k.Acquire(ctx, "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, acquired := acquireAsync(ctx, func(ctx context.Context, onQueued func(int)) (func(), error) {
+		return k.Acquire(ctx, "a", onQueued)
+	})
+	waitQueued(t, queued)
+
+	// 调整上限对已存在的 key 立即生效，之后创建的 key 使用新的上限
+	k.SetLimit(2)
+	release2 := waitAcquired(t, acquired)
+	release3, err := k.Acquire(ctx, "b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release4, err := k.Acquire(ctx, "b", func(int) { t.Error("key b should allow 2 concurrent requests") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, release := range []func(){release1, release2, release3, release4} {
+		release()
+	}
+}
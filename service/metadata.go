@@ -0,0 +1,71 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Metadata 写入输出文件的元数据
+type Metadata struct {
+	Title       string    // 标题
+	Artist      string    // UP 主昵称
+	Date        string    // 发布日期（YYYY-MM-DD）
+	Description string    // 视频简介
+	Comment     string    // 视频页面地址
+	CoverUrl    string    // 封面图片地址，为空或容器不支持时不嵌入封面
+	Chapters    []Chapter // 分段章节，为空时不写入章节
+}
+
+// Chapter 章节
+type Chapter struct {
+	Start time.Duration
+	End   time.Duration
+	Title string
+}
+
+// args 返回写入全局元数据的 FFmpeg 参数，空字段不写入
+func (m *Metadata) args() []string {
+	var args []string
+	for _, kv := range [][2]string{
+		{"title", m.Title},
+		{"artist", m.Artist},
+		{"date", m.Date},
+		{"description", m.Description},
+		{"comment", m.Comment},
+	} {
+		if kv[1] != "" {
+			args = append(args, "-metadata", kv[0]+"="+kv[1])
+		}
+	}
+	return args
+}
+
+// writeChapters 把章节写成 FFmetadata 文件，供 FFmpeg 通过 -map_chapters 读取
+// 参数 path: 输出文件路径
+// 参数 chapters: 章节列表
+// 返回：错误信息
+func writeChapters(path string, chapters []Chapter) error {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, ch := range chapters {
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			ch.Start.Milliseconds(), ch.End.Milliseconds(), escapeFFMetadata(ch.Title))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// ffmetadataEscaper FFmetadata 中需要转义的特殊字符
+var ffmetadataEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"=", `\=`,
+	";", `\;`,
+	"#", `\#`,
+	"\n", "\\\n",
+)
+
+// escapeFFMetadata 转义 FFmetadata 的值
+func escapeFFMetadata(s string) string {
+	return ffmetadataEscaper.Replace(s)
+}
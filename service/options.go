@@ -0,0 +1,43 @@
+package service
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ClientOptions 访问 Bilibili 使用的 HTTP 客户端配置
+type ClientOptions struct {
+	Cookie    string        // 用户 Cookie，用于身份验证
+	UserAgent string        // User-Agent，为空时使用 DefaultUserAgent
+	Timeout   time.Duration // 单次请求超时时间
+}
+
+// clientState 可在运行时热更新的 HTTP 客户端状态
+// 更新时创建新的 http.Client，进行中的请求继续使用旧客户端，不会被中断
+type clientState struct {
+	mu     sync.RWMutex
+	opts   ClientOptions
+	client *http.Client
+}
+
+// update 更新客户端配置
+func (c *clientState) update(opts ClientOptions) {
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts = opts
+	c.client = &http.Client{
+		Timeout: opts.Timeout,
+	}
+}
+
+// get 返回当前的客户端配置和 http.Client
+func (c *clientState) get() (ClientOptions, *http.Client) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.opts, c.client
+}
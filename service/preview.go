@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"bilibili-downloader-server/logging"
+	"bilibili-downloader-server/metrics"
+)
+
+// 动图预览参数的默认值和上限
+const (
+	DefaultPreviewWidth    = 480
+	MaxPreviewWidth        = 1280
+	DefaultPreviewFps      = 12
+	MaxPreviewFps          = 30
+	DefaultPreviewDuration = 5 * time.Second
+	MaxPreviewDuration     = 30 * time.Second
+)
+
+// PreviewFormat 动图预览格式
+type PreviewFormat struct {
+	Name        string // 格式名称，同时也是 format 参数的取值
+	Extension   string // 文件扩展名（不含点）
+	ContentType string // HTTP Content-Type
+	args        func(fps, width int) []string
+}
+
+// previewFormats 支持的动图格式
+var previewFormats = map[string]PreviewFormat{
+	// GIF 只有 256 色：先为整个片段生成调色板，再用调色板抖动，比默认调色板清晰得多
+	"gif": {Name: "gif", Extension: "gif", ContentType: "image/gif", args: func(fps, width int) []string {
+		return []string{
+			"-filter_complex", fmt.Sprintf("[0:v:0]fps=%d,scale=%d:-2:flags=lanczos,split[a][b];"+
+				"[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=bayer:bayer_scale=5:diff_mode=rectangle", fps, width),
+			"-loop", "0",
+		}
+	}},
+	// 动态 WebP 支持真彩色，有损压缩后通常比 GIF 小得多
+	"webp": {Name: "webp", Extension: "webp", ContentType: "image/webp", args: func(fps, width int) []string {
+		return []string{
+			"-map", "0:v:0",
+			"-vf", fmt.Sprintf("fps=%d,scale=%d:-2:flags=lanczos", fps, width),
+			"-c:v", "libwebp", "-lossless", "0", "-quality", "75", "-compression_level", "4",
+			"-loop", "0",
+		}
+	}},
+}
+
+// LookupPreviewFormat 根据名称查找动图格式
+// 参数 name: 格式名称（gif/webp）
+// 返回：动图格式和是否存在
+func LookupPreviewFormat(name string) (PreviewFormat, bool) {
+	f, ok := previewFormats[name]
+	return f, ok
+}
+
+// PreviewFormatNames 返回所有支持的动图格式名称（按字母排序）
+func PreviewFormatNames() []string {
+	names := make([]string, 0, len(previewFormats))
+	for name := range previewFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PreviewOptions 动图预览选项
+type PreviewOptions struct {
+	Format PreviewFormat // 输出格式
+	Width  int           // 输出宽度，高度等比缩放
+	Fps    int           // 帧率
+}
+
+// Preview 截取视频片段并生成动图，只下载视频轨道中覆盖时间范围的分段
+// 参数 ctx: 上下文，取消时中断下载和转换
+// 参数 videoUrl: DASH 视频流地址
+// 参数 bvid: 视频 BV 号，用于生成 Referer
+// 参数 clip: 时间范围和视频轨道的 sidx 索引位置
+// 参数 opts: 输出选项
+// 返回：动图内容和错误信息
+func (d *Downloader) Preview(ctx context.Context, videoUrl, bvid string, clip Clip, opts PreviewOptions) ([]byte, error) {
+	// 服务关闭时（Abort）同样取消下载和转换
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(d.baseCtx, cancel)
+	defer stop()
+
+	if err := d.checkDiskSpace(ctx); err != nil {
+		return nil, err
+	}
+	tempDir, err := d.newWorkDir()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temporary directory: %w", err)
+	}
+	defer d.removeWorkDir(tempDir)
+
+	logger := logging.FromContext(ctx).With("bvid", bvid, "format", opts.Format.Name)
+	videoPath := filepath.Join(tempDir, "video.mp4")
+	outputPath := filepath.Join(tempDir, "preview."+opts.Format.Extension)
+	referer := fmt.Sprintf("%s/video/%s/", VideoURL, bvid)
+
+	offset, err := d.downloadTrack(ctx, videoUrl, referer, videoPath, clip.VideoIndex, &clip)
+	if err != nil {
+		return nil, fmt.Errorf("Video download failed: %w", err)
+	}
+
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("FFmpeg not found, please ensure it is installed: %w", err)
+	}
+
+	// ffmpeg -y -use_tfdt 0 -ss <开始> -i video.mp4 <滤镜和编码参数> -t <时长> preview.<ext>
+	args := []string{"-y"}
+	args = append(args, clip.inputArgs(offset)...)
+	args = append(args, "-i", videoPath, "-an")
+	args = append(args, opts.Format.args(opts.Fps, opts.Width)...)
+	args = append(args, clip.outputArgs()...)
+	args = append(args, outputPath)
+
+	// 生成动图需要解码和编码，受转码并发数限制
+	release, err := d.transcodeLimiter.Acquire(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Waiting for FFmpeg slot cancelled: %w", err)
+	}
+	start := time.Now()
+	output, err := exec.CommandContext(ctx, ffmpegPath, args...).CombinedOutput()
+	release()
+	metrics.TranscodeDuration.WithLabelValues("preview-"+opts.Format.Name, metrics.Result(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		logger.Error("FFmpeg preview failed", "error", err)
+		return nil, fmt.Errorf("FFmpeg execution failed: %w, output: %s", err, string(output))
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read preview: %w", err)
+	}
+	logger.Info("Preview generated", "bytes", len(data), "duration_ms", time.Since(start).Milliseconds())
+	return data, nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 投稿列表分页参数
+const (
+	DefaultSpacePageSize = 30
+	MaxSpacePageSize     = 50
+)
+
+// SpaceVideo UP 主投稿列表中的一个视频
+type SpaceVideo struct {
+	Aid         int64  `json:"aid"`
+	Bvid        string `json:"bvid"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Pic         string `json:"pic"`
+	Author      string `json:"author"`
+	Mid         int64  `json:"mid"`
+	Created     int64  `json:"created"` // 发布时间（Unix 秒）
+	Length      string `json:"length"`  // 时长，如 "12:34"
+	Play        int64  `json:"play"`
+}
+
+// Duration 解析 "[时:]分:秒" 格式的时长，无法解析时返回 0
+func (v SpaceVideo) Duration() time.Duration {
+	var total int64
+	for _, part := range strings.Split(v.Length, ":") {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0
+		}
+		total = total*60 + n
+	}
+	return time.Duration(total) * time.Second
+}
+
+// SpaceVideoPage 投稿列表的一页
+type SpaceVideoPage struct {
+	Mid      int64        `json:"mid"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+	Total    int          `json:"total"`
+	Videos   []SpaceVideo `json:"videos"`
+}
+
+// spaceArcSearchResponse 投稿列表 API 响应
+type spaceArcSearchResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		List struct {
+			Vlist []SpaceVideo `json:"vlist"`
+		} `json:"list"`
+		Page struct {
+			Pn    int `json:"pn"`
+			Ps    int `json:"ps"`
+			Count int `json:"count"`
+		} `json:"page"`
+	} `json:"data"`
+}
+
+// GetSpaceVideos 获取 UP 主的投稿列表（按发布时间倒序）
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 mid: UP 主 ID
+// 参数 page: 页码（从 1 开始）
+// 参数 pageSize: 每页数量（1~50）
+// 返回：投稿列表的一页和错误信息
+func (s *ApiService) GetSpaceVideos(ctx context.Context, mid int64, page, pageSize int) (*SpaceVideoPage, error) {
+	// 接口会检查浏览器指纹参数，缺少时返回 -352 风控错误
+	apiUrl, err := s.signedUrl(ctx, SpaceArcSearchEndpoint, map[string]interface{}{
+		"mid":              mid,
+		"pn":               page,
+		"ps":               pageSize,
+		"order":            "pubdate",
+		"dm_img_list":      "[]",
+		"dm_img_str":       "V2ViR0wgMS4wIChPcGVuR0wgRVMgMi4wIENocm9taXVtKQ",
+		"dm_cover_img_str": "QU5HTEUgKEludGVsLCBJbnRlbChSKSBVSEQgR3JhcGhpY3MgNjMwLCBPcGVuR0wgNC42KUdvb2dsZSBJbmMuIChJbnRlbCk",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+
+	// 设置请求头，Referer 为 UP 主空间页面
+	s.setHeaders(req, fmt.Sprintf("%s/%d/video", SpaceURL, mid))
+
+	// 发送请求并解析 JSON 响应
+	var searchResp spaceArcSearchResponse
+	if err := s.getJSON(req, SpaceArcSearchEndpoint, &searchResp); err != nil {
+		return nil, err
+	}
+	data := searchResp.Data
+	return &SpaceVideoPage{
+		Mid:      mid,
+		Page:     data.Page.Pn,
+		PageSize: data.Page.Ps,
+		Total:    data.Page.Count,
+		Videos:   data.List.Vlist,
+	}, nil
+}
+
+// ListSpaceVideos 逐页获取 UP 主的全部投稿（按发布时间倒序）
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 mid: UP 主 ID
+// 参数 since: 只返回该时间之后发布的视频，零值表示全部
+// 返回：投稿列表和错误信息
+func (s *ApiService) ListSpaceVideos(ctx context.Context, mid int64, since time.Time) ([]SpaceVideo, error) {
+	var videos []SpaceVideo
+	for page := 1; ; page++ {
+		result, err := s.GetSpaceVideos(ctx, mid, page, MaxSpacePageSize)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get page %d: %w", page, err)
+		}
+		for _, v := range result.Videos {
+			// 列表按发布时间倒序，遇到更早的视频即可停止
+			if !since.IsZero() && v.Created < since.Unix() {
+				return videos, nil
+			}
+			videos = append(videos, v)
+		}
+		if len(result.Videos) == 0 || page*MaxSpacePageSize >= result.Total {
+			return videos, nil
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"bilibili-downloader-server/metrics"
+)
+
+// ErrSubtitleNotFound 视频没有所请求语言的字幕
+var ErrSubtitleNotFound = errors.New("Subtitle not found")
+
+// SubtitleTrack 字幕轨道信息
+type SubtitleTrack struct {
+	Id          int64  `json:"id"`
+	Lan         string `json:"lan"`     // 语言代码，如 zh-CN、en-US、ai-zh
+	LanDoc      string `json:"lan_doc"` // 语言名称，如 中文（中国）
+	SubtitleUrl string `json:"subtitle_url"`
+	Type        int    `json:"type"`    // 0 人工字幕，1 AI 字幕
+	AiType      int    `json:"ai_type"` // AI 字幕类型
+}
+
+// PlayerWbiResponse 播放器信息（WBI）API 响应
+type PlayerWbiResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Subtitle struct {
+			Subtitles []SubtitleTrack `json:"subtitles"`
+		} `json:"subtitle"`
+		Interaction *struct {
+			GraphVersion int64 `json:"graph_version"`
+		} `json:"interaction"` // 互动视频的剧情图信息，普通视频为 nil
+	} `json:"data"`
+}
+
+// BccLine BCC 字幕中的一行
+type BccLine struct {
+	From     float64 `json:"from"`     // 开始时间（秒）
+	To       float64 `json:"to"`       // 结束时间（秒）
+	Location int     `json:"location"` // 位置：2 底部，8 顶部
+	Content  string  `json:"content"`
+}
+
+// BccSubtitle Bilibili 的 BCC JSON 字幕
+type BccSubtitle struct {
+	Body []BccLine `json:"body"`
+}
+
+// SubtitleFormat 字幕输出格式
+type SubtitleFormat struct {
+	Name        string // 格式名称，同时也是 format 参数的取值
+	Extension   string // 文件扩展名（不含点）
+	ContentType string // HTTP Content-Type
+	convert     func(*BccSubtitle) []byte
+}
+
+// DefaultSubtitleFormat 默认字幕格式
+const DefaultSubtitleFormat = "srt"
+
+// subtitleFormats 支持的字幕格式
+var subtitleFormats = map[string]SubtitleFormat{
+	"srt": {Name: "srt", Extension: "srt", ContentType: "application/x-subrip; charset=utf-8", convert: toSRT},
+	"vtt": {Name: "vtt", Extension: "vtt", ContentType: "text/vtt; charset=utf-8", convert: toVTT},
+	"ass": {Name: "ass", Extension: "ass", ContentType: "text/x-ssa; charset=utf-8", convert: toASS},
+}
+
+// LookupSubtitleFormat 根据名称查找字幕格式
+// 参数 name: 格式名称（srt/vtt/ass）
+// 返回：字幕格式和是否存在
+func LookupSubtitleFormat(name string) (SubtitleFormat, bool) {
+	f, ok := subtitleFormats[name]
+	return f, ok
+}
+
+// SubtitleFormatNames 返回所有支持的字幕格式名称（按字母排序）
+func SubtitleFormatNames() []string {
+	names := make([]string, 0, len(subtitleFormats))
+	for name := range subtitleFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Convert 把 BCC 字幕转换为该格式
+// 负的时间按 0 处理，结束时间不晚于开始时间的行被丢弃，其余按开始时间排序（WebVTT 要求开始时间不递减）；
+// 时间重叠的行全部保留，由播放器同时显示
+func (f SubtitleFormat) Convert(bcc *BccSubtitle) []byte {
+	return f.convert(&BccSubtitle{Body: subtitleLines(bcc.Body)})
+}
+
+// subtitleLines 整理字幕行：负的时间按 0 处理，丢弃结束时间不晚于开始时间的行，按开始时间稳定排序
+func subtitleLines(body []BccLine) []BccLine {
+	lines := make([]BccLine, 0, len(body))
+	for _, line := range body {
+		line.From, line.To = max(line.From, 0), max(line.To, 0)
+		if subtitleTime(line.To) <= subtitleTime(line.From) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].From < lines[j].From })
+	return lines
+}
+
+// GetSubtitles 获取视频分 P 的字幕列表
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 bvid: 视频 BV 号
+// 参数 cid: 分 P 的 CID
+// 返回：字幕轨道列表（没有字幕时为空）和错误信息
+func (s *ApiService) GetSubtitles(ctx context.Context, bvid string, cid int64) ([]SubtitleTrack, error) {
+	playerResp, err := s.getPlayerInfo(ctx, bvid, cid)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := playerResp.Data.Subtitle.Subtitles
+	for i := range tracks {
+		// 字幕地址通常是省略协议的 //aisubtitle.hdslb.com/...
+		if strings.HasPrefix(tracks[i].SubtitleUrl, "//") {
+			tracks[i].SubtitleUrl = "https:" + tracks[i].SubtitleUrl
+		}
+	}
+	return tracks, nil
+}
+
+// getPlayerInfo 获取播放器信息（字幕列表、互动视频剧情图版本等）
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 bvid: 视频 BV 号
+// 参数 cid: 分 P 的 CID
+// 返回：播放器信息和错误信息
+func (s *ApiService) getPlayerInfo(ctx context.Context, bvid string, cid int64) (*PlayerWbiResponse, error) {
+	apiUrl, err := s.signedUrl(ctx, PlayerWbiEndpoint, map[string]interface{}{
+		"bvid": bvid,
+		"cid":  cid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+
+	// 设置请求头，Referer 需要包含 BV 号
+	s.setHeaders(req, fmt.Sprintf("%s/video/%s/", VideoURL, bvid))
+
+	// 发送请求并解析 JSON 响应
+	var playerResp PlayerWbiResponse
+	if err := s.getJSON(req, PlayerWbiEndpoint, &playerResp); err != nil {
+		return nil, err
+	}
+	return &playerResp, nil
+}
+
+// GetSubtitleBody 下载并解析 BCC 字幕内容
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 track: 字幕轨道
+// 返回：BCC 字幕和错误信息
+func (s *ApiService) GetSubtitleBody(ctx context.Context, track SubtitleTrack) (*BccSubtitle, error) {
+	if track.SubtitleUrl == "" {
+		return nil, fmt.Errorf("%w: %s has no subtitle URL", ErrSubtitleNotFound, track.Lan)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, track.SubtitleUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+	s.setHeaders(req, "")
+
+	_, client := s.client.get()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Subtitle request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Subtitle download failed, status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	metrics.CdnBytesTotal.Add(float64(len(body)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read subtitle: %w", err)
+	}
+
+	var bcc BccSubtitle
+	if err := json.Unmarshal(body, &bcc); err != nil {
+		return nil, fmt.Errorf("Failed to parse subtitle: %w", err)
+	}
+	return &bcc, nil
+}
+
+// FindSubtitle 按语言代码查找字幕轨道，不区分大小写
+// 参数 tracks: 字幕轨道列表
+// 参数 lan: 语言代码
+// 返回：字幕轨道和是否存在
+func FindSubtitle(tracks []SubtitleTrack, lan string) (SubtitleTrack, bool) {
+	for _, t := range tracks {
+		if strings.EqualFold(t.Lan, lan) {
+			return t, true
+		}
+	}
+	return SubtitleTrack{}, false
+}
+
+// SubtitleLanguage 把 Bilibili 的语言代码转换为 ISO 639-2 代码，用于容器中的字幕语言标记
+// 无法识别时返回 "und"
+func SubtitleLanguage(lan string) string {
+	lan = strings.TrimPrefix(strings.ToLower(lan), "ai-")
+	if i := strings.IndexAny(lan, "-_"); i >= 0 {
+		lan = lan[:i]
+	}
+	switch lan {
+	case "zh":
+		return "chi"
+	case "en":
+		return "eng"
+	case "ja":
+		return "jpn"
+	case "ko":
+		return "kor"
+	case "es":
+		return "spa"
+	case "fr":
+		return "fre"
+	case "de":
+		return "ger"
+	case "ru":
+		return "rus"
+	case "pt":
+		return "por"
+	case "ar":
+		return "ara"
+	case "th":
+		return "tha"
+	case "vi":
+		return "vie"
+	case "id":
+		return "ind"
+	default:
+		return "und"
+	}
+}
+
+// subtitleTime 把秒数转换为时间，避免浮点误差
+func subtitleTime(sec float64) time.Duration {
+	return time.Duration(sec*1000+0.5) * time.Millisecond
+}
+
+// formatTimestamp 格式化时间戳：时、分、秒以及 digits 位小数，sep 为秒和小数之间的分隔符，负的时间按 0 处理
+func formatTimestamp(d time.Duration, sep string, digits int, hourWidth int) string {
+	ms := max(d.Milliseconds(), 0)
+	h, m, s := ms/3600000, ms/60000%60, ms/1000%60
+	frac := ms % 1000
+	if digits == 2 {
+		frac /= 10
+	}
+	return fmt.Sprintf("%0*d:%02d:%02d%s%0*d", hourWidth, h, m, s, sep, digits, frac)
+}
+
+// toSRT 把 BCC 字幕转换为 SRT
+func toSRT(bcc *BccSubtitle) []byte {
+	var b strings.Builder
+	for i, line := range bcc.Body {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1,
+			formatTimestamp(subtitleTime(line.From), ",", 3, 2),
+			formatTimestamp(subtitleTime(line.To), ",", 3, 2),
+			line.Content)
+	}
+	return []byte(b.String())
+}
+
+// vttEscaper WebVTT 文本中需要转义的字符
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// toVTT 把 BCC 字幕转换为 WebVTT
+func toVTT(bcc *BccSubtitle) []byte {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, line := range bcc.Body {
+		settings := ""
+		if line.Location == 8 {
+			settings = " line:0"
+		}
+		fmt.Fprintf(&b, "%s --> %s%s\n%s\n\n",
+			formatTimestamp(subtitleTime(line.From), ".", 3, 2),
+			formatTimestamp(subtitleTime(line.To), ".", 3, 2),
+			settings,
+			vttEscaper.Replace(line.Content))
+	}
+	return []byte(b.String())
+}
+
+// assHeader ASS 字幕文件头，使用 1080p 画布
+const assHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 1920
+PlayResY: 1080
+WrapStyle: 0
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Noto Sans CJK SC,64,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,3,1,2,40,40,50,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+// assEscaper ASS 文本中需要转义的字符
+var assEscaper = strings.NewReplacer("\\", "\\\\", "{", "\\{", "}", "\\}", "\r\n", "\\N", "\n", "\\N")
+
+// toASS 把 BCC 字幕转换为 ASS
+func toASS(bcc *BccSubtitle) []byte {
+	var b strings.Builder
+	b.WriteString(assHeader)
+	for _, line := range bcc.Body {
+		text := assEscaper.Replace(line.Content)
+		if line.Location == 8 {
+			text = "{\\an8}" + text
+		}
+		fmt.Fprintf(&b, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n",
+			formatTimestamp(subtitleTime(line.From), ".", 2, 1),
+			formatTimestamp(subtitleTime(line.To), ".", 2, 1),
+			text)
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubtitleConvert(t *testing.T) {
+	tests := []struct {
+		name string
+		body []BccLine
+		srt  string
+		vtt  string
+		ass  string // Dialogue 行
+	}{
+		{
+			name: "basic",
+			body: []BccLine{
+				{From: 1.2, To: 3.456, Location: 2, Content: "第一行"},
+				{From: 3661.005, To: 3662.999, Location: 8, Content: "a < b & {c}\nline 2"},
+			},
+			srt: "1\n00:00:01,200 --> 00:00:03,456\n第一行\n\n" +
+				"2\n01:01:01,005 --> 01:01:02,999\na < b & {c}\nline 2\n\n",
+			vtt: "WEBVTT\n\n" +
+				"00:00:01.200 --> 00:00:03.456\n第一行\n\n" +
+				"01:01:01.005 --> 01:01:02.999 line:0\na &lt; b &amp; {c}\nline 2\n\n",
+			ass: "Dialogue: 0,0:00:01.20,0:00:03.45,Default,,0,0,0,,第一行\n" +
+				"Dialogue: 0,1:01:01.00,1:01:02.99,Default,,0,0,0,,{\\an8}a < b & \\{c\\}\\Nline 2\n",
+		},
+		{
+			// 浮点误差：0.1+0.2 秒不能被截断为 299 毫秒
+			name: "rounding",
+			body: []BccLine{{From: 0.1 + 0.2, To: 1.0000001, Content: "x"}},
+			srt:  "1\n00:00:00,300 --> 00:00:01,000\nx\n\n",
+			vtt:  "WEBVTT\n\n00:00:00.300 --> 00:00:01.000\nx\n\n",
+			ass:  "Dialogue: 0,0:00:00.30,0:00:01.00,Default,,0,0,0,,x\n",
+		},
+		{
+			// 负的开始时间按 0 处理；整行都在 0 之前或结束时间不晚于开始时间的行被丢弃，SRT 序号保持连续
+			name: "negative and empty",
+			body: []BccLine{
+				{From: -0.5, To: 1, Content: "clamped"},
+				{From: -3, To: -1, Content: "before start"},
+				{From: 2, To: 2, Content: "zero length"},
+				{From: 3, To: 2.5, Content: "reversed"},
+				{From: 4, To: 5, Content: "kept"},
+			},
+			srt: "1\n00:00:00,000 --> 00:00:01,000\nclamped\n\n" +
+				"2\n00:00:04,000 --> 00:00:05,000\nkept\n\n",
+			vtt: "WEBVTT\n\n" +
+				"00:00:00.000 --> 00:00:01.000\nclamped\n\n" +
+				"00:00:04.000 --> 00:00:05.000\nkept\n\n",
+			ass: "Dialogue: 0,0:00:00.00,0:00:01.00,Default,,0,0,0,,clamped\n" +
+				"Dialogue: 0,0:00:04.00,0:00:05.00,Default,,0,0,0,,kept\n",
+		},
+		{
+			// 时间重叠的行全部保留，按开始时间排序（WebVTT 要求开始时间不递减）
+			name: "overlapping and unordered",
+			body: []BccLine{
+				{From: 5, To: 8, Content: "later"},
+				{From: 1, To: 6, Content: "long"},
+				{From: 1, To: 2, Content: "same start"},
+			},
+			srt: "1\n00:00:01,000 --> 00:00:06,000\nlong\n\n" +
+				"2\n00:00:01,000 --> 00:00:02,000\nsame start\n\n" +
+				"3\n00:00:05,000 --> 00:00:08,000\nlater\n\n",
+			vtt: "WEBVTT\n\n" +
+				"00:00:01.000 --> 00:00:06.000\nlong\n\n" +
+				"00:00:01.000 --> 00:00:02.000\nsame start\n\n" +
+				"00:00:05.000 --> 00:00:08.000\nlater\n\n",
+			ass: "Dialogue: 0,0:00:01.00,0:00:06.00,Default,,0,0,0,,long\n" +
+				"Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,same start\n" +
+				"Dialogue: 0,0:00:05.00,0:00:08.00,Default,,0,0,0,,later\n",
+		},
+		{
+			name: "empty",
+			body: nil,
+			srt:  "",
+			vtt:  "WEBVTT\n\n",
+			ass:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bcc := &BccSubtitle{Body: tt.body}
+			for _, c := range []struct {
+				format string
+				want   string
+			}{{"srt", tt.srt}, {"vtt", tt.vtt}, {"ass", tt.ass}} {
+				f, ok := LookupSubtitleFormat(c.format)
+				if !ok {
+					t.Fatalf("format %s not found", c.format)
+				}
+				got := string(f.Convert(bcc))
+				if c.format == "ass" {
+					header, events, ok := strings.Cut(got, "Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+					if !ok || !strings.HasPrefix(header, "[Script Info]\n") {
+						t.Fatalf("unexpected ASS header:\n%s", got)
+					}
+					got = events
+				}
+				if got != c.want {
+					t.Errorf("%s =\n%q\nwant\n%q", c.format, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	tests := []struct {
+		d         time.Duration
+		sep       string
+		digits    int
+		hourWidth int
+		want      string
+	}{
+		{0, ",", 3, 2, "00:00:00,000"},
+		{90*time.Minute + 1500*time.Millisecond, ",", 3, 2, "01:30:01,500"},
+		{100*time.Hour + 10*time.Millisecond, ".", 3, 2, "100:00:00.010"},
+		{1999 * time.Millisecond, ".", 2, 1, "0:00:01.99"},
+		{-1500 * time.Millisecond, ".", 3, 2, "00:00:00.000"},
+	}
+	for _, tt := range tests {
+		if got := formatTimestamp(tt.d, tt.sep, tt.digits, tt.hourWidth); got != tt.want {
+			t.Errorf("formatTimestamp(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestSubtitleLanguage(t *testing.T) {
+	tests := map[string]string{
+		"zh-CN":   "chi",
+		"ai-zh":   "chi",
+		"zh-Hant": "chi",
+		"en-US":   "eng",
+		"ja":      "jpn",
+		"AI-EN":   "eng",
+		"xx":      "und",
+		"":        "und",
+	}
+	for lan, want := range tests {
+		if got := SubtitleLanguage(lan); got != want {
+			t.Errorf("SubtitleLanguage(%q) = %q, want %q", lan, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// TranscodeProfile 转码配置档，用于需要固定编码或分辨率的客户端
+type TranscodeProfile struct {
+	Name         string // 配置档名称，同时也是 profile 参数的取值
+	VideoCodec   string // FFmpeg 视频编码器，如 libx264
+	VideoProfile string // 视频编码 profile，如 baseline，为空不指定
+	Preset       string // 编码速度预设，如 veryfast，为空不指定
+	Height       int    // 输出最大高度，宽度按比例缩放，不会放大，0 表示保持原分辨率
+	VideoBitrate string // 视频码率，如 2500k，为空使用编码器默认值
+	PixelFormat  string // 像素格式，如 yuv420p，为空不指定
+	AudioCodec   string // FFmpeg 音频编码器，如 aac
+	AudioBitrate string // 音频码率，如 128k，为空使用编码器默认值
+}
+
+// PreciseClipProfile 返回精确截取片段时使用的转码配置档
+// 直接复制流只能从关键帧开始截取，精确截取需要重新编码，编码器按容器选择
+// 参数 format: 输出格式
+// 返回：转码配置档
+func PreciseClipProfile(format OutputFormat) TranscodeProfile {
+	if format.Name == "webm" {
+		return TranscodeProfile{Name: "precise", VideoCodec: "libvpx-vp9", PixelFormat: "yuv420p", AudioCodec: "libopus", AudioBitrate: "160k"}
+	}
+	return TranscodeProfile{Name: "precise", VideoCodec: "libx264", Preset: "veryfast", PixelFormat: "yuv420p", AudioCodec: "aac", AudioBitrate: "192k"}
+}
+
+// args 返回转码使用的 FFmpeg 编码参数
+// 视频参数只作用于第一条视频流（v:0），嵌入的封面图片不会被转码或缩放
+func (p TranscodeProfile) args() []string {
+	args := []string{"-c:v:0", p.VideoCodec}
+	if p.VideoProfile != "" {
+		args = append(args, "-profile:v:0", p.VideoProfile)
+	}
+	if p.Preset != "" {
+		args = append(args, "-preset:v:0", p.Preset)
+	}
+	if p.Height > 0 {
+		// 宽度取偶数，高度不超过原视频
+		args = append(args, "-filter:v:0", fmt.Sprintf("scale=-2:'min(%d,ih)'", p.Height))
+	}
+	if p.VideoBitrate != "" {
+		args = append(args, "-b:v:0", p.VideoBitrate)
+	}
+	if p.PixelFormat != "" {
+		args = append(args, "-pix_fmt:v:0", p.PixelFormat)
+	}
+	args = append(args, "-c:a", p.AudioCodec)
+	if p.AudioBitrate != "" {
+		args = append(args, "-b:a", p.AudioBitrate)
+	}
+	return args
+}
+
+// Fingerprint 返回包含所有编码参数的标识，用于生成缓存键
+// 配置档内容变化后指纹随之变化，旧的缓存不会被误用
+func (p TranscodeProfile) Fingerprint() string {
+	return p.Name + "|" + p.VideoCodec + "|" + p.VideoProfile + "|" + p.Preset + "|" +
+		strconv.Itoa(p.Height) + "|" + p.VideoBitrate + "|" + p.PixelFormat + "|" +
+		p.AudioCodec + "|" + p.AudioBitrate
+}
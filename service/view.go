@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// VideoOwner 视频 UP 主信息
+type VideoOwner struct {
+	Mid  int64  `json:"mid"`
+	Name string `json:"name"`
+	Face string `json:"face"`
+}
+
+// VideoInfo 视频详细信息（/x/web-interface/view）
+type VideoInfo struct {
+	Bvid     string     `json:"bvid"`
+	Aid      int64      `json:"aid"`
+	Title    string     `json:"title"`
+	Desc     string     `json:"desc"`
+	Pic      string     `json:"pic"`
+	Pubdate  int64      `json:"pubdate"`
+	Duration int        `json:"duration"`
+	Owner    VideoOwner `json:"owner"`
+	Pages    []CidInfo  `json:"pages"`
+	// UgcSeason 视频所属的合集，不属于合集时为 nil
+	UgcSeason *UgcSeason `json:"ugc_season,omitempty"`
+}
+
+// UgcSeason 视频详细信息中的合集，按小节分组列出全部视频
+type UgcSeason struct {
+	Id       int64           `json:"id"`
+	Title    string          `json:"title"`
+	Cover    string          `json:"cover"`
+	Mid      int64           `json:"mid"`
+	Intro    string          `json:"intro"`
+	EpCount  int             `json:"ep_count"`
+	Sections []SeasonSection `json:"sections"`
+}
+
+// SeasonSection 合集中的小节
+type SeasonSection struct {
+	Id       int64           `json:"id"`
+	Title    string          `json:"title"`
+	Episodes []SeasonEpisode `json:"episodes"`
+}
+
+// SeasonEpisode 合集中的一个视频
+type SeasonEpisode struct {
+	Id    int64  `json:"id"`
+	Aid   int64  `json:"aid"`
+	Cid   int64  `json:"cid"`
+	Title string `json:"title"`
+	Bvid  string `json:"bvid"`
+}
+
+// Episodes 按小节顺序返回合集中的全部视频
+func (s *UgcSeason) Episodes() []SeasonEpisode {
+	var episodes []SeasonEpisode
+	for _, section := range s.Sections {
+		episodes = append(episodes, section.Episodes...)
+	}
+	return episodes
+}
+
+// Page 根据页码查找分 P 信息
+// 参数 page: 分 P 页码（从 1 开始）
+// 返回：分 P 信息和是否存在
+func (v *VideoInfo) Page(page int) (CidInfo, bool) {
+	for _, p := range v.Pages {
+		if p.Page == page {
+			return p, true
+		}
+	}
+	return CidInfo{}, false
+}
+
+// ViewResponse 视频详细信息 API 响应
+type ViewResponse struct {
+	Code    int       `json:"code"`
+	Message string    `json:"message"`
+	Data    VideoInfo `json:"data"`
+}
+
+// ViewPoint 视频分段章节
+type ViewPoint struct {
+	Type    int    `json:"type"`
+	From    int    `json:"from"` // 开始时间（秒）
+	To      int    `json:"to"`   // 结束时间（秒）
+	Content string `json:"content"`
+	ImgUrl  string `json:"imgUrl"`
+}
+
+// PlayerResponse 播放器信息 API 响应
+type PlayerResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		ViewPoints []ViewPoint `json:"view_points"`
+	} `json:"data"`
+}
+
+// GetVideoInfo 获取视频详细信息（标题、简介、封面、UP 主、分 P 等）
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 bvid: 视频 BV 号
+// 返回：视频信息和错误信息
+func (s *ApiService) GetVideoInfo(ctx context.Context, bvid string) (*VideoInfo, error) {
+	apiUrl := fmt.Sprintf("%s%s?bvid=%s", BaseURL, ViewEndpoint, bvid)
+
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+
+	// 设置请求头
+	s.setHeaders(req, "")
+
+	// 发送请求并解析 JSON 响应
+	var viewResp ViewResponse
+	if err := s.getJSON(req, ViewEndpoint, &viewResp); err != nil {
+		return nil, err
+	}
+	return &viewResp.Data, nil
+}
+
+// GetViewPoints 获取视频分段章节，UP 主未设置章节时返回空列表
+// 参数 ctx: 上下文，取消时中断请求
+// 参数 bvid: 视频 BV 号
+// 参数 cid: 分 P 的 CID
+// 返回：分段章节列表和错误信息
+func (s *ApiService) GetViewPoints(ctx context.Context, bvid string, cid int64) ([]ViewPoint, error) {
+	apiUrl := fmt.Sprintf("%s%s?bvid=%s&cid=%d", BaseURL, PlayerEndpoint, bvid, cid)
+
+	req, err := http.NewRequest(http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req = EnsureContext(ctx, req)
+
+	// 设置请求头
+	s.setHeaders(req, fmt.Sprintf("%s/video/%s/", VideoURL, bvid))
+
+	// 发送请求并解析 JSON 响应
+	var playerResp PlayerResponse
+	if err := s.getJSON(req, PlayerEndpoint, &playerResp); err != nil {
+		return nil, err
+	}
+	return playerResp.Data.ViewPoints, nil
+}
@@ -0,0 +1,317 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// 下载记录的结果
+const (
+	OutcomeSuccess   = "success"
+	OutcomeFailed    = "failed"
+	OutcomeCancelled = "cancelled"
+)
+
+// 查询结果的分页限制
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// migrations 按顺序执行的建表语句，已执行的版本记录在 PRAGMA user_version 中
+var migrations = []string{
+	`CREATE TABLE downloads (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		bvid      TEXT    NOT NULL,
+		cid       INTEGER NOT NULL DEFAULT 0,
+		page      INTEGER NOT NULL DEFAULT 1,
+		quality   INTEGER NOT NULL DEFAULT 0,
+		codec     TEXT    NOT NULL DEFAULT '',
+		format    TEXT    NOT NULL DEFAULT '',
+		size      INTEGER NOT NULL DEFAULT 0,
+		checksum  TEXT    NOT NULL DEFAULT '',
+		path      TEXT    NOT NULL DEFAULT '',
+		requester TEXT    NOT NULL DEFAULT '',
+		source    TEXT    NOT NULL DEFAULT '',
+		cached    INTEGER NOT NULL DEFAULT 0,
+		outcome   TEXT    NOT NULL,
+		error     TEXT    NOT NULL DEFAULT '',
+		started   INTEGER NOT NULL,
+		finished  INTEGER NOT NULL
+	);
+	CREATE INDEX downloads_bvid ON downloads (bvid, page);
+	CREATE INDEX downloads_finished ON downloads (finished);
+	CREATE TABLE jobs (
+		id       TEXT    PRIMARY KEY,
+		source   TEXT    NOT NULL,
+		status   TEXT    NOT NULL,
+		created  INTEGER NOT NULL,
+		finished INTEGER NOT NULL DEFAULT 0,
+		data     TEXT    NOT NULL
+	);`,
+}
+
+// Store 基于 SQLite 的下载记录和任务存储，可以被多个 goroutine 同时使用
+type Store struct {
+	db *sql.DB
+}
+
+// Download 一次下载的记录，直接返回给客户端的下载没有 Path，保存到视频库的下载有 Path
+type Download struct {
+	Id        int64     `json:"id"`
+	Bvid      string    `json:"bvid"`
+	Cid       int64     `json:"cid"`
+	Page      int       `json:"page"`
+	Quality   int       `json:"quality"` // 实际下载的清晰度
+	Codec     string    `json:"codec,omitempty"`
+	Format    string    `json:"format"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum,omitempty"` // SHA-256，只在成功时记录
+	Path      string    `json:"path,omitempty"`     // 视频库目录中的文件名
+	Requester string    `json:"requester,omitempty"`
+	Source    string    `json:"source"` // request 或批量任务的来源，如 space:<mid>
+	Cached    bool      `json:"cached"` // 是否命中转码缓存
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+}
+
+// DownloadQuery 下载记录的查询条件，为空的字段不作为条件
+type DownloadQuery struct {
+	Bvid      string
+	Requester string
+	Outcome   string
+	Library   bool // 只查询保存到视频库的下载
+	Limit     int
+	Offset    int
+}
+
+// JobRecord 保存的批量任务，Data 为任务的 JSON
+type JobRecord struct {
+	Id       string
+	Source   string
+	Status   string
+	Created  time.Time
+	Finished time.Time // 未结束时为零值
+	Data     []byte
+}
+
+// Open 打开数据库文件，不存在时创建，并执行未执行过的建表语句
+// 参数 path: 数据库文件路径
+// 返回：Store 实例和错误信息
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("Failed to create database directory: %w", err)
+	}
+	// WAL 模式下读写互不阻塞，busy_timeout 让并发写入等待而不是直接失败
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database: %w", err)
+	}
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close 关闭数据库
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// migrate 执行未执行过的建表语句
+func (s *Store) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("Failed to read database version: %w", err)
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("Failed to migrate database: %w", err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to migrate database to version %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to migrate database to version %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("Failed to migrate database to version %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// AddDownload 添加一条下载记录
+func (s *Store) AddDownload(ctx context.Context, d Download) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO downloads
+		(bvid, cid, page, quality, codec, format, size, checksum, path, requester, source, cached, outcome, error, started, finished)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.Bvid, d.Cid, d.Page, d.Quality, d.Codec, d.Format, d.Size, d.Checksum, d.Path, d.Requester, d.Source,
+		d.Cached, d.Outcome, d.Error, d.Started.UnixMilli(), d.Finished.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("Failed to add download record: %w", err)
+	}
+	return nil
+}
+
+// Downloads 按完成时间倒序查询下载记录
+func (s *Store) Downloads(ctx context.Context, q DownloadQuery) ([]Download, error) {
+	var where []string
+	var args []interface{}
+	if q.Bvid != "" {
+		where, args = append(where, "bvid = ?"), append(args, q.Bvid)
+	}
+	if q.Requester != "" {
+		where, args = append(where, "requester = ?"), append(args, q.Requester)
+	}
+	if q.Outcome != "" {
+		where, args = append(where, "outcome = ?"), append(args, q.Outcome)
+	}
+	if q.Library {
+		where = append(where, "path != ''")
+	}
+	query := `SELECT id, bvid, cid, page, quality, codec, format, size, checksum, path, requester, source,
+		cached, outcome, error, started, finished FROM downloads`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY finished DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, clampLimit(q.Limit), max(q.Offset, 0))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query downloads: %w", err)
+	}
+	defer rows.Close()
+
+	downloads := []Download{}
+	for rows.Next() {
+		var d Download
+		var started, finished int64
+		if err := rows.Scan(&d.Id, &d.Bvid, &d.Cid, &d.Page, &d.Quality, &d.Codec, &d.Format, &d.Size, &d.Checksum,
+			&d.Path, &d.Requester, &d.Source, &d.Cached, &d.Outcome, &d.Error, &started, &finished); err != nil {
+			return nil, fmt.Errorf("Failed to query downloads: %w", err)
+		}
+		d.Started, d.Finished = time.UnixMilli(started), time.UnixMilli(finished)
+		downloads = append(downloads, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to query downloads: %w", err)
+	}
+	return downloads, nil
+}
+
+// InLibrary 判断视频是否已经成功保存到视频库
+func (s *Store) InLibrary(ctx context.Context, bvid string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM downloads WHERE bvid = ? AND path != '' AND outcome = ?",
+		bvid, OutcomeSuccess).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("Failed to query downloads: %w", err)
+	}
+	return n > 0, nil
+}
+
+// LibraryFiles 返回视频每个分 P 最近一次成功保存到视频库的文件名，按分 P 页码索引
+func (s *Store) LibraryFiles(ctx context.Context, bvid string) (map[int]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT page, path FROM downloads WHERE bvid = ? AND path != '' AND outcome = ?
+		ORDER BY finished, id`, bvid, OutcomeSuccess)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query downloads: %w", err)
+	}
+	defer rows.Close()
+
+	files := map[int]string{}
+	for rows.Next() {
+		var page int
+		var path string
+		if err := rows.Scan(&page, &path); err != nil {
+			return nil, fmt.Errorf("Failed to query downloads: %w", err)
+		}
+		files[page] = path
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to query downloads: %w", err)
+	}
+	return files, nil
+}
+
+// SaveJob 保存批量任务，已存在时覆盖
+func (s *Store) SaveJob(ctx context.Context, j JobRecord) error {
+	var finished int64
+	if !j.Finished.IsZero() {
+		finished = j.Finished.UnixMilli()
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO jobs (id, source, status, created, finished, data) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, finished = excluded.finished, data = excluded.data`,
+		j.Id, j.Source, j.Status, j.Created.UnixMilli(), finished, string(j.Data))
+	if err != nil {
+		return fmt.Errorf("Failed to save job: %w", err)
+	}
+	return nil
+}
+
+// PruneJobs 删除较早的已结束任务，只保留最近创建的 keep 个已结束任务，未结束的任务不受影响
+// 返回：删除的任务数和错误信息
+func (s *Store) PruneJobs(ctx context.Context, keep int) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM jobs WHERE finished > 0 AND id NOT IN
+		(SELECT id FROM jobs WHERE finished > 0 ORDER BY created DESC, id DESC LIMIT ?)`, max(keep, 0))
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prune jobs: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prune jobs: %w", err)
+	}
+	return n, nil
+}
+
+// Jobs 按创建时间倒序返回最近的 limit 个批量任务
+func (s *Store) Jobs(ctx context.Context, limit int) ([]JobRecord, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, source, status, created, finished, data FROM jobs ORDER BY created DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []JobRecord
+	for rows.Next() {
+		var j JobRecord
+		var created, finished int64
+		var data string
+		if err := rows.Scan(&j.Id, &j.Source, &j.Status, &created, &finished, &data); err != nil {
+			return nil, fmt.Errorf("Failed to query jobs: %w", err)
+		}
+		j.Created, j.Data = time.UnixMilli(created), []byte(data)
+		if finished > 0 {
+			j.Finished = time.UnixMilli(finished)
+		}
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to query jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// clampLimit 把查询数量限制在 1~MaxLimit 之间，未指定时使用 DefaultLimit
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultLimit
+	}
+	return min(limit, MaxLimit)
+}
@@ -0,0 +1,259 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openTest 在临时目录中打开数据库，测试结束时关闭
+func openTest(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "data", "library.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "dir", "library.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+	if err := s.AddDownload(context.Background(), Download{Bvid: "BV1", Outcome: OutcomeSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// 重新打开时不再执行已执行过的建表语句，已有数据保留
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	downloads, err := s.Downloads(context.Background(), DownloadQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(downloads) != 1 {
+		t.Errorf("downloads after reopen = %d, want 1", len(downloads))
+	}
+}
+
+func TestDownloads(t *testing.T) {
+	s := openTest(t)
+	ctx := context.Background()
+	base := time.UnixMilli(1700000000000)
+	records := []Download{
+		{Bvid: "BV1", Page: 1, Requester: "key:a", Outcome: OutcomeSuccess, Path: "a.mp4", Size: 10, Cached: true},
+		{Bvid: "BV1", Page: 2, Requester: "key:b", Outcome: OutcomeFailed, Error: "boom"},
+		{Bvid: "BV2", Page: 1, Requester: "key:a", Outcome: OutcomeSuccess},
+		{Bvid: "BV2", Page: 1, Requester: "ip:192.0.2.1", Outcome: OutcomeCancelled},
+		{Bvid: "BV3", Page: 1, Requester: "key:a", Outcome: OutcomeSuccess, Path: "c.mp4"},
+	}
+	for i, d := range records {
+		d.Started = base.Add(time.Duration(i) * time.Minute)
+		d.Finished = d.Started.Add(time.Second)
+		if err := s.AddDownload(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 完成时间相同的记录按 ID 倒序排列
+	if err := s.AddDownload(ctx, Download{Bvid: "BV3", Page: 2, Outcome: OutcomeSuccess,
+		Started: base, Finished: base.Add(4*time.Minute + time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		q    DownloadQuery
+		want []int64 // 按返回顺序排列的 ID
+	}{
+		{"all", DownloadQuery{}, []int64{6, 5, 4, 3, 2, 1}},
+		{"bvid", DownloadQuery{Bvid: "BV1"}, []int64{2, 1}},
+		{"requester", DownloadQuery{Requester: "key:a"}, []int64{5, 3, 1}},
+		{"outcome", DownloadQuery{Outcome: OutcomeSuccess}, []int64{6, 5, 3, 1}},
+		{"library", DownloadQuery{Library: true}, []int64{5, 1}},
+		{"combined", DownloadQuery{Requester: "key:a", Outcome: OutcomeSuccess, Library: true}, []int64{5, 1}},
+		{"no match", DownloadQuery{Bvid: "BV9"}, []int64{}},
+		{"limit", DownloadQuery{Limit: 2}, []int64{6, 5}},
+		{"offset", DownloadQuery{Limit: 2, Offset: 2}, []int64{4, 3}},
+		{"offset past end", DownloadQuery{Offset: 10}, []int64{}},
+		{"negative offset", DownloadQuery{Limit: 1, Offset: -1}, []int64{6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			downloads, err := s.Downloads(ctx, tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []int64{}
+			for _, d := range downloads {
+				ids = append(ids, d.Id)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("ids = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	// 字段原样读出
+	downloads, err := s.Downloads(ctx, DownloadQuery{Bvid: "BV1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := downloads[1]
+	if got.Id != 1 || got.Bvid != "BV1" || got.Path != "a.mp4" || got.Size != 10 || !got.Cached ||
+		!got.Started.Equal(base) || !got.Finished.Equal(base.Add(time.Second)) {
+		t.Errorf("download = %+v", got)
+	}
+	if downloads[0].Error != "boom" {
+		t.Errorf("error = %q, want boom", downloads[0].Error)
+	}
+}
+
+func TestClampLimit(t *testing.T) {
+	tests := map[int]int{-1: DefaultLimit, 0: DefaultLimit, 1: 1, MaxLimit: MaxLimit, MaxLimit + 1: MaxLimit}
+	for in, want := range tests {
+		if got := clampLimit(in); got != want {
+			t.Errorf("clampLimit(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestLibraryFiles(t *testing.T) {
+	s := openTest(t)
+	ctx := context.Background()
+	base := time.UnixMilli(1700000000000)
+	add := func(page int, path, outcome string, finished time.Duration) {
+		t.Helper()
+		if err := s.AddDownload(ctx, Download{Bvid: "BV1", Page: page, Path: path, Outcome: outcome,
+			Started: base, Finished: base.Add(finished)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 插入顺序和完成时间顺序不同：以完成时间最晚的成功记录为准
+	add(1, "new.mp4", OutcomeSuccess, 3*time.Minute)
+	add(1, "old.mp4", OutcomeSuccess, time.Minute)
+	add(1, "failed.mp4", OutcomeFailed, 4*time.Minute)
+	add(1, "", OutcomeSuccess, 5*time.Minute)
+	add(2, "p2.mp4", OutcomeSuccess, 2*time.Minute)
+	add(3, "p3.mp4", OutcomeCancelled, 2*time.Minute)
+
+	files, err := s.LibraryFiles(ctx, "BV1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1: "new.mp4", 2: "p2.mp4"}
+	if !maps.Equal(files, want) {
+		t.Errorf("LibraryFiles = %v, want %v", files, want)
+	}
+
+	if ok, err := s.InLibrary(ctx, "BV1"); err != nil || !ok {
+		t.Errorf("InLibrary(BV1) = %v, %v; want true", ok, err)
+	}
+	if err := s.AddDownload(ctx, Download{Bvid: "BV2", Outcome: OutcomeSuccess, Started: base, Finished: base}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.InLibrary(ctx, "BV2"); err != nil || ok {
+		t.Errorf("InLibrary(BV2) = %v, %v; want false for a download without path", ok, err)
+	}
+	if files, err := s.LibraryFiles(ctx, "BV2"); err != nil || len(files) != 0 {
+		t.Errorf("LibraryFiles(BV2) = %v, %v; want empty", files, err)
+	}
+}
+
+func TestSaveJob(t *testing.T) {
+	s := openTest(t)
+	ctx := context.Background()
+	created := time.UnixMilli(1700000000000)
+
+	job := JobRecord{Id: "j1", Source: "space:1", Status: "running", Created: created, Data: []byte(`{"v":1}`)}
+	if err := s.SaveJob(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	// 再次保存时更新状态、结束时间和内容，来源和创建时间不变
+	finished := created.Add(time.Minute)
+	if err := s.SaveJob(ctx, JobRecord{Id: "j1", Source: "changed", Status: "completed", Created: created.Add(time.Hour),
+		Finished: finished, Data: []byte(`{"v":2}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveJob(ctx, JobRecord{Id: "j2", Source: "fav:1", Status: "queued", Created: created.Add(time.Second), Data: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := s.Jobs(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].Id != "j2" || jobs[1].Id != "j1" {
+		t.Fatalf("jobs = %+v, want j2, j1", jobs)
+	}
+	got := jobs[1]
+	if got.Source != "space:1" || got.Status != "completed" || !got.Created.Equal(created) ||
+		!got.Finished.Equal(finished) || string(got.Data) != `{"v":2}` {
+		t.Errorf("upserted job = %+v", got)
+	}
+	if !jobs[0].Finished.IsZero() {
+		t.Errorf("unfinished job has finished time %s", jobs[0].Finished)
+	}
+
+	if jobs, err := s.Jobs(ctx, 1); err != nil || len(jobs) != 1 || jobs[0].Id != "j2" {
+		t.Errorf("Jobs(1) = %+v, %v; want j2", jobs, err)
+	}
+}
+
+func TestPruneJobs(t *testing.T) {
+	s := openTest(t)
+	ctx := context.Background()
+	created := time.UnixMilli(1700000000000)
+	for i := 1; i <= 6; i++ {
+		job := JobRecord{Id: fmt.Sprintf("j%d", i), Source: "space:1", Status: "completed",
+			Created: created.Add(time.Duration(i) * time.Minute), Data: []byte(`{}`)}
+		// j2 和 j5 还没有结束
+		if i != 2 && i != 5 {
+			job.Finished = job.Created.Add(time.Second)
+		}
+		if err := s.SaveJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := s.PruneJobs(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("pruned %d jobs, want 2", n)
+	}
+	jobs, err := s.Jobs(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, j := range jobs {
+		ids = append(ids, j.Id)
+	}
+	// 保留最近的两个已结束任务和全部未结束的任务
+	if want := []string{"j6", "j5", "j4", "j2"}; !slices.Equal(ids, want) {
+		t.Errorf("jobs = %v, want %v", ids, want)
+	}
+
+	if n, err := s.PruneJobs(ctx, 2); err != nil || n != 0 {
+		t.Errorf("second prune = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FilenameFields 文件名模板支持的占位符
+var FilenameFields = []string{
+	"title",       // 视频标题
+	"part",        // 分 P 标题
+	"uploader",    // UP 主昵称
+	"bvid",        // BV 号
+	"aid",         // AV 号
+	"page",        // 分 P 页码
+	"page_suffix", // 多 P 视频为 " P<页码>"，单 P 视频为空
+	"clip_suffix", // 截取片段时为 " [<开始秒数>-<结束秒数>]"，完整视频为空
+	"quality",     // 实际下载的清晰度代码
+	"date",        // 发布日期（YYYY-MM-DD）
+	"format",      // 输出格式名称
+	"ext",         // 文件扩展名（不含点）
+}
+
+// MaxFilenameBytes 文件名最大字节数，大多数文件系统限制为 255 字节
+const MaxFilenameBytes = 240
+
+// placeholderPattern 匹配 {name} 形式的占位符
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// ValidateFilenameTemplate 校验文件名模板只包含支持的占位符
+// 参数 tmpl: 文件名模板，如 "{title} [{bvid}].{ext}"
+// 返回：错误信息
+func ValidateFilenameTemplate(tmpl string) error {
+	if strings.TrimSpace(tmpl) == "" {
+		return fmt.Errorf("Filename template is empty")
+	}
+	for _, m := range placeholderPattern.FindAllStringSubmatch(tmpl, -1) {
+		if !isFilenameField(m[1]) {
+			return fmt.Errorf("Unknown filename placeholder {%s}, expected one of: %s", m[1], strings.Join(FilenameFields, ", "))
+		}
+	}
+	return nil
+}
+
+// isFilenameField 判断是否是支持的占位符
+func isFilenameField(name string) bool {
+	for _, f := range FilenameFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderFilename 用字段值替换模板中的占位符，并清理文件名中不安全的字符
+// 参数 tmpl: 文件名模板
+// 参数 values: 占位符对应的值，缺失的占位符替换为空字符串
+// 返回：清理后的文件名，超长时截断扩展名之前的部分
+func RenderFilename(tmpl string, values map[string]string) string {
+	name := placeholderPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		return values[m[1:len(m)-1]]
+	})
+	return truncateFilename(SanitizeFilename(name), values["ext"])
+}
+
+// unsafeFilenameChars 路径分隔符和 Windows 文件名中不允许的字符
+const unsafeFilenameChars = `/\:*?"<>|`
+
+// SanitizeFilename 清理文件名：替换路径分隔符、Windows 保留字符和控制字符，
+// 合并连续空白，去掉首尾的空格和点
+func SanitizeFilename(name string) string {
+	var b strings.Builder
+	lastSpace := false
+	for _, r := range name {
+		switch {
+		case strings.ContainsRune(unsafeFilenameChars, r):
+			b.WriteRune('_')
+			lastSpace = false
+		case unicode.IsControl(r) || unicode.IsSpace(r):
+			if !lastSpace {
+				b.WriteRune(' ')
+			}
+			lastSpace = true
+		default:
+			b.WriteRune(r)
+			lastSpace = false
+		}
+	}
+	return strings.Trim(b.String(), " .")
+}
+
+// truncateFilename 文件名超过 MaxFilenameBytes 时截断扩展名之前的部分，不会截断多字节字符
+func truncateFilename(name, ext string) string {
+	if len(name) <= MaxFilenameBytes {
+		return name
+	}
+	suffix := ""
+	if ext != "" && strings.HasSuffix(name, "."+ext) {
+		suffix = "." + ext
+		name = strings.TrimSuffix(name, suffix)
+	}
+	limit := MaxFilenameBytes - len(suffix)
+	for limit > 0 && !utf8.RuneStart(name[limit]) {
+		limit--
+	}
+	return strings.TrimRight(name[:limit], " .") + suffix
+}
+
+// ContentDisposition 生成 attachment 类型的 Content-Disposition 头
+// 同时提供 ASCII 的 filename 和 RFC 5987 编码的 filename*，支持中文文件名
+// 参数 filename: 文件名（已清理）
+// 参数 fallback: 文件名包含非 ASCII 字符时，供旧客户端使用的 ASCII 文件名
+// 返回：Content-Disposition 头的值
+func ContentDisposition(filename, fallback string) string {
+	return contentDisposition("attachment", filename, fallback)
+}
+
+// InlineContentDisposition 生成 inline 类型的 Content-Disposition 头，浏览器直接显示内容，另存为时使用该文件名
+// 参数和编码方式同 ContentDisposition
+func InlineContentDisposition(filename, fallback string) string {
+	return contentDisposition("inline", filename, fallback)
+}
+
+// contentDisposition 生成指定类型的 Content-Disposition 头
+func contentDisposition(disposition, filename, fallback string) string {
+	if isASCII(filename) {
+		return fmt.Sprintf(`%s; filename="%s"`, disposition, filename)
+	}
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback, encodeRFC5987(filename))
+}
+
+// isASCII 判断字符串是否只包含可打印 ASCII 字符
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeRFC5987 按 RFC 5987 的 attr-char 规则对 UTF-8 字节做百分号编码
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"mime"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRenderFilename(t *testing.T) {
+	values := map[string]string{
+		"title":       "标题 / 第一集",
+		"part":        "P1",
+		"uploader":    "UP主",
+		"bvid":        "BV1xx411c7mD",
+		"page_suffix": " P2",
+		"quality":     "80",
+		"ext":         "mp4",
+	}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"{title} [{bvid}].{ext}", "标题 _ 第一集 [BV1xx411c7mD].mp4"},
+		{"{uploader} - {title}{page_suffix} [{quality}].{ext}", "UP主 - 标题 _ 第一集 P2 [80].mp4"},
+		{"{bvid}{clip_suffix}.{ext}", "BV1xx411c7mD.mp4"},
+		{"{unknown}{bvid}.{ext}", "BV1xx411c7mD.mp4"},
+		{"  ..{title}:\t\n{part}..  ", "标题 _ 第一集_ P1"},
+		{"a\\b*c?d\"e<f>g|h", "a_b_c_d_e_f_g_h"},
+	}
+	for _, tt := range tests {
+		if got := RenderFilename(tt.tmpl, values); got != tt.want {
+			t.Errorf("RenderFilename(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestValidateFilenameTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		ok   bool
+	}{
+		{"{title} [{bvid}].{ext}", true},
+		{"{uploader}/{date} {title}{page_suffix}{clip_suffix}.{ext}", true},
+		{"static.mp4", true},
+		{"", false},
+		{"   ", false},
+		{"{title} {resolution}.{ext}", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateFilenameTemplate(tt.tmpl); (err == nil) != tt.ok {
+			t.Errorf("ValidateFilenameTemplate(%q) = %v, want ok %v", tt.tmpl, err, tt.ok)
+		}
+	}
+}
+
+func TestTruncateFilename(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		ext     string
+		want    string
+		wantLen int
+	}{
+		{"short", "标题.mp4", "mp4", "标题.mp4", len("标题.mp4")},
+		{"exactly the limit", strings.Repeat("a", MaxFilenameBytes-4) + ".mp4", "mp4", strings.Repeat("a", MaxFilenameBytes-4) + ".mp4", MaxFilenameBytes},
+		{"ascii", strings.Repeat("a", 300) + ".mp4", "mp4", strings.Repeat("a", MaxFilenameBytes-4) + ".mp4", MaxFilenameBytes},
+		// 3 字节的汉字：236 字节处是字符中间，退回到 234 字节
+		{"three-byte runes", strings.Repeat("中", 100) + ".mp4", "mp4", strings.Repeat("中", 78) + ".mp4", 238},
+		// 4 字节的 emoji 加 1 字节前缀：236 字节处是字符中间，退回到 233 字节
+		{"four-byte runes", "a" + strings.Repeat("😀", 80) + ".mkv", "mkv", "a" + strings.Repeat("😀", 58) + ".mkv", 237},
+		{"trailing space and dot", strings.Repeat("a", 234) + " . " + strings.Repeat("b", 10) + ".mp4", "mp4", strings.Repeat("a", 234) + ".mp4", 238},
+		{"extension not in name", strings.Repeat("中", 100), "mp4", strings.Repeat("中", 80), MaxFilenameBytes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateFilename(tt.in, tt.ext)
+			if got != tt.want {
+				t.Errorf("truncateFilename = %q, want %q", got, tt.want)
+			}
+			if len(got) != tt.wantLen || len(got) > MaxFilenameBytes {
+				t.Errorf("length = %d, want %d", len(got), tt.wantLen)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("result is not valid UTF-8: %q", got)
+			}
+		})
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		filename string
+		fallback string
+		want     string
+	}{
+		{"video [BV1xx411c7mD].mp4", "unused.mp4", `attachment; filename="video [BV1xx411c7mD].mp4"`},
+		{"标题.mp4", "BV1xx411c7mD.mp4", `attachment; filename="BV1xx411c7mD.mp4"; filename*=UTF-8''%E6%A0%87%E9%A2%98.mp4`},
+		// attr-char 之外的 ASCII 字符（空格、括号、单引号、百分号）同样需要编码
+		{"视频 (1) 'a' 100%.mp4", "BV1.mp4", `attachment; filename="BV1.mp4"; filename*=UTF-8''%E8%A7%86%E9%A2%91%20%281%29%20%27a%27%20100%25.mp4`},
+		{"a\tb.mp4", "BV1.mp4", `attachment; filename="BV1.mp4"; filename*=UTF-8''a%09b.mp4`},
+		{"!#$&+-.^_`|~.mp4", "BV1.mp4", "attachment; filename=\"!#$&+-.^_`|~.mp4\""},
+	}
+	for _, tt := range tests {
+		got := ContentDisposition(tt.filename, tt.fallback)
+		if got != tt.want {
+			t.Errorf("ContentDisposition(%q) = %s, want %s", tt.filename, got, tt.want)
+			continue
+		}
+
+		// 标准库按 RFC 2231/5987 解析后优先使用 filename*，得到原始文件名
+		_, params, err := mime.ParseMediaType(got)
+		if err != nil {
+			t.Errorf("ParseMediaType(%s): %v", got, err)
+			continue
+		}
+		if params["filename"] != tt.filename {
+			t.Errorf("parsed filename = %q, want %q", params["filename"], tt.filename)
+		}
+	}
+}
+
+func TestInlineContentDisposition(t *testing.T) {
+	tests := []struct {
+		filename string
+		fallback string
+		want     string
+	}{
+		{"BV1xx411c7mD_p2.jpg", "unused.jpg", `inline; filename="BV1xx411c7mD_p2.jpg"`},
+		{"封面.jpg", "BV1xx411c7mD.jpg", `inline; filename="BV1xx411c7mD.jpg"; filename*=UTF-8''%E5%B0%81%E9%9D%A2.jpg`},
+	}
+	for _, tt := range tests {
+		got := InlineContentDisposition(tt.filename, tt.fallback)
+		if got != tt.want {
+			t.Errorf("InlineContentDisposition(%q) = %s, want %s", tt.filename, got, tt.want)
+			continue
+		}
+		disposition, params, err := mime.ParseMediaType(got)
+		if err != nil || disposition != "inline" || params["filename"] != tt.filename {
+			t.Errorf("ParseMediaType(%s) = %q, %q, %v", got, disposition, params["filename"], err)
+		}
+	}
+}
+
+func TestEncodeRFC5987(t *testing.T) {
+	s := "中文 名称-1.mp4"
+	encoded := encodeRFC5987(s)
+	if decoded, err := url.PathUnescape(encoded); err != nil || decoded != s {
+		t.Errorf("encodeRFC5987(%q) = %q, decodes to %q, %v", s, encoded, decoded, err)
+	}
+}